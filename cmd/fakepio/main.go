@@ -0,0 +1,59 @@
+// fakepio 是一个离线的PioSolver替身进程，通过标准输入输出实现UPI协议。
+//
+// 用法:
+//
+//	fakepio [-tree fixture.json] [-tick 50ms] [-crash-after N] [-fail "show_strategy r:0"]
+//
+// 也可以通过环境变量 FAKEPIO_TREE / FAKEPIO_TICK / FAKEPIO_CRASH_AFTER / FAKEPIO_FAIL 配置，便于被upi.Client直接以无参数方式启动。
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"piodatasolver/internal/fakepio"
+)
+
+func main() {
+	treePath := flag.String("tree", os.Getenv("FAKEPIO_TREE"), "启动时预加载的fixture文件")
+	tick := flag.Duration("tick", 50*time.Millisecond, "模拟求解每次迭代的间隔")
+	crashAfter := flag.Int("crash-after", 0, "处理N条命令后模拟崩溃退出（测试崩溃恢复用），0表示不崩溃")
+	failCommand := flag.String("fail", os.Getenv("FAKEPIO_FAIL"), "第一次收到该命令时应答ERROR（测试查询失败用）")
+	flag.Parse()
+
+	if v := os.Getenv("FAKEPIO_TICK"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			*tick = d
+		}
+	}
+
+	if v := os.Getenv("FAKEPIO_CRASH_AFTER"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			*crashAfter = n
+		}
+	}
+
+	var tree *fakepio.Tree
+	if *treePath != "" {
+		var err error
+		tree, err = fakepio.LoadTree(*treePath)
+		if err != nil {
+			log.Fatalf("加载fixture失败: %v", err)
+		}
+	}
+
+	server := fakepio.NewServer(tree)
+	server.SetSolveTick(*tick)
+	server.SetCrashAfter(*crashAfter)
+	server.SetFailCommand(*failCommand)
+	if err := server.Serve(os.Stdin, os.Stdout); err != nil {
+		if errors.Is(err, fakepio.ErrCrashed) {
+			os.Exit(3)
+		}
+		log.Fatalf("处理UPI命令失败: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+// upibridge 把本机的PioSolver暴露到TCP上，让其他机器上的piodatasolver通过 PIO_SOLVER_ADDR 远程驱动。
+//
+// 用法:
+//
+//	upibridge [-listen :7788] [-exe PioSOLVER3-edge.exe] [-dir 工作目录] [-max 1]
+//
+// 每个连接启动一个新的PioSolver进程，连接断开时进程被终止。
+// 桥接服务没有鉴权，只应在可信的内网中使用。
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"piodatasolver/internal/upi"
+)
+
+func main() {
+	listen := flag.String("listen", ":7788", "监听地址")
+	exePath := flag.String("exe", "./PioSOLVER3-edge.exe", "PioSolver可执行文件路径")
+	workDir := flag.String("dir", ".", "PioSolver工作目录")
+	maxSessions := flag.Int("max", 1, "同时运行的PioSolver进程上限，0表示不限制")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("监听 %s 失败: %v", *listen, err)
+	}
+	log.Printf("upibridge 监听 %s，PioSolver: %s (工作目录 %s)，会话上限 %d", ln.Addr(), *exePath, *workDir, *maxSessions)
+
+	bridge := &upi.Bridge{ExePath: *exePath, WorkingDir: *workDir, MaxSessions: *maxSessions}
+	if err := bridge.Serve(ctx, ln); err != nil {
+		log.Fatalf("桥接服务出错: %v", err)
+	}
+	log.Printf("upibridge 已停止")
+}
@@ -4,7 +4,6 @@ go 1.21.0
 
 toolchain go1.24.2
 
-require (
-	filippo.io/edwards25519 v1.1.0 // indirect
-	github.com/go-sql-driver/mysql v1.9.2 // indirect
-)
+require github.com/go-sql-driver/mysql v1.9.2
+
+require filippo.io/edwards25519 v1.1.0 // indirect
@@ -1,9 +1,7 @@
 package cache
 
 import (
-	"strings"
 	"sync"
-	"time"
 
 	"piodatasolver/internal/upi"
 )
@@ -18,12 +16,12 @@ type HandOrder struct {
 // Init 会自动调用 UPI 客户端拉取 hand order，只执行一次
 func (h *HandOrder) Init(client *upi.Client) error {
 	h.once.Do(func() {
-		lines, err := client.ExecuteCommand("show_hand_order", 10*time.Second)
+		hands, err := client.ShowHandOrder()
 		if err != nil {
 			h.err = err
 			return
 		}
-		h.order = strings.Fields(lines[0]) // 一行 1326 手牌
+		h.order = hands // 一行 1326 手牌
 		h.idx = make(map[string]int, len(h.order))
 		for i, hand := range h.order {
 			h.idx[hand] = i
@@ -0,0 +1,21 @@
+package fakepio
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// Build 把cmd/fakepio编译到dir目录下并返回可执行文件路径，供测试以子进程方式启动替身（需要go命令）
+func Build(dir string) (string, error) {
+	exe := filepath.Join(dir, "fakepio")
+	if runtime.GOOS == "windows" {
+		exe += ".exe"
+	}
+	out, err := exec.Command("go", "build", "-o", exe, "piodatasolver/cmd/fakepio").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("编译fakepio失败: %v\n%s", err, out)
+	}
+	return exe, nil
+}
@@ -0,0 +1,841 @@
+// Package fakepio 提供一个离线的PioSolver替身，实现upi.Client使用的UPI行协议，
+// 用一棵小型合成博弈树应答查询，便于在没有PioSolver授权的Linux机器上跑通解析/计算流程。
+package fakepio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Banner 是进程启动时输出的标识行
+const Banner = "PioSOLVER fakepio (offline UPI stand-in)"
+
+// ErrCrashed 表示服务端按SetCrashAfter的设置模拟了一次崩溃
+var ErrCrashed = errors.New("fakepio: simulated crash")
+
+// Server 是一个UPI协议服务端，从输入读取命令，将应答写入输出
+type Server struct {
+	// 当前加载的博弈树
+	tree *Tree
+	// 结束标记字符串，set_end_string之前为空
+	endString string
+	// 建树参数（set_board/set_pot/set_eff_stack/set_range/add_line）
+	board          string
+	pot            [3]int
+	effectiveStack float64
+	ranges         map[string][]float64
+	lines          map[string]bool
+	// 节点锁定：set_strategy设置的策略与lock_node锁定的节点
+	strategies map[string][][]float64
+	locked     map[string]bool
+	// 求解参数
+	accuracy  float64
+	solveTick time.Duration
+	// 模拟崩溃：处理crashAfter条命令后不再应答直接退出，0表示不崩溃
+	crashAfter int
+	handled    int
+	// 模拟单条命令出错：第一次收到与failCommand相同的命令时应答ERROR，为空表示不模拟
+	failCommand string
+
+	// 输出写入器，求解goroutine与命令处理共享
+	out   *bufio.Writer
+	outMu sync.Mutex
+
+	// 求解状态；solvedLocks是最近一次求解时锁定节点策略的摘要，求解后EV据此偏移
+	solveMu     sync.Mutex
+	solving     bool
+	solvedLocks string
+	stopChan    chan struct{}
+	solveWg     sync.WaitGroup
+}
+
+// NewServer 创建一个服务端，tree可以为nil，之后通过load_tree或build_tree加载
+func NewServer(tree *Tree) *Server {
+	return &Server{
+		tree:      tree,
+		accuracy:  0.12,
+		solveTick: 50 * time.Millisecond,
+	}
+}
+
+// SetSolveTick 设置模拟求解时每次迭代的间隔
+func (s *Server) SetSolveTick(d time.Duration) {
+	s.solveTick = d
+}
+
+// SetCrashAfter 设置处理n条命令后模拟进程崩溃，第n+1条命令不会得到应答，0表示不崩溃
+func (s *Server) SetCrashAfter(n int) {
+	s.crashAfter = n
+}
+
+// SetFailCommand 设置第一次收到与command完全相同的命令时应答ERROR，之后的同一命令正常应答；为空表示不模拟
+func (s *Server) SetFailCommand(command string) {
+	s.failCommand = command
+}
+
+// Serve 逐行处理命令直到输入结束或收到exit
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.out = bufio.NewWriter(w)
+	s.writeLines(Banner)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "exit" {
+			break
+		}
+		s.handled++
+		if s.crashAfter > 0 && s.handled > s.crashAfter {
+			return ErrCrashed
+		}
+		if s.failCommand != "" && line == s.failCommand {
+			s.failCommand = ""
+			s.writeResponse([]string{"ERROR: simulated failure of " + line})
+			continue
+		}
+		s.handle(line)
+	}
+
+	s.stopSolve()
+	s.solveWg.Wait()
+	return scanner.Err()
+}
+
+// handle 处理一条命令并输出应答（以结束标记结尾）
+func (s *Server) handle(line string) {
+	args := splitArgs(line)
+	cmd := args[0]
+	args = args[1:]
+
+	var resp []string
+	var err error
+
+	switch cmd {
+	case "set_end_string":
+		if len(args) < 1 {
+			err = fmt.Errorf("set_end_string needs an argument")
+			break
+		}
+		s.endString = args[0]
+		resp = []string{"set_end_string ok!"}
+	case "is_ready":
+		resp = []string{"is_ready ok!"}
+	case "show_hand_order":
+		resp = []string{strings.Join(HandOrder(), " ")}
+	case "load_tree":
+		resp, err = s.loadTree(args)
+	case "dump_tree":
+		resp, err = s.dumpTree(args)
+	case "show_effective_stack":
+		if err = s.needTree(); err == nil {
+			resp = []string{formatFloat(s.tree.EffectiveStack)}
+		}
+	case "show_node":
+		resp, err = s.showNode(args)
+	case "show_children":
+		resp, err = s.showChildren(args)
+	case "show_strategy":
+		resp, err = s.showStrategy(args)
+	case "calc_ev":
+		resp, err = s.calcEV(args)
+	case "calc_eq_node":
+		resp, err = s.calcEqNode(args)
+	case "show_range":
+		resp, err = s.showRange(args)
+	case "show_memory":
+		resp = []string{"free memory: 16384 MB", "total memory: 32768 MB"}
+	case "set_board":
+		s.board = formatBoard(strings.Join(args, ""))
+		resp = []string{"set_board ok!"}
+	case "set_pot":
+		resp, err = s.setPot(args)
+	case "set_eff_stack":
+		resp, err = s.setEffStack(args)
+	case "set_accuracy":
+		if len(args) > 0 {
+			if v, perr := strconv.ParseFloat(args[0], 64); perr == nil {
+				s.accuracy = v
+			}
+		}
+		resp = []string{"set_accuracy ok!"}
+	case "set_range":
+		resp, err = s.setRange(args)
+	case "set_isomorphism":
+		if len(args) != 2 {
+			err = fmt.Errorf("set_isomorphism needs 2 values")
+			break
+		}
+		resp = []string{"set_isomorphism ok!"}
+	case "add_line", "remove_line":
+		resp, err = s.editLine(cmd, args)
+	case "set_strategy":
+		resp, err = s.setStrategy(args)
+	case "lock_node", "unlock_node":
+		resp, err = s.lockNode(cmd, args)
+	case "estimate_tree":
+		resp, err = s.estimateTree()
+	case "build_tree":
+		if s.board == "" {
+			err = fmt.Errorf("board not set")
+			break
+		}
+		s.setTree(SyntheticTree(s.board, s.pot[0], s.pot[1], s.pot[2], s.effectiveStack))
+		resp = []string{"build_tree ok!"}
+	case "go":
+		resp, err = s.startSolve()
+	case "stop":
+		s.stopSolve()
+		resp = []string{"stop ok!"}
+	default:
+		// 其余建树/设置类命令只做确认
+		if strings.HasPrefix(cmd, "set_") || strings.HasPrefix(cmd, "add_") || strings.HasPrefix(cmd, "remove_") ||
+			strings.HasPrefix(cmd, "clear_") || cmd == "rebuild_forgotten_streets" || cmd == "free_tree" {
+			resp = []string{cmd + " ok!"}
+		} else {
+			err = fmt.Errorf("unknown command %s", cmd)
+		}
+	}
+
+	if err != nil {
+		resp = []string{"ERROR: " + err.Error()}
+	}
+	s.writeResponse(resp)
+}
+
+// writeResponse 原子地输出一组应答行和结束标记
+func (s *Server) writeResponse(lines []string) {
+	if s.endString != "" {
+		lines = append(lines, s.endString)
+	}
+	s.writeLines(lines...)
+}
+
+// writeLines 原子地输出若干行
+func (s *Server) writeLines(lines ...string) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	for _, line := range lines {
+		s.out.WriteString(line)
+		s.out.WriteByte('\n')
+	}
+	s.out.Flush()
+}
+
+// setTree 切换当前博弈树，清除上一棵树的节点锁定
+func (s *Server) setTree(tree *Tree) {
+	s.tree = tree
+	s.strategies = nil
+	s.locked = nil
+	s.solveMu.Lock()
+	s.solvedLocks = ""
+	s.solveMu.Unlock()
+}
+
+func (s *Server) needTree() error {
+	if s.tree == nil {
+		return fmt.Errorf("no tree loaded")
+	}
+	return nil
+}
+
+// lookup 查找节点
+func (s *Server) lookup(id string) (*Node, error) {
+	if err := s.needTree(); err != nil {
+		return nil, err
+	}
+	n, ok := s.tree.Node(id)
+	if !ok {
+		return nil, fmt.Errorf("node %s not found", id)
+	}
+	return n, nil
+}
+
+func (s *Server) loadTree(args []string) ([]string, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("load_tree needs a path")
+	}
+	tree, err := LoadTree(args[0])
+	if err != nil {
+		return nil, err
+	}
+	s.setTree(tree)
+	return []string{"load_tree ok!"}, nil
+}
+
+func (s *Server) dumpTree(args []string) ([]string, error) {
+	if err := s.needTree(); err != nil {
+		return nil, err
+	}
+	if len(args) < 1 {
+		return nil, fmt.Errorf("dump_tree needs a path")
+	}
+	if len(args) > 1 {
+		switch args[1] {
+		case "full", "no_rivers", "no_turns":
+		default:
+			return nil, fmt.Errorf("dump_tree: unknown mode %s", args[1])
+		}
+	}
+	if err := s.tree.Save(args[0]); err != nil {
+		return nil, err
+	}
+	return []string{"dump_tree ok!"}, nil
+}
+
+func (s *Server) showNode(args []string) ([]string, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("show_node needs a node")
+	}
+	n, err := s.lookup(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		n.ID,
+		n.Type,
+		s.tree.NodeBoard(n),
+		n.Pot,
+		fmt.Sprintf("%d children", len(n.Children)),
+		"flags:",
+	}, nil
+}
+
+func (s *Server) showChildren(args []string) ([]string, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("show_children needs a node")
+	}
+	n, err := s.lookup(args[0])
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for i, id := range n.Children {
+		child, _ := s.tree.Node(id)
+		lines = append(lines,
+			fmt.Sprintf("child %d:", i),
+			child.ID,
+			child.Type,
+			s.tree.NodeBoard(child),
+			child.Pot,
+			fmt.Sprintf("%d children", len(child.Children)),
+			"flags:",
+		)
+	}
+	return lines, nil
+}
+
+func (s *Server) showStrategy(args []string) ([]string, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("show_strategy needs a node")
+	}
+	n, err := s.lookup(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if n.Type != "OOP_DEC" && n.Type != "IP_DEC" {
+		return nil, fmt.Errorf("node %s is not a decision node", n.ID)
+	}
+	if rows, ok := s.strategies[n.ID]; ok {
+		lines := make([]string, len(rows))
+		for a, row := range rows {
+			values := make([]string, len(row))
+			for j, v := range row {
+				values[j] = formatFloat(v)
+			}
+			lines[a] = strings.Join(values, " ")
+		}
+		return lines, nil
+	}
+
+	blocked := blockedHands(s.tree.NodeBoard(n))
+	rows := make([][]string, len(n.Children))
+	for j, hand := range HandOrder() {
+		for a := range n.Children {
+			v := 0.0
+			if !blocked[j] {
+				v = s.strategyFreq(n, j, hand, a)
+			}
+			rows[a] = append(rows[a], formatFloat(v))
+		}
+	}
+
+	lines := make([]string, len(rows))
+	for a, row := range rows {
+		lines[a] = strings.Join(row, " ")
+	}
+	return lines, nil
+}
+
+// strategyFreq 返回手牌（HandOrder中的第j手）在决策节点选择第a个动作的频率：
+// set_strategy设置过时使用设置的值，否则在基础频率上做确定性扰动后归一化
+func (s *Server) strategyFreq(n *Node, j int, hand string, a int) float64 {
+	if rows, ok := s.strategies[n.ID]; ok {
+		return rows[a][j]
+	}
+	total, weight := 0.0, 0.0
+	for i := range n.Children {
+		base := 1.0 / float64(len(n.Children))
+		if len(n.Strategy) > 0 {
+			base = n.Strategy[i]
+		}
+		w := base * (0.5 + unit(n.ID, hand, "strategy", strconv.Itoa(i)))
+		total += w
+		if i == a {
+			weight = w
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return weight / total
+}
+
+// showRange 返回玩家到达节点时1326手牌的范围权重：初始范围（set_range设置的权重，默认为1）
+// 乘以路径上该玩家各决策节点选择对应动作的频率，与公牌冲突的手牌为0
+func (s *Server) showRange(args []string) ([]string, error) {
+	player, n, err := s.playerNode("show_range", args)
+	if err != nil {
+		return nil, err
+	}
+	actor := player + "_DEC"
+	blocked := blockedHands(s.tree.NodeBoard(n))
+
+	// 路径上该玩家的决策节点及其选择的动作
+	type step struct {
+		node   *Node
+		action int
+	}
+	var steps []step
+	parts := strings.Split(n.ID, ":")
+	for i := 2; i < len(parts); i++ {
+		parent, ok := s.tree.Node(strings.Join(parts[:i], ":"))
+		if !ok || parent.Type != actor {
+			continue
+		}
+		child := strings.Join(parts[:i+1], ":")
+		for a, id := range parent.Children {
+			if id == child {
+				steps = append(steps, step{parent, a})
+				break
+			}
+		}
+	}
+
+	weights := make([]string, 0, len(HandOrder()))
+	for j, hand := range HandOrder() {
+		w := 1.0
+		if r, ok := s.ranges[player]; ok {
+			w = r[j]
+		}
+		if blocked[j] {
+			w = 0
+		}
+		for _, st := range steps {
+			w *= s.strategyFreq(st.node, j, hand, st.action)
+		}
+		weights = append(weights, formatFloat(w))
+	}
+	return []string{strings.Join(weights, " ")}, nil
+}
+
+func (s *Server) calcEV(args []string) ([]string, error) {
+	player, n, err := s.playerNode("calc_ev", args)
+	if err != nil {
+		return nil, err
+	}
+	pot := potTotal(n.Pot)
+	blocked := blockedHands(s.tree.NodeBoard(n))
+
+	var evs, matchups []string
+	for j, hand := range HandOrder() {
+		if blocked[j] {
+			evs = append(evs, "nan")
+			matchups = append(matchups, "0")
+			continue
+		}
+		evs = append(evs, formatFloat(pot*unit(n.ID, hand, "ev", player)*s.lockScale(n.ID, hand, player)))
+		matchups = append(matchups, formatFloat(0.5+0.5*unit(n.ID, hand, "matchup", player)))
+	}
+	return []string{strings.Join(evs, " "), strings.Join(matchups, " ")}, nil
+}
+
+func (s *Server) calcEqNode(args []string) ([]string, error) {
+	player, n, err := s.playerNode("calc_eq_node", args)
+	if err != nil {
+		return nil, err
+	}
+	blocked := blockedHands(s.tree.NodeBoard(n))
+
+	var eqs, matchups []string
+	sum, weight := 0.0, 0.0
+	for j, hand := range HandOrder() {
+		if blocked[j] {
+			eqs = append(eqs, "nan")
+			matchups = append(matchups, "0")
+			continue
+		}
+		eq := unit(n.ID, hand, "eq", player)
+		matchup := 0.5 + 0.5*unit(n.ID, hand, "matchup", player)
+		eqs = append(eqs, formatFloat(eq))
+		matchups = append(matchups, formatFloat(matchup))
+		sum += eq * matchup
+		weight += matchup
+	}
+	total := 0.0
+	if weight > 0 {
+		total = sum / weight
+	}
+	return []string{strings.Join(eqs, " "), strings.Join(matchups, " "), formatFloat(total)}, nil
+}
+
+// lockScale 返回节点锁定后重新求解带来的EV缩放系数，没有锁定过节点时为1
+func (s *Server) lockScale(node, hand, player string) float64 {
+	s.solveMu.Lock()
+	locks := s.solvedLocks
+	s.solveMu.Unlock()
+	if locks == "" {
+		return 1
+	}
+	return 0.9 + 0.2*unit(locks, node, hand, player)
+}
+
+// setStrategy 设置节点策略：动作数×1326个频率，按动作顺序排列
+func (s *Server) setStrategy(args []string) ([]string, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("set_strategy needs a node")
+	}
+	n, err := s.lookup(args[0])
+	if err != nil {
+		return nil, err
+	}
+	hands := len(HandOrder())
+	values := args[1:]
+	if len(n.Children) == 0 || len(values) != len(n.Children)*hands {
+		return nil, fmt.Errorf("set_strategy needs %d values, got %d", len(n.Children)*hands, len(values))
+	}
+	rows := make([][]float64, len(n.Children))
+	for a := range rows {
+		rows[a] = make([]float64, hands)
+		for j := range rows[a] {
+			v, err := strconv.ParseFloat(values[a*hands+j], 64)
+			if err != nil || v < 0 || v > 1 {
+				return nil, fmt.Errorf("invalid frequency %s", values[a*hands+j])
+			}
+			rows[a][j] = v
+		}
+	}
+	if s.strategies == nil {
+		s.strategies = make(map[string][][]float64)
+	}
+	s.strategies[n.ID] = rows
+	return []string{"set_strategy ok!"}, nil
+}
+
+// lockNode 锁定或解锁节点
+func (s *Server) lockNode(cmd string, args []string) ([]string, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("%s needs a node", cmd)
+	}
+	n, err := s.lookup(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if s.locked == nil {
+		s.locked = make(map[string]bool)
+	}
+	if cmd == "lock_node" {
+		s.locked[n.ID] = true
+	} else {
+		delete(s.locked, n.ID)
+	}
+	return []string{cmd + " ok!"}, nil
+}
+
+// lockDigest 计算当前锁定节点及其策略的摘要
+func (s *Server) lockDigest() string {
+	var ids []string
+	for id := range s.locked {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	h := fnv.New64a()
+	for _, id := range ids {
+		fmt.Fprintf(h, "%s=%v;", id, s.strategies[id])
+	}
+	if len(ids) == 0 {
+		return ""
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// playerNode 解析 "<OOP|IP> <node>" 形式的参数
+func (s *Server) playerNode(cmd string, args []string) (string, *Node, error) {
+	if len(args) < 2 {
+		return "", nil, fmt.Errorf("%s needs a player and a node", cmd)
+	}
+	player := args[0]
+	if player != "OOP" && player != "IP" {
+		return "", nil, fmt.Errorf("invalid player %s", player)
+	}
+	n, err := s.lookup(args[1])
+	if err != nil {
+		return "", nil, err
+	}
+	return player, n, nil
+}
+
+func (s *Server) setPot(args []string) ([]string, error) {
+	if len(args) < 3 {
+		return nil, fmt.Errorf("set_pot needs 3 values")
+	}
+	for i := 0; i < 3; i++ {
+		v, err := strconv.Atoi(args[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pot value %s", args[i])
+		}
+		s.pot[i] = v
+	}
+	return []string{"set_pot ok!"}, nil
+}
+
+// setRange 记录玩家范围：1326个权重，或PioSolver范围文本（只检查非空）
+func (s *Server) setRange(args []string) ([]string, error) {
+	if len(args) < 2 || (args[0] != "OOP" && args[0] != "IP") {
+		return nil, fmt.Errorf("set_range needs a player and a range")
+	}
+	if len(args) == 2 {
+		// 范围文本，如 AA,KK:0.5
+		return []string{"set_range ok!"}, nil
+	}
+	if len(args)-1 != len(HandOrder()) {
+		return nil, fmt.Errorf("set_range needs %d weights, got %d", len(HandOrder()), len(args)-1)
+	}
+	weights := make([]float64, len(args)-1)
+	for i, a := range args[1:] {
+		v, err := strconv.ParseFloat(a, 64)
+		if err != nil || v < 0 || v > 1 {
+			return nil, fmt.Errorf("invalid weight %s", a)
+		}
+		weights[i] = v
+	}
+	if s.ranges == nil {
+		s.ranges = make(map[string][]float64)
+	}
+	s.ranges[args[0]] = weights
+	return []string{"set_range ok!"}, nil
+}
+
+// editLine 记录add_line/remove_line的下注线，remove_line删除不存在的线时报错
+func (s *Server) editLine(cmd string, args []string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s needs bet sizes", cmd)
+	}
+	for _, a := range args {
+		if _, err := strconv.Atoi(a); err != nil {
+			return nil, fmt.Errorf("invalid bet size %s", a)
+		}
+	}
+	key := strings.Join(args, " ")
+	if s.lines == nil {
+		s.lines = make(map[string]bool)
+	}
+	if cmd == "remove_line" {
+		if !s.lines[key] {
+			return nil, fmt.Errorf("line %s not found", key)
+		}
+		delete(s.lines, key)
+	} else {
+		s.lines[key] = true
+	}
+	return []string{cmd + " ok!"}, nil
+}
+
+// estimateTree 按当前设置构建的合成树估算节点数和内存占用
+func (s *Server) estimateTree() ([]string, error) {
+	if s.board == "" {
+		return nil, fmt.Errorf("board not set")
+	}
+	t := SyntheticTree(s.board, s.pot[0], s.pot[1], s.pot[2], s.effectiveStack)
+	memory := float64(len(t.Nodes)) * 1326 * 8 * 64 / (1 << 20)
+	return []string{
+		fmt.Sprintf("nodes: %d", len(t.Nodes)),
+		fmt.Sprintf("estimated memory: %.2f MB", memory),
+	}, nil
+}
+
+func (s *Server) setEffStack(args []string) ([]string, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("set_eff_stack needs a value")
+	}
+	v, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stack %s", args[0])
+	}
+	s.effectiveStack = v
+	return []string{"set_eff_stack ok!"}, nil
+}
+
+// startSolve 启动模拟求解，进度行在后台异步输出
+func (s *Server) startSolve() ([]string, error) {
+	if err := s.needTree(); err != nil {
+		return nil, err
+	}
+	s.solveMu.Lock()
+	defer s.solveMu.Unlock()
+	if s.solving {
+		return nil, fmt.Errorf("solver is already running")
+	}
+	s.solving = true
+	s.stopChan = make(chan struct{})
+
+	s.solveWg.Add(1)
+	root, _ := s.tree.Node("r:0")
+	go s.solve(s.stopChan, potTotal(root.Pot), s.lockDigest())
+	return []string{"go ok!"}, nil
+}
+
+// solve 模拟CFR迭代：可剥削值按固定比例下降，达到精度后停止
+func (s *Server) solve(stop <-chan struct{}, pot float64, locks string) {
+	defer s.solveWg.Done()
+
+	start := time.Now()
+	exploitable := math.Max(pot/10, 1)
+	reason := "required accuracy reached"
+
+	s.writeLines("SOLVER: started")
+	for {
+		select {
+		case <-stop:
+			reason = "stopped by user"
+		case <-time.After(s.solveTick):
+			exploitable *= 0.7
+			s.writeLines(
+				fmt.Sprintf("running time: %.2f", time.Since(start).Seconds()),
+				fmt.Sprintf("EV OOP: %.3f", pot*0.52),
+				fmt.Sprintf("EV IP: %.3f", pot*0.48),
+				fmt.Sprintf("Exploitable for: %.6f", exploitable),
+			)
+			if exploitable > s.accuracy {
+				continue
+			}
+		}
+		break
+	}
+
+	s.solveMu.Lock()
+	s.solving = false
+	s.solvedLocks = locks
+	s.solveMu.Unlock()
+	s.writeLines(fmt.Sprintf("SOLVER: stopped (%s)", reason))
+}
+
+// stopSolve 中止正在进行的求解
+func (s *Server) stopSolve() {
+	s.solveMu.Lock()
+	defer s.solveMu.Unlock()
+	if s.solving && s.stopChan != nil {
+		close(s.stopChan)
+		s.stopChan = nil
+	}
+}
+
+// HandOrder 返回与PioSolver相同顺序的1326手牌
+func HandOrder() []string {
+	handOrderOnce.Do(func() {
+		ranks := "23456789TJQKA"
+		suits := "cdhs"
+		var cards []string
+		for _, r := range ranks {
+			for _, s := range suits {
+				cards = append(cards, string(r)+string(s))
+			}
+		}
+		for i := 1; i < len(cards); i++ {
+			for j := 0; j < i; j++ {
+				handOrder = append(handOrder, cards[i]+cards[j])
+			}
+		}
+	})
+	return handOrder
+}
+
+var (
+	handOrder     []string
+	handOrderOnce sync.Once
+)
+
+// blockedHands 返回与公牌冲突的手牌标记（按HandOrder顺序）
+func blockedHands(board string) []bool {
+	dead := make(map[string]bool)
+	for _, card := range strings.Fields(board) {
+		dead[card] = true
+	}
+	hands := HandOrder()
+	blocked := make([]bool, len(hands))
+	for i, hand := range hands {
+		blocked[i] = dead[hand[:2]] || dead[hand[2:]]
+	}
+	return blocked
+}
+
+// unit 根据输入生成[0,1)区间内确定性的伪随机数
+func unit(parts ...string) float64 {
+	h := fnv.New64a()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return float64(h.Sum64()%1000000) / 1000000
+}
+
+// potTotal 计算 "oop ip dead" 的总底池
+func potTotal(pot string) float64 {
+	total := 0.0
+	for _, f := range strings.Fields(pot) {
+		if v, err := strconv.ParseFloat(f, 64); err == nil {
+			total += v
+		}
+	}
+	return total
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 6, 64)
+}
+
+// splitArgs 按空白分割命令，支持双引号包裹的路径
+func splitArgs(line string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if current.Len() > 0 {
+				args = append(args, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		args = append(args, current.String())
+	}
+	return args
+}
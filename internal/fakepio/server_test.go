@@ -0,0 +1,126 @@
+package fakepio
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fixturePath 是testdata中的合成博弈树
+var fixturePath, _ = filepath.Abs("../../testdata/fakepio/40bb_COvsBB_Ks7d2c.cfr")
+
+// serve 把commands逐行交给一个新的Server处理，返回全部输出行
+func serve(t *testing.T, commands ...string) []string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := NewServer(nil).Serve(strings.NewReader(strings.Join(commands, "\n")+"\n"), &out); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+// frames 按结束标记把输出切分为各条命令的应答，第一帧之前的行（启动横幅）单独返回
+func frames(lines []string, end string) (banner []string, responses [][]string) {
+	var current []string
+	for _, line := range lines {
+		if line == end {
+			responses = append(responses, current)
+			current = nil
+			continue
+		}
+		current = append(current, line)
+	}
+	return responses[0][:1], append([][]string{responses[0][1:]}, responses[1:]...)
+}
+
+func TestServeFraming(t *testing.T) {
+	lines := serve(t,
+		"set_end_string END",
+		"load_tree "+fixturePath,
+		"show_node r:0",
+		"show_children r:0",
+		"show_node r:0:no_such_node",
+		"no_such_command",
+	)
+	banner, responses := frames(lines, "END")
+	if banner[0] != Banner {
+		t.Errorf("启动横幅 = %q", banner[0])
+	}
+	if len(responses) != 6 {
+		t.Fatalf("应有6帧应答，实际 %d: %q", len(responses), lines)
+	}
+	if got := strings.Join(responses[2], "|"); got != "r:0|OOP_DEC|Ks 7d 2c|0 0 60|2 children|flags:" {
+		t.Errorf("show_node = %q", got)
+	}
+	if len(responses[3]) != 14 || responses[3][0] != "child 0:" || responses[3][8] != "r:0:b20" {
+		t.Errorf("show_children = %q", responses[3])
+	}
+	for _, r := range responses[4:] {
+		if len(r) != 1 || !strings.HasPrefix(r[0], "ERROR") {
+			t.Errorf("错误命令的应答 = %q", r)
+		}
+	}
+}
+
+func TestShowStrategyRows(t *testing.T) {
+	_, responses := frames(serve(t, "set_end_string END", "load_tree "+fixturePath, "show_strategy r:0"), "END")
+	rows := responses[2]
+	if len(rows) != 2 {
+		t.Fatalf("r:0有2个行动，实际 %d 行", len(rows))
+	}
+
+	hands := HandOrder()
+	sums := make([]float64, len(hands))
+	for _, row := range rows {
+		fields := strings.Fields(row)
+		if len(fields) != len(hands) {
+			t.Fatalf("每行应有 %d 个数值，实际 %d", len(hands), len(fields))
+		}
+		for j, field := range fields {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sums[j] += v
+		}
+	}
+	blocked := blockedHands("Ks 7d 2c")
+	for j, sum := range sums {
+		want := 1.0
+		if blocked[j] {
+			want = 0
+		}
+		if sum < want-1e-6 || sum > want+1e-6 {
+			t.Errorf("%s 的频率之和 = %v，应为 %v", hands[j], sum, want)
+		}
+	}
+}
+
+func TestServeCrashAfter(t *testing.T) {
+	server := NewServer(nil)
+	server.SetCrashAfter(2)
+	var out bytes.Buffer
+	err := server.Serve(strings.NewReader("set_end_string END\nis_ready\nis_ready\n"), &out)
+	if !errors.Is(err, ErrCrashed) {
+		t.Fatalf("第3条命令应模拟崩溃，实际 %v", err)
+	}
+	if got := strings.Count(out.String(), "is_ready ok!"); got != 1 {
+		t.Errorf("崩溃前应只应答1条is_ready，实际 %d", got)
+	}
+}
+
+func TestServeFailCommand(t *testing.T) {
+	server := NewServer(nil)
+	server.SetFailCommand("is_ready")
+	var out bytes.Buffer
+	if err := server.Serve(strings.NewReader("set_end_string END\nis_ready\nis_ready\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "set_end_string ok!\nEND\nERROR: simulated failure of is_ready\nEND\nis_ready ok!\nEND\n"
+	if got := strings.TrimPrefix(out.String(), Banner+"\n"); got != want {
+		t.Errorf("只有第一次is_ready应答ERROR，实际输出:\n%s", got)
+	}
+}
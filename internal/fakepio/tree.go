@@ -0,0 +1,230 @@
+package fakepio
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Node 表示合成博弈树中的一个节点
+type Node struct {
+	ID       string    `json:"id"`                 // 节点ID，如 r:0:c:b20
+	Type     string    `json:"type"`               // 节点类型 OOP_DEC/IP_DEC/SPLIT_NODE/END_NODE
+	Board    string    `json:"board,omitempty"`    // 公牌，为空时使用树的公牌
+	Pot      string    `json:"pot"`                // 底池信息 "oop ip dead"
+	Children []string  `json:"children,omitempty"` // 子节点ID，顺序即行动顺序
+	Strategy []float64 `json:"strategy,omitempty"` // 各行动的基础频率，为空时平均分配
+
+	expanded bool // 发牌节点是否已生成下一条街
+}
+
+// Tree 表示一棵合成博弈树（即fixture文件的内容）
+type Tree struct {
+	Board          string  `json:"board"`           // 翻牌公牌，如 "Ks 7d 2c"
+	EffectiveStack float64 `json:"effective_stack"` // 有效筹码
+	Nodes          []*Node `json:"nodes"`           // 所有节点
+
+	index map[string]*Node
+}
+
+// LoadTree 从fixture文件加载合成博弈树
+func LoadTree(path string) (*Tree, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取fixture文件失败: %v", err)
+	}
+
+	var tree Tree
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("解析fixture文件失败: %v", err)
+	}
+	if err := tree.buildIndex(); err != nil {
+		return nil, err
+	}
+	return &tree, nil
+}
+
+// Save 将合成博弈树写入文件，格式与LoadTree读取的fixture相同
+func (t *Tree) Save(path string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化博弈树失败: %v", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Node 根据ID查找节点，路径上未展开的发牌节点（SPLIT_NODE）在查找时按需生成下一条街
+func (t *Tree) Node(id string) (*Node, bool) {
+	n, ok := t.index[id]
+	if !ok {
+		// 逐级展开路径上的发牌节点，如 r:0:c:c:7h:c 需要先展开 r:0:c:c
+		parts := strings.Split(id, ":")
+		for i := 2; i < len(parts); i++ {
+			if p, ok := t.index[strings.Join(parts[:i], ":")]; ok {
+				t.expand(p)
+			}
+		}
+		if n, ok = t.index[id]; !ok {
+			return nil, false
+		}
+	}
+	t.expand(n)
+	return n, true
+}
+
+// NodeBoard 返回节点所在的公牌（节点未单独指定时使用树的公牌）
+func (t *Tree) NodeBoard(n *Node) string {
+	if n.Board != "" {
+		return n.Board
+	}
+	return t.Board
+}
+
+// buildIndex 建立节点索引并检查子节点引用
+func (t *Tree) buildIndex() error {
+	t.index = make(map[string]*Node, len(t.Nodes))
+	for _, n := range t.Nodes {
+		if _, dup := t.index[n.ID]; dup {
+			return fmt.Errorf("fixture中节点重复: %s", n.ID)
+		}
+		t.index[n.ID] = n
+	}
+	for _, n := range t.Nodes {
+		for _, child := range n.Children {
+			if _, ok := t.index[child]; !ok {
+				return fmt.Errorf("节点 %s 引用了不存在的子节点 %s", n.ID, child)
+			}
+		}
+		if len(n.Strategy) > 0 && len(n.Strategy) != len(n.Children) {
+			return fmt.Errorf("节点 %s 的strategy长度 %d 与子节点数量 %d 不一致", n.ID, len(n.Strategy), len(n.Children))
+		}
+	}
+	if _, ok := t.index["r:0"]; !ok {
+		return fmt.Errorf("fixture中缺少根节点 r:0")
+	}
+	return nil
+}
+
+// SyntheticTree 根据公牌、底池和有效筹码生成一棵小型合成树
+// 结构为：OOP过牌/下注，IP面对下注时弃牌/跟注/加注，每条街只有一次加注；
+// 转牌和河牌在查找发牌节点时按需生成（见Node），结构与翻牌相同
+func SyntheticTree(board string, oop, ip, dead int, effectiveStack float64) *Tree {
+	t := &Tree{Board: board, EffectiveStack: effectiveStack}
+	t.addStreet("r:0", "", oop, ip, dead)
+	t.buildIndex()
+	return t
+}
+
+// add 添加节点，已建立索引时同时加入索引
+func (t *Tree) add(nodes ...*Node) {
+	t.Nodes = append(t.Nodes, nodes...)
+	if t.index != nil {
+		for _, n := range nodes {
+			t.index[n.ID] = n
+		}
+	}
+}
+
+// addStreet 添加一条街的行动节点，id为该街第一个行动节点，board为空时使用树的公牌
+func (t *Tree) addStreet(id, board string, oop, ip, dead int) {
+	pot := func(o, i int) string { return fmt.Sprintf("%d %d %d", o, i, dead) }
+	bet := func(o, i int) int { return max(o, i) + (o+i+dead)/3 }
+
+	// 过牌过牌后进入发牌节点，河牌之后摊牌
+	next := "SPLIT_NODE"
+	if board != "" && len(strings.Fields(board)) >= 5 {
+		next = "END_NODE"
+	}
+
+	// OOP先行动
+	b1 := bet(oop, ip)
+	t.add(&Node{ID: id, Type: "OOP_DEC", Board: board, Pot: pot(oop, ip), Children: []string{id + ":c", id + ":b" + strconv.Itoa(b1)}, Strategy: []float64{0.6, 0.4}})
+
+	// OOP过牌后IP行动
+	b2 := bet(oop, ip)
+	t.add(
+		&Node{ID: id + ":c", Type: "IP_DEC", Board: board, Pot: pot(oop, ip), Children: []string{id + ":c:c", id + ":c:b" + strconv.Itoa(b2)}, Strategy: []float64{0.5, 0.5}},
+		&Node{ID: id + ":c:c", Type: next, Board: board, Pot: pot(oop, ip)},
+	)
+	t.addFacingBet(id+":c:b"+strconv.Itoa(b2), "OOP_DEC", board, next, oop, b2, dead)
+
+	// OOP下注后IP面对下注
+	t.addFacingBet(id+":b"+strconv.Itoa(b1), "IP_DEC", board, next, b1, ip, dead)
+}
+
+// addFacingBet 添加面对下注的节点及其弃牌/跟注/加注子节点，面对加注时只能弃牌或跟注
+// next 是跟注后的节点类型（SPLIT_NODE或河牌的END_NODE）
+func (t *Tree) addFacingBet(id, actor, board, next string, oop, ip, dead int) {
+	pot := func(o, i int) string { return fmt.Sprintf("%d %d %d", o, i, dead) }
+	called := max(oop, ip)
+	stack := int(t.EffectiveStack)
+
+	raise := called * 3
+	if stack > 0 && raise > stack {
+		raise = stack
+	}
+	raiseID := id + ":b" + strconv.Itoa(raise)
+
+	// 加注后的行动方与底池
+	raiseActor, o, i := "OOP_DEC", oop, raise
+	if actor == "OOP_DEC" {
+		raiseActor, o, i = "IP_DEC", raise, ip
+	}
+
+	t.add(
+		&Node{ID: id, Type: actor, Board: board, Pot: pot(oop, ip), Children: []string{id + ":f", id + ":c", raiseID}, Strategy: []float64{0.3, 0.5, 0.2}},
+		&Node{ID: id + ":f", Type: "END_NODE", Board: board, Pot: pot(oop, ip)},
+		&Node{ID: id + ":c", Type: next, Board: board, Pot: pot(called, called)},
+		&Node{ID: raiseID, Type: raiseActor, Board: board, Pot: pot(o, i), Children: []string{raiseID + ":f", raiseID + ":c"}, Strategy: []float64{0.4, 0.6}},
+		&Node{ID: raiseID + ":f", Type: "END_NODE", Board: board, Pot: pot(o, i)},
+		&Node{ID: raiseID + ":c", Type: next, Board: board, Pot: pot(raise, raise)},
+	)
+}
+
+// expand 为没有子节点的发牌节点生成下一条街：每张未出现在公牌上的牌一个子节点，ID为 <发牌节点>:<牌>
+func (t *Tree) expand(n *Node) {
+	if n.Type != "SPLIT_NODE" || n.expanded || len(n.Children) > 0 {
+		return
+	}
+	n.expanded = true
+
+	board := t.NodeBoard(n)
+	cards := strings.Fields(board)
+	if len(cards) >= 5 {
+		return
+	}
+	var pot [3]int
+	for i, f := range strings.Fields(n.Pot) {
+		if i < len(pot) {
+			pot[i], _ = strconv.Atoi(f)
+		}
+	}
+
+	dead := make(map[string]bool, len(cards))
+	for _, card := range cards {
+		dead[card] = true
+	}
+	for _, r := range "23456789TJQKA" {
+		for _, s := range "cdhs" {
+			card := string(r) + string(s)
+			if dead[card] {
+				continue
+			}
+			id := n.ID + ":" + card
+			n.Children = append(n.Children, id)
+			t.addStreet(id, board+" "+card, pot[0], pot[1], pot[2])
+		}
+	}
+}
+
+// formatBoard 将 "Ks7d2c" 形式的公牌转换为 "Ks 7d 2c"
+func formatBoard(board string) string {
+	board = strings.ReplaceAll(strings.TrimSpace(board), " ", "")
+	var cards []string
+	for i := 0; i+1 < len(board); i += 2 {
+		cards = append(cards, board[i:i+2])
+	}
+	return strings.Join(cards, " ")
+}
@@ -0,0 +1,199 @@
+// Package manifest 记录输出目录中已完成的产物：来源、参数、记录数、SHA-256和完成时间
+//
+// 每个输出目录一个清单文件 manifest.jsonl，产物完成时追加一行，同一产物以最后一行为准。
+// 产物先写入临时文件，成功后重命名为正式文件名再记入清单；只有记入清单、参数相同且
+// 文件大小一致的产物才被视为已完成，目录中其他同名文件（中断留下的或旧版本生成的）不算。
+package manifest
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileName 是输出目录中清单文件的文件名
+const FileName = "manifest.jsonl"
+
+// 产物类型
+const (
+	KindRecords = "records" // 逐手牌记录（JSON/NDJSON）
+	KindSummary = "summary" // 节点汇总（JSON/NDJSON）
+	KindSQL     = "sql"     // 单个CFR文件的SQL插入语句
+	KindMerged  = "merged"  // merge命令汇总的SQL文件
+	KindCFR     = "cfr"     // calc命令导出的CFR文件
+	KindCSV     = "csv"     // mergecsv命令转换的CSV文件
+	KindLoadSQL = "load"    // mergecsv命令生成的LOAD DATA导入脚本
+	KindJSONL   = "jsonl"   // jsonl命令生成的训练/评估数据
+)
+
+// Entry 是清单中的一个产物
+type Entry struct {
+	Artifact    string            `json:"artifact"`          // 产物文件名（相对输出目录）
+	Kind        string            `json:"kind"`              // 产物类型，见KindRecords等
+	Source      string            `json:"source"`            // 来源CFR文件或脚本
+	Params      map[string]string `json:"params,omitempty"`  // 生成产物时影响内容的参数
+	Records     int               `json:"records,omitempty"` // 记录数
+	Size        int64             `json:"size"`              // 文件大小（字节）
+	SHA256      string            `json:"sha256,omitempty"`  // 文件的SHA-256，文件不在本机时为空
+	CompletedAt time.Time         `json:"completed_at"`      // 完成时间
+}
+
+// Manifest 是一个输出目录的清单，可以被多个goroutine同时使用
+type Manifest struct {
+	dir string
+
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+// Open 读取dir目录的清单，清单文件不存在时返回空清单；dir不存在时会被创建
+func Open(dir string) (*Manifest, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建输出目录失败: %v", err)
+	}
+	m := &Manifest{dir: dir, entries: make(map[string]Entry)}
+
+	file, err := os.Open(filepath.Join(dir, FileName))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取清单失败: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// 写入中断可能留下不完整的最后一行
+			log.Printf("警告：清单 %s 第%d行无法解析: %v，已忽略", filepath.Join(dir, FileName), line, err)
+			continue
+		}
+		m.entries[entry.Artifact] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取清单失败: %v", err)
+	}
+	return m, nil
+}
+
+// Dir 返回清单所在的输出目录
+func (m *Manifest) Dir() string {
+	return m.dir
+}
+
+// Record 计算输出目录中artifact的大小和SHA-256，并把产物追加到清单
+// 文件不在本机（如远程PioSolver导出的CFR文件）时只记录来源和参数
+func (m *Manifest) Record(artifact, kind, source string, params map[string]string, records int) (Entry, error) {
+	entry := Entry{
+		Artifact: artifact,
+		Kind:     kind,
+		Source:   source,
+		Params:   params,
+		Records:  records,
+	}
+	size, sum, err := FileSHA256(filepath.Join(m.dir, artifact))
+	switch {
+	case err == nil:
+		entry.Size, entry.SHA256 = size, sum
+	case os.IsNotExist(err):
+		log.Printf("警告：产物 %s 不在本机，清单中不记录大小和SHA-256", artifact)
+	default:
+		return Entry{}, fmt.Errorf("计算 %s 的SHA-256失败: %v", artifact, err)
+	}
+	entry.CompletedAt = time.Now()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return Entry{}, fmt.Errorf("序列化清单记录失败: %v", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	file, err := os.OpenFile(filepath.Join(m.dir, FileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return Entry{}, fmt.Errorf("打开清单失败: %v", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return Entry{}, fmt.Errorf("写入清单失败: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return Entry{}, fmt.Errorf("写入清单失败: %v", err)
+	}
+	m.entries[artifact] = entry
+	return entry, nil
+}
+
+// Completed 判断artifact是否已按params完成：清单中有记录、参数相同，且文件大小与记录一致
+// 没有记录SHA-256的产物（文件不在本机）只比较参数
+func (m *Manifest) Completed(artifact string, params map[string]string) bool {
+	m.mu.Lock()
+	entry, ok := m.entries[artifact]
+	m.mu.Unlock()
+	if !ok || !maps.Equal(entry.Params, params) {
+		return false
+	}
+	if entry.SHA256 == "" {
+		return true
+	}
+	info, err := os.Stat(filepath.Join(m.dir, artifact))
+	return err == nil && info.Size() == entry.Size
+}
+
+// Entries 返回清单中kind类型的产物，按文件名排序
+func (m *Manifest) Entries(kind string) []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []Entry
+	for _, entry := range m.entries {
+		if entry.Kind == kind {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Artifact < entries[j].Artifact })
+	return entries
+}
+
+// Verify 重新计算产物的SHA-256，与清单中的记录比较
+func (m *Manifest) Verify(entry Entry) error {
+	size, sum, err := FileSHA256(filepath.Join(m.dir, entry.Artifact))
+	if err != nil {
+		return err
+	}
+	if size != entry.Size || sum != entry.SHA256 {
+		return fmt.Errorf("内容与清单不一致（大小 %d/%d，SHA-256 %s/%s）", size, entry.Size, sum, entry.SHA256)
+	}
+	return nil
+}
+
+// FileSHA256 返回文件的大小和十六进制SHA-256
+func FileSHA256(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
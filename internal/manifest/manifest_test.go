@@ -0,0 +1,103 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.json"), []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{"format": "json"}
+	entry, err := m.Record("a.json", KindRecords, "a.cfr", params, 0)
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if entry.Size != 2 || len(entry.SHA256) != 64 {
+		t.Errorf("Record = %+v", entry)
+	}
+	if !m.Completed("a.json", params) {
+		t.Error("记入清单的产物应为已完成")
+	}
+	if m.Completed("a.json", map[string]string{"format": "ndjson"}) {
+		t.Error("参数不同的产物不应为已完成")
+	}
+	if m.Completed("b.json", nil) {
+		t.Error("不在清单中的产物不应为已完成")
+	}
+
+	// 重新打开后读取同样的记录，同一产物以最后一行为准，不完整的最后一行被忽略
+	if err := os.WriteFile(filepath.Join(dir, "a.json"), []byte("[1]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Record("a.json", KindRecords, "a.cfr", params, 1); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, FileName), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"artifact":"b.json","kind":`)
+	file.Close()
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := reopened.Entries(KindRecords)
+	if len(entries) != 1 || entries[0].Records != 1 || entries[0].Size != 3 {
+		t.Fatalf("Entries = %+v", entries)
+	}
+	if err := reopened.Verify(entries[0]); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if len(reopened.Entries(KindSQL)) != 0 {
+		t.Error("不应有SQL产物")
+	}
+}
+
+func TestCompletedDetectsChangedFile(t *testing.T) {
+	dir := t.TempDir()
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "a.sql")
+	if err := os.WriteFile(path, []byte("INSERT"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := m.Record("a.sql", KindSQL, "a.cfr", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 大小改变时不再视为已完成；大小相同但内容不同时只有Verify能发现
+	if err := os.WriteFile(path, []byte("INSERT;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if m.Completed("a.sql", nil) {
+		t.Error("大小改变后不应为已完成")
+	}
+	if err := os.WriteFile(path, []byte("insert"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Completed("a.sql", nil) {
+		t.Error("大小相同时应为已完成")
+	}
+	if err := m.Verify(entry); err == nil {
+		t.Error("内容改变后Verify应返回错误")
+	}
+
+	// 文件不在本机时只记录来源和参数
+	remote, err := m.Record("remote.cfr", KindCFR, "script.txt", map[string]string{"flop": "Ks7d2c"}, 0)
+	if err != nil || remote.SHA256 != "" || !m.Completed("remote.cfr", map[string]string{"flop": "Ks7d2c"}) {
+		t.Errorf("Record(remote.cfr) = %+v, %v", remote, err)
+	}
+}
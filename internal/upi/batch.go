@@ -0,0 +1,160 @@
+package upi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"piodatasolver/model"
+)
+
+// BatchResult 是批量查询中一条命令的结果
+type BatchResult struct {
+	Command string
+	Lines   []string
+	// PioSolver对该命令返回ERROR行时为*CommandError，其余命令不受影响
+	Err error
+}
+
+// NodeInfo 把show_node的结果解析为节点信息
+func (r BatchResult) NodeInfo() (NodeInfo, error) {
+	if r.Err != nil {
+		return NodeInfo{}, r.Err
+	}
+	return parseNodeInfo(r.Lines)
+}
+
+// Children 把show_children的结果解析为子节点列表
+func (r BatchResult) Children() ([]model.ChildNode, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return parseChildren(r.Lines)
+}
+
+// Strategy 把show_strategy的结果解析为每个动作一行的策略
+func (r BatchResult) Strategy() ([][]float64, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return parseStrategy(r.Lines)
+}
+
+// HandValues 把calc_ev/calc_eq_node的结果解析为1326手牌的数值和match-up
+func (r BatchResult) HandValues() (values, matchup []float64, err error) {
+	if r.Err != nil {
+		return nil, nil, r.Err
+	}
+	return parseHandPair(r.Command, r.Lines)
+}
+
+// Range 把show_range的结果解析为1326手牌的范围权重
+func (r BatchResult) Range() ([]float64, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return parseRange(r.Lines)
+}
+
+// ExecuteBatch 一次写入全部命令，再按顺序读取各自的应答，省去逐条等待结束标记的往返
+// 返回的应答与commands一一对应，ERROR行原样保留；timeout是整批命令的超时时间
+func (c *Client) ExecuteBatch(commands []string, timeout time.Duration) ([][]string, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, timeout)
+	defer cancel()
+	return c.ExecuteBatchContext(ctx, commands)
+}
+
+// ExecuteBatchContext 一次写入全部命令，再按顺序读取各自的应答
+// 任一命令出错（进程退出、ctx被取消）时返回错误，之后命令的应答被丢弃
+func (c *Client) ExecuteBatchContext(ctx context.Context, commands []string) ([][]string, error) {
+	if len(commands) == 0 {
+		return nil, nil
+	}
+
+	start := time.Now()
+	pending, err := c.sendBatch(ctx, commands)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([][]string, len(commands))
+	for i, p := range pending {
+		lines, err := c.wait(ctx, p)
+		if c.recorder != nil {
+			// 只录制实际得到结果的命令，之后未执行的命令不写入录制文件，避免回放时被误认为进程退出点
+			c.recorder.command(p.command, lines, err, start)
+		}
+		if err != nil {
+			if ctx.Err() != nil && i < len(pending)-1 {
+				// 等待最后一条命令的应答到达，确认PioSolver已处理完整批命令，无法同步时终止进程
+				c.wait(ctx, pending[len(pending)-1])
+			}
+			return nil, err
+		}
+		results[i] = lines
+	}
+	return results, nil
+}
+
+// sendBatch 把全部命令登记到等待队列后一次写入
+func (c *Client) sendBatch(ctx context.Context, commands []string) ([]*pendingCommand, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.started {
+		return nil, fmt.Errorf("客户端未启动")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("批量命令未发送: %v", err)
+	}
+
+	var buf strings.Builder
+	pending := make([]*pendingCommand, len(commands))
+	for i, command := range commands {
+		p, err := c.demux.enqueue(command)
+		if err != nil {
+			return nil, err
+		}
+		pending[i] = p
+		buf.WriteString(command)
+		buf.WriteByte('\n')
+	}
+	if _, err := c.stdin.Write([]byte(buf.String())); err != nil {
+		// 写入管道失败说明进程已退出
+		err = fmt.Errorf("发送批量命令失败: %w (%v)", ErrProcessExited, err)
+		c.demux.fail(err)
+		return nil, err
+	}
+	return pending, nil
+}
+
+// QueryBatch 批量执行查询命令，每条命令的ERROR行转换为该条结果的Err
+// PioSolver进程退出时重启并重试整批命令，最多重试maxRestarts次；timeout是每条命令的超时时间
+func (c *Client) QueryBatch(commands []string, timeout time.Duration) ([]BatchResult, error) {
+	total := timeout * time.Duration(len(commands))
+	for attempt := 1; ; attempt++ {
+		responses, err := c.ExecuteBatch(commands, total)
+		if err == nil {
+			results := make([]BatchResult, len(commands))
+			for i, command := range commands {
+				results[i] = BatchResult{
+					Command: command,
+					Lines:   responses[i],
+					Err:     checkError(command, responses[i]),
+				}
+			}
+			return results, nil
+		}
+		if !errors.Is(err, ErrProcessExited) || attempt > c.maxRestarts || c.ctx.Err() != nil {
+			return nil, err
+		}
+
+		log.Printf("⚠️  批量命令执行时PioSolver进程退出，正在重启 (%d/%d)...", attempt, c.maxRestarts)
+		if rerr := c.restartAfterExit(); rerr != nil {
+			return nil, fmt.Errorf("%w，重启PioSolver失败: %v", err, rerr)
+		}
+	}
+}
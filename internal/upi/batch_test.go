@@ -0,0 +1,82 @@
+package upi
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// nodeQueries 返回解析一个决策节点所需的批量查询
+func nodeQueries(node string) []string {
+	return []string{
+		"show_node " + node,
+		"show_children " + node,
+		"show_strategy " + node,
+		"calc_ev OOP " + node,
+		"calc_eq_node IP " + node,
+	}
+}
+
+func TestQueryBatchAgainstFakepio(t *testing.T) {
+	client := startFakepio(t)
+
+	commands := append(nodeQueries("r:0"), "show_node r:0:no_such_node", "show_effective_stack")
+	results, err := client.QueryBatch(commands, 10*time.Second)
+	if err != nil {
+		t.Fatalf("QueryBatch: %v", err)
+	}
+	if len(results) != len(commands) {
+		t.Fatalf("应有 %d 条结果，实际 %d", len(commands), len(results))
+	}
+
+	// 批量结果与逐条查询相同
+	info, _ := client.ShowNode("r:0")
+	if got, err := results[0].NodeInfo(); err != nil || got != info {
+		t.Errorf("show_node = %+v, %v", got, err)
+	}
+	children, _ := client.ShowChildren("r:0")
+	if got, err := results[1].Children(); err != nil || fmt.Sprint(got) != fmt.Sprint(children) {
+		t.Errorf("show_children = %v, %v", got, err)
+	}
+	strategy, _ := client.ShowStrategy("r:0")
+	if got, err := results[2].Strategy(); err != nil || fmt.Sprint(got) != fmt.Sprint(strategy) {
+		t.Errorf("show_strategy不同: %v", err)
+	}
+	ev, matchup, _ := client.CalcEV("OOP", "r:0")
+	if gotEV, gotMatchup, err := results[3].HandValues(); err != nil || fmt.Sprint(gotEV, gotMatchup) != fmt.Sprint(ev, matchup) {
+		t.Errorf("calc_ev不同: %v", err)
+	}
+	if _, _, err := results[4].HandValues(); err != nil {
+		t.Errorf("calc_eq_node: %v", err)
+	}
+
+	// 一条命令的ERROR不影响其后的命令
+	var cmdErr *CommandError
+	if _, err := results[5].NodeInfo(); !errors.As(err, &cmdErr) {
+		t.Errorf("不存在的节点应返回*CommandError，实际 %v", err)
+	}
+	if results[6].Err != nil || len(results[6].Lines) != 1 {
+		t.Errorf("show_effective_stack = %v, %v", results[6].Lines, results[6].Err)
+	}
+}
+
+func TestQueryBatchRestart(t *testing.T) {
+	// 第二批命令执行到一半时fakepio崩溃，重启后整批重试
+	t.Setenv("FAKEPIO_CRASH_AFTER", strconv.Itoa(handshakeCommands+1+len(nodeQueries(""))+2))
+	client := startFakepio(t)
+
+	for i := 0; i < 3; i++ {
+		results, err := client.QueryBatch(nodeQueries("r:0:c"), 10*time.Second)
+		if err != nil {
+			t.Fatalf("第 %d 批查询失败: %v", i+1, err)
+		}
+		if _, err := results[2].Strategy(); err != nil {
+			t.Errorf("第 %d 批show_strategy: %v", i+1, err)
+		}
+	}
+	if client.Restarts() == 0 {
+		t.Error("fakepio崩溃后客户端应重启")
+	}
+}
@@ -0,0 +1,152 @@
+package upi
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bridge 把本机PioSolver进程的标准输入输出暴露到TCP上，供远程的upi.Client（NewTCPClient）驱动
+//
+// 每个TCP连接对应一个新启动的PioSolver进程：连接上收到的每一行命令写入进程的标准输入，
+// 进程的输出按行转发，遇到结束标记（或暂时没有更多输出）时整帧发送。
+// 连接断开时进程被终止，进程退出时连接被关闭。
+type Bridge struct {
+	// PioSolver可执行文件路径
+	ExePath string
+	// PioSolver工作目录
+	WorkingDir string
+	// 同时运行的会话上限，0表示不限制；超过上限的连接收到ERROR行后被关闭
+	MaxSessions int
+
+	mu       sync.Mutex
+	sessions int
+}
+
+// Serve 接受连接直到ctx被取消或监听出错
+func (b *Bridge) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("接受连接失败: %v", err)
+		}
+
+		if !b.acquire() {
+			log.Printf("拒绝连接 %s: 会话数已达上限 %d", conn.RemoteAddr(), b.MaxSessions)
+			fmt.Fprintf(conn, "ERROR: upibridge: too many sessions (max %d)\n", b.MaxSessions)
+			conn.Close()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer b.release()
+			b.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (b *Bridge) acquire() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.MaxSessions > 0 && b.sessions >= b.MaxSessions {
+		return false
+	}
+	b.sessions++
+	return true
+}
+
+func (b *Bridge) release() {
+	b.mu.Lock()
+	b.sessions--
+	b.mu.Unlock()
+}
+
+// serveConn 为一个连接启动PioSolver进程并双向转发，直到任一端结束
+func (b *Bridge) serveConn(ctx context.Context, conn net.Conn) {
+	remote := conn.RemoteAddr()
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t := &execTransport{exePath: b.ExePath, workingDir: b.WorkingDir}
+	stdin, stdout, err := t.open(ctx)
+	if err != nil {
+		log.Printf("[%s] 启动PioSolver失败: %v", remote, err)
+		fmt.Fprintf(conn, "ERROR: upibridge: %v\n", err)
+		return
+	}
+	log.Printf("[%s] 会话开始，PioSolver进程 %d", remote, t.cmd.Process.Pid)
+
+	// 结束标记由客户端的set_end_string命令决定，转发命令时记录下来
+	var endMu sync.Mutex
+	endString := ""
+
+	// 连接 -> PioSolver标准输入
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if args := strings.Fields(line); len(args) == 2 && args[0] == "set_end_string" {
+				endMu.Lock()
+				endString = args[1]
+				endMu.Unlock()
+			}
+			if _, err := fmt.Fprintln(stdin, line); err != nil {
+				break
+			}
+		}
+		// 客户端断开或半关闭：关闭标准输入让PioSolver自行退出，超时则终止进程
+		stdin.Close()
+		select {
+		case <-time.After(resyncTimeout):
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// PioSolver标准输出 -> 连接，按帧发送
+	reader := bufio.NewReader(stdout)
+	writer := bufio.NewWriter(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			writer.WriteString(line)
+			endMu.Lock()
+			frameEnd := endString != "" && strings.TrimRight(line, "\r\n") == endString
+			endMu.Unlock()
+			if frameEnd || reader.Buffered() == 0 {
+				if werr := writer.Flush(); werr != nil {
+					log.Printf("[%s] 写入连接失败: %v", remote, werr)
+					break
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	writer.Flush()
+
+	cancel()
+	t.kill()
+	log.Printf("[%s] 会话结束，PioSolver退出状态: %v", remote, t.wait())
+}
@@ -0,0 +1,78 @@
+package upi
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// startBridge 在本机随机端口上启动包装fakepio的桥接服务，测试结束时停止
+func startBridge(t *testing.T, maxSessions int) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	bridge := &Bridge{ExePath: fakepioExe, WorkingDir: t.TempDir(), MaxSessions: maxSessions}
+	done := make(chan error, 1)
+	go func() { done <- bridge.Serve(ctx, ln) }()
+
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Serve: %v", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Error("桥接服务没有停止")
+		}
+	})
+	return ln.Addr().String()
+}
+
+func TestBridgeRoundTrip(t *testing.T) {
+	addr := startBridge(t, 1)
+
+	client := NewTCPClient(addr)
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("通过桥接服务启动失败: %v", err)
+	}
+	defer client.Close()
+	if client.Version() == "" {
+		t.Error("没有收到fakepio的启动横幅")
+	}
+
+	if err := client.LoadTree(fixturePath); err != nil {
+		t.Fatalf("LoadTree: %v", err)
+	}
+	if stack, err := client.ShowEffectiveStack(); err != nil || stack != 370 {
+		t.Errorf("ShowEffectiveStack = %v, %v", stack, err)
+	}
+	strategy, err := client.ShowStrategy("r:0")
+	if err != nil || len(strategy) != 2 || len(strategy[0]) != HandCount {
+		t.Fatalf("ShowStrategy = %d 行, %v", len(strategy), err)
+	}
+
+	// 批量发送的命令按顺序得到各自的应答
+	results, err := client.QueryBatch([]string{"show_node r:0", "show_children r:0", "calc_ev OOP r:0"}, 10*time.Second)
+	if err != nil {
+		t.Fatalf("QueryBatch: %v", err)
+	}
+	if info, err := results[0].NodeInfo(); err != nil || info.NodeID != "r:0" {
+		t.Errorf("show_node = %+v, %v", info, err)
+	}
+	if _, _, err := results[2].HandValues(); err != nil {
+		t.Errorf("calc_ev: %v", err)
+	}
+
+	// 会话数已达上限时新连接被拒绝
+	rejected := NewTCPClient(addr)
+	rejected.SetStartupTimeout(5 * time.Second)
+	if err := rejected.Start(context.Background()); err == nil {
+		rejected.Close()
+		t.Error("超过会话上限的连接应被拒绝")
+	}
+}
@@ -0,0 +1,296 @@
+package upi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 录制文件（cassette）是JSONL格式，每行一条记录：
+//
+//	{"type":"command","time":"...","command":"show_node r:0","response":["r:0","OOP_DEC",...],"elapsed_ms":3.2}
+//	{"type":"event","time":"...","line":"SOLVER: started"}
+//
+// 命令按应答到达的顺序写入；事件归属于它之前最近的一条命令，回放时紧跟在该命令的应答之后输出，
+// 第一条命令之前的事件（如启动横幅）在会话开始时输出。
+
+const (
+	entryCommand = "command"
+	entryEvent   = "event"
+)
+
+// cassetteEntry 是录制文件中的一行
+type cassetteEntry struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Command   string    `json:"command,omitempty"`
+	Response  []string  `json:"response,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Exited    bool      `json:"exited,omitempty"` // 命令执行期间进程退出
+	ElapsedMs float64   `json:"elapsed_ms,omitempty"`
+	Line      string    `json:"line,omitempty"`
+
+	// 回放时紧跟在应答之后输出的事件
+	events []string
+}
+
+// recorder 把客户端的每条命令、完整应答和耗时写入录制文件
+type recorder struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// RecordTo 开启录制模式：之后每条命令及其应答、耗时和事件都写入path（JSONL格式，已存在时覆盖）
+// 必须在Start之前调用；录制文件在Close时关闭
+func (c *Client) RecordTo(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.started || c.starting {
+		return fmt.Errorf("客户端已启动，无法开启录制")
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("创建录制文件失败: %v", err)
+	}
+	c.recorder = &recorder{file: file, enc: json.NewEncoder(file)}
+	return nil
+}
+
+// command 记录一条命令的结果
+func (r *recorder) command(command string, lines []string, err error, start time.Time) {
+	entry := cassetteEntry{
+		Type:      entryCommand,
+		Time:      start,
+		Command:   command,
+		Response:  lines,
+		ElapsedMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		entry.Exited = errors.Is(err, ErrProcessExited)
+	}
+	r.write(entry)
+}
+
+// event 记录一行事件输出
+func (r *recorder) event(line string) {
+	r.write(cassetteEntry{Type: entryEvent, Time: time.Now(), Line: line})
+}
+
+func (r *recorder) write(entry cassetteEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_ = r.enc.Encode(entry) // 录制失败不影响正常命令
+}
+
+func (r *recorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// Cassette 是加载到内存的录制文件，可以用NewReplayClient回放
+type Cassette struct {
+	// 会话开始时输出的事件（第一条命令之前录制的输出）
+	prologue []string
+	// 按录制顺序排列的命令
+	entries []*cassetteEntry
+}
+
+// LoadCassette 加载录制文件
+func LoadCassette(path string) (*Cassette, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开录制文件失败: %v", err)
+	}
+	defer file.Close()
+
+	cassette := &Cassette{}
+	var last *cassetteEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry cassetteEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("解析录制文件第 %d 行失败: %v", lineNo, err)
+		}
+
+		switch entry.Type {
+		case entryCommand:
+			// 被取消或超时的命令没有可回放的应答
+			if entry.Error != "" && !entry.Exited {
+				continue
+			}
+			last = &entry
+			cassette.entries = append(cassette.entries, last)
+		case entryEvent:
+			if last == nil {
+				cassette.prologue = append(cassette.prologue, entry.Line)
+			} else {
+				last.events = append(last.events, entry.Line)
+			}
+		default:
+			return nil, fmt.Errorf("录制文件第 %d 行类型未知: %s", lineNo, entry.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取录制文件失败: %v", err)
+	}
+	return cassette, nil
+}
+
+// Len 返回录制文件中可回放的命令数
+func (c *Cassette) Len() int {
+	return len(c.entries)
+}
+
+// NewReplayClient 创建一个回放录制文件的客户端，不需要PioSolver
+//
+// 每条命令按录制顺序取用同名命令的下一条应答；录制时进程在命令执行期间退出的，
+// 回放时同样在该命令处结束会话，客户端的自动重启会继续取用之后录制的应答。
+// 录制文件中没有的命令返回ERROR行。
+func NewReplayClient(cassette *Cassette) *Client {
+	queues := make(map[string][]*cassetteEntry)
+	for _, entry := range cassette.entries {
+		queues[entry.Command] = append(queues[entry.Command], entry)
+	}
+	return newClient(&replayTransport{prologue: cassette.prologue, queues: queues})
+}
+
+// replayTransport 用录制的应答模拟PioSolver会话
+type replayTransport struct {
+	prologue []string
+
+	mu     sync.Mutex
+	queues map[string][]*cassetteEntry
+
+	// 当前会话
+	session *replaySession
+}
+
+// replaySession 是一次open建立的回放会话
+type replaySession struct {
+	in       *io.PipeReader
+	out      *io.PipeWriter
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func (t *replayTransport) open(ctx context.Context) (io.WriteCloser, io.ReadCloser, error) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	s := &replaySession{in: inR, out: outW, done: make(chan struct{})}
+	t.session = s
+
+	go func() {
+		defer close(s.done)
+		t.serve(s)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.stop()
+		case <-s.done:
+		}
+	}()
+	return inW, outR, nil
+}
+
+func (t *replayTransport) wait() error {
+	<-t.session.done
+	return nil
+}
+
+func (t *replayTransport) kill() error {
+	if t.session != nil {
+		t.session.stop()
+	}
+	return nil
+}
+
+// stop 结束会话：输出端EOF，命令写入端返回错误
+func (s *replaySession) stop() {
+	s.stopOnce.Do(func() {
+		s.out.Close()
+		s.in.CloseWithError(ErrProcessExited)
+	})
+}
+
+// serve 逐行读取命令并输出录制的应答，直到exit、输入关闭或录制中的进程退出点
+func (t *replayTransport) serve(s *replaySession) {
+	defer s.stop()
+
+	w := bufio.NewWriter(s.out)
+	writeLines := func(lines ...string) bool {
+		for _, line := range lines {
+			w.WriteString(line)
+			w.WriteByte('\n')
+		}
+		return w.Flush() == nil
+	}
+
+	if !writeLines(t.prologue...) {
+		return
+	}
+
+	endString := "PIO_END"
+	scanner := bufio.NewScanner(s.in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		command := strings.TrimSpace(scanner.Text())
+		if command == "" {
+			continue
+		}
+		if command == "exit" {
+			return
+		}
+		if args := strings.Fields(command); len(args) == 2 && args[0] == "set_end_string" {
+			endString = args[1]
+		}
+
+		entry := t.next(command)
+		if entry == nil && command == "is_ready" {
+			// 启动握手和健康检查的is_ready在录制中不够用时直接应答
+			entry = &cassetteEntry{Response: []string{"is_ready ok!"}}
+		}
+		if entry == nil {
+			if !writeLines(fmt.Sprintf("ERROR: command not found in cassette: %s", command), endString) {
+				return
+			}
+			continue
+		}
+		if entry.Exited {
+			// 录制时进程在这里退出
+			return
+		}
+		if !writeLines(entry.Response...) || !writeLines(endString) || !writeLines(entry.events...) {
+			return
+		}
+	}
+}
+
+// next 取出命令的下一条录制应答
+func (t *replayTransport) next(command string) *cassetteEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	queue := t.queues[command]
+	if len(queue) == 0 {
+		return nil
+	}
+	t.queues[command] = queue[1:]
+	return queue[0]
+}
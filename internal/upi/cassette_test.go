@@ -0,0 +1,80 @@
+package upi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// querySession 在已启动的客户端上执行一组查询，返回各查询结果的文本形式
+// （EV中被公牌阻挡的手牌为NaN，用文本比较代替reflect.DeepEqual）
+func querySession(t *testing.T, client *Client) []string {
+	t.Helper()
+	if err := client.LoadTree(fixturePath); err != nil {
+		t.Fatalf("LoadTree: %v", err)
+	}
+	var results []string
+	add := func(v ...any) { results = append(results, fmt.Sprint(v...)) }
+
+	add(client.ShowEffectiveStack())
+	for _, node := range []string{"r:0", "r:0:c", "r:0:b20"} {
+		add(client.ShowNode(node))
+		add(client.ShowChildren(node))
+		add(client.ShowStrategy(node))
+		add(client.CalcEV("OOP", node))
+		add(client.CalcEqNode("IP", node))
+	}
+	return results
+}
+
+func TestCassetteRecordReplay(t *testing.T) {
+	// 录制期间fakepio崩溃一次，回放时客户端同样重启并继续取用录制的应答
+	t.Setenv("FAKEPIO_CRASH_AFTER", strconv.Itoa(handshakeCommands+8))
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+
+	recording := NewClient(fakepioExe, t.TempDir())
+	if err := recording.RecordTo(path); err != nil {
+		t.Fatalf("RecordTo: %v", err)
+	}
+	if err := recording.Start(context.Background()); err != nil {
+		t.Fatalf("启动fakepio失败: %v", err)
+	}
+	want := querySession(t, recording)
+	restarts := recording.Restarts()
+	recording.Close()
+	if restarts == 0 {
+		t.Fatal("录制期间fakepio应崩溃并重启")
+	}
+	if want[0] != "370 <nil>" {
+		t.Fatalf("录制时查询失败: %s", want[0])
+	}
+
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("LoadCassette: %v", err)
+	}
+	replay := NewReplayClient(cassette)
+	if err := replay.Start(context.Background()); err != nil {
+		t.Fatalf("启动回放客户端失败: %v", err)
+	}
+	defer replay.Close()
+	got := querySession(t, replay)
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("第 %d 个查询的回放结果与录制时不同", i+1)
+		}
+	}
+	if replay.Restarts() != restarts {
+		t.Errorf("回放时重启 %d 次，录制时 %d 次", replay.Restarts(), restarts)
+	}
+
+	// 录制文件中没有的命令返回ERROR
+	var cmdErr *CommandError
+	if _, err := replay.ShowNode("r:0:no_such_node"); !errors.As(err, &cmdErr) {
+		t.Errorf("录制中没有的命令应返回*CommandError，实际 %v", err)
+	}
+}
@@ -1,211 +1,447 @@
 package upi
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"piodatasolver/model"
 )
 
+// resyncTimeout 是命令被取消后等待PioSolver输出结束标记以重新同步的最长时间，超时则终止进程
+const resyncTimeout = 5 * time.Second
+
+// defaultStartupTimeout 是启动后等待PioSolver应答第一条命令的默认最长时间
+const defaultStartupTimeout = 60 * time.Second
+
+// startupPollInterval 是启动握手期间检查进程状态的间隔
+const startupPollInterval = 100 * time.Millisecond
+
+// readyTimeout 是握手中is_ready确认的超时时间
+const readyTimeout = 5 * time.Second
+
+// defaultMaxRestarts 是查询命令因进程崩溃失败时默认的最大重启重试次数
+const defaultMaxRestarts = 3
+
+// ErrProcessExited 表示PioSolver进程在命令完成前退出（标准输出EOF或写入管道失败）
+var ErrProcessExited = errors.New("PioSolver进程已退出")
+
 // Client 表示一个PioSolver UPI客户端
+//
+// 客户端启动后由一个常驻的读取goroutine负责读取PioSolver的全部输出：
+// 以结束标记切分应答并按发送顺序交给对应的命令，"SOLVER:"行和没有命令在等待时的输出
+// 作为事件发送到Events通道。命令可以被多个goroutine并发调用。
+//
+// 进程意外退出时，查询类方法（LoadTree、ShowNode、CalcEV等）会自动重启PioSolver、
+// 重新设置结束标记并重新加载最近一次load_tree的文件，然后重试失败的命令，最多重试maxRestarts次。
 type Client struct {
-	// PioSolver可执行文件路径
-	exePath string
-	// PioSolver工作目录
-	workingDir string
-	// 进程对象
-	cmd *exec.Cmd
+	// 底层连接：本机进程或录制会话的回放
+	transport transport
 	// 标准输入写入器
 	stdin io.WriteCloser
-	// 标准输出读取器
-	stdout io.ReadCloser
-	// 互斥锁，确保同一时间只有一个命令在执行
+	// 互斥锁，保证命令写入顺序与等待队列顺序一致
 	mu sync.Mutex
 	// 结束标记字符串
 	endString string
-	// 是否已启动
-	started bool
+	// 是否已启动：启动握手成功后才设置，starting表示Start正在进行
+	started  bool
+	starting bool
+	// 客户端生命周期上下文，由Start传入，取消时终止进程并中断进行中的命令
+	ctx context.Context
+	// 输出分发器，由常驻读取goroutine驱动
+	demux *demux
+	// 进程退出后关闭，exitErr为进程的退出状态
+	exited  chan struct{}
+	exitErr error
+	// 会话录制器，为nil时不录制
+	recorder *recorder
+	// 启动握手：等待第一次应答的最长时间，以及启动横幅
+	startupTimeout time.Duration
+	banner         []string
+
+	// 重启锁，保证并发的命令只触发一次重启
+	restartMu sync.Mutex
+	// 查询命令因进程崩溃失败时的最大重启重试次数
+	maxRestarts int
+	// 累计重启次数
+	restarts int
+	// 最近一次成功加载的树文件，重启后重新加载
+	treePath string
 }
 
 // NewClient 创建一个新的PioSolver UPI客户端
 func NewClient(exePath, workingDir string) *Client {
+	return newClient(&execTransport{exePath: exePath, workingDir: workingDir})
+}
+
+// NewTCPClient 创建一个通过TCP连接远程PioSolver的客户端，addr为upibridge的监听地址（host:port）
+// 每次Start或重启都会建立新连接，桥接服务为每个连接启动一个新的PioSolver进程
+func NewTCPClient(addr string) *Client {
+	return newClient(&tcpTransport{addr: addr})
+}
+
+func newClient(t transport) *Client {
 	return &Client{
-		exePath:    exePath,
-		workingDir: workingDir,
-		endString:  "PIO_END", // 默认结束标记
+		transport:      t,
+		endString:      "PIO_END", // 默认结束标记
+		ctx:            context.Background(),
+		maxRestarts:    defaultMaxRestarts,
+		startupTimeout: defaultStartupTimeout,
 	}
 }
 
-// Start 启动PioSolver进程
-func (c *Client) Start() error {
-	if c.started {
+// SetStartupTimeout 设置启动后等待PioSolver应答第一条命令的最长时间
+func (c *Client) SetStartupTimeout(d time.Duration) {
+	c.startupTimeout = d
+}
+
+// SetMaxRestarts 设置查询命令因进程崩溃失败时的最大重启重试次数，0表示不自动重启
+func (c *Client) SetMaxRestarts(n int) {
+	c.maxRestarts = n
+}
+
+// Restarts 返回客户端累计重启PioSolver的次数
+func (c *Client) Restarts() int {
+	c.restartMu.Lock()
+	defer c.restartMu.Unlock()
+	return c.restarts
+}
+
+// Start 启动PioSolver进程，ctx被取消（如Ctrl-C或任务截止时间到达）时进程会被终止
+func (c *Client) Start(ctx context.Context) error {
+	c.mu.Lock()
+	if c.started || c.starting {
+		c.mu.Unlock()
 		return fmt.Errorf("客户端已启动")
 	}
+	c.starting = true
+	c.ctx = ctx
+	c.mu.Unlock()
 
-	// 创建命令
-	c.cmd = exec.Command(c.exePath)
-	c.cmd.Dir = c.workingDir
+	err := c.startProcess()
+	c.mu.Lock()
+	c.starting = false
+	c.mu.Unlock()
+	return err
+}
 
-	// 获取标准输入和输出管道
-	var err error
-	c.stdin, err = c.cmd.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("获取标准输入管道失败: %v", err)
-	}
+// startProcess 启动PioSolver进程和读取goroutine并设置结束标记，握手成功后客户端才可以接受命令
+func (c *Client) startProcess() error {
+	ctx := c.ctx
 
-	c.stdout, err = c.cmd.StdoutPipe()
+	stdin, stdout, err := c.transport.open(ctx)
 	if err != nil {
-		return fmt.Errorf("获取标准输出管道失败: %v", err)
+		return err
 	}
 
-	// 启动进程
-	if err := c.cmd.Start(); err != nil {
-		return fmt.Errorf("启动进程失败: %v", err)
+	// 启动常驻读取goroutine，并在进程退出时记录退出状态
+	d := newDemux(c.endString)
+	if c.recorder != nil {
+		d.onEvent = c.recorder.event
 	}
+	exited := make(chan struct{})
+	go func() {
+		// 读到EOF之后才等待进程退出：Wait会关闭标准输出管道，提前调用可能丢失进程退出前的输出
+		d.run(stdout)
+		err := c.transport.wait()
+		c.mu.Lock()
+		c.exitErr = err
+		c.mu.Unlock()
+		close(exited)
+	}()
 
-	// 等待初始化（可以根据需要调整等待时间）
-	time.Sleep(2 * time.Second)
+	c.mu.Lock()
+	c.stdin, c.demux, c.exited = stdin, d, exited
+	c.mu.Unlock()
 
-	// 设置结束标记字符串
-	if _, err := c.ExecuteCommand(fmt.Sprintf("set_end_string %s", c.endString), 5*time.Second); err != nil {
-		log.Printf("设置结束标记失败: %v", err)
-		// 即使设置失败也继续运行
+	if err := c.handshake(); err != nil {
+		c.kill()
+		return err
 	}
 
+	c.mu.Lock()
 	c.started = true
+	c.mu.Unlock()
 	return nil
 }
 
-// ExecuteCommand 执行一个命令并等待其完成
-func (c *Client) ExecuteCommand(command string, timeout time.Duration) ([]string, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if !c.started && command != fmt.Sprintf("set_end_string %s", c.endString) {
-		return nil, fmt.Errorf("客户端未启动")
+// handshake 等待PioSolver应答set_end_string，确认结束标记生效并记录启动横幅
+//
+// 命令在进程完成初始化之前就写入管道，PioSolver就绪后按顺序处理；等待期间定期检查进程是否已退出，
+// 超过startupTimeout仍未应答则返回错误。之后用is_ready确认应答已按新的结束标记分帧。
+func (c *Client) handshake() error {
+	command := fmt.Sprintf("set_end_string %s", c.endString)
+	lines, err := c.awaitStartup(command)
+	if err != nil {
+		return err
 	}
 
-	// 发送命令
-	if _, err := fmt.Fprintln(c.stdin, command); err != nil {
-		return nil, fmt.Errorf("发送命令失败: %v", err)
+	// 结束标记之前的输出（启动横幅）会与set_end_string的应答在同一帧中
+	ok := -1
+	for i, line := range lines {
+		if line == "set_end_string ok!" {
+			ok = i
+			break
+		}
+	}
+	if ok < 0 {
+		return fmt.Errorf("设置结束标记失败，PioSolver应答: %v", lines)
 	}
 
-	// 读取响应直到遇到结束标记或超时
-	responses, err := c.readResponseUntilEnd(timeout)
+	c.mu.Lock()
+	c.banner = c.demux.preamble()
+	c.mu.Unlock()
+
+	// 确认结束标记已生效：is_ready的应答必须按新的结束标记完整返回
+	ctx, cancel := context.WithTimeout(c.ctx, readyTimeout)
+	defer cancel()
+	start := time.Now()
+	p, err := c.sendStartup(ctx, "is_ready")
+	if err == nil {
+		lines, err = c.wait(ctx, p)
+		if c.recorder != nil {
+			c.recorder.command("is_ready", lines, err, start)
+		}
+	}
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("设置结束标记后is_ready未应答: %v", err)
 	}
-
-	return responses, nil
+	if len(lines) == 0 || lines[len(lines)-1] != "is_ready ok!" {
+		return fmt.Errorf("设置结束标记后is_ready应答异常: %v", lines)
+	}
+	return nil
 }
 
-// readResponseUntilEnd 读取响应直到遇到结束标记或超时
-func (c *Client) readResponseUntilEnd(timeout time.Duration) ([]string, error) {
-	var responses []string
-	reader := bufio.NewReader(c.stdout)
-	doneChan := make(chan bool)
-	errChan := make(chan error)
+// awaitStartup 发送第一条命令并轮询等待应答，期间进程退出、超时或上下文取消都返回明确的错误
+func (c *Client) awaitStartup(command string) ([]string, error) {
+	start := time.Now()
+	p, err := c.sendStartup(c.ctx, command)
+	if err != nil {
+		return nil, fmt.Errorf("发送 '%s' 失败: %v", command, err)
+	}
 
-	// 在goroutine中读取响应
-	go func() {
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				if err == io.EOF {
-					doneChan <- true
-					return
-				}
-				errChan <- err
-				return
+	ticker := time.NewTicker(startupPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case r := <-p.done:
+			if c.recorder != nil {
+				c.recorder.command(command, r.lines, r.err, start)
 			}
+			if r.err != nil {
+				return nil, c.startupExitError(r.err)
+			}
+			return r.lines, nil
+		case <-c.ctx.Done():
+			return nil, fmt.Errorf("等待PioSolver启动时被取消: %v", c.ctx.Err())
+		case <-ticker.C:
+			if time.Since(start) > c.startupTimeout {
+				return nil, fmt.Errorf("PioSolver启动后 %v 内没有应答 '%s'，输出: %v", c.startupTimeout, command, c.demux.preamble())
+			}
+		}
+	}
+}
 
-			// 去除行尾的换行符
-			line = strings.TrimSuffix(line, "\n")
-			line = strings.TrimSuffix(line, "\r")
+// startupExitError 生成进程在启动阶段退出的错误，附带退出状态和已输出的内容（如授权错误信息）
+func (c *Client) startupExitError(err error) error {
+	select {
+	case <-c.exited:
+	case <-time.After(startupPollInterval):
+	}
+	c.mu.Lock()
+	exitErr := c.exitErr
+	c.mu.Unlock()
+	return fmt.Errorf("PioSolver启动阶段退出（%v，退出状态: %v），输出: %v", err, exitErr, c.demux.preamble())
+}
 
-			// 检查是否是结束标记
-			if line == c.endString {
-				doneChan <- true
-				return
-			}
+// Banner 返回PioSolver启动时在第一次应答之前输出的内容（启动横幅）
+func (c *Client) Banner() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.banner...)
+}
 
-			// 将非空行添加到响应列表
-			if line != "" && !strings.HasPrefix(line, "SOLVER:") {
-				responses = append(responses, line)
-			}
+// Version 返回启动横幅中包含版本信息的行（包含"pio"的第一行），没有时返回第一行
+func (c *Client) Version() string {
+	banner := c.Banner()
+	for _, line := range banner {
+		if strings.Contains(strings.ToLower(line), "pio") {
+			return line
 		}
-	}()
+	}
+	if len(banner) > 0 {
+		return banner[0]
+	}
+	return ""
+}
 
-	// 等待读取完成或超时
+// Alive 判断PioSolver进程是否仍在运行且可以接受命令
+func (c *Client) Alive() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.started || c.demux == nil {
+		return false
+	}
 	select {
-	case <-doneChan:
-		return responses, nil
-	case err := <-errChan:
-		return nil, fmt.Errorf("读取响应时出错: %v", err)
-	case <-time.After(timeout):
-		return nil, fmt.Errorf("读取响应超时")
+	case <-c.exited:
+		return false
+	default:
+	}
+	c.demux.mu.Lock()
+	defer c.demux.mu.Unlock()
+	return c.demux.err == nil
+}
+
+// Events 返回PioSolver的事件通道："SOLVER:"开头的行、求解进度报告以及没有命令等待应答时的输出
+// 通道在进程退出后关闭；通道满时新事件会被丢弃，不会阻塞命令应答的读取
+func (c *Client) Events() <-chan string {
+	if c.demux == nil {
+		return nil
+	}
+	return c.demux.events
+}
+
+// ExecuteCommand 执行一个命令并等待其完成，超时或客户端上下文被取消时返回错误
+func (c *Client) ExecuteCommand(command string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, timeout)
+	defer cancel()
+	return c.ExecuteCommandContext(ctx, command)
+}
+
+// ExecuteCommandContext 执行一个命令并等待其完成
+// ctx被取消时会等待本次应答读完以保持命令与应答对应，无法同步时终止PioSolver进程
+func (c *Client) ExecuteCommandContext(ctx context.Context, command string) ([]string, error) {
+	start := time.Now()
+	p, err := c.send(ctx, command)
+	if err != nil {
+		return nil, err
 	}
+	lines, err := c.wait(ctx, p)
+	if c.recorder != nil {
+		c.recorder.command(command, lines, err, start)
+	}
+	return lines, err
 }
 
-// ExecuteGoCommandWithStream 执行go命令并返回实时输出流
-// 这个方法专门用于处理go命令，因为go命令会持续输出而不发送结束标记
-func (c *Client) ExecuteGoCommandWithStream() (<-chan string, <-chan error, error) {
+// send 写入命令并登记到等待队列
+func (c *Client) send(ctx context.Context, command string) (*pendingCommand, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if !c.started {
-		return nil, nil, fmt.Errorf("客户端未启动")
+		return nil, fmt.Errorf("客户端未启动")
 	}
+	return c.sendLocked(ctx, command)
+}
 
-	// 发送go命令
-	if _, err := fmt.Fprintln(c.stdin, "go"); err != nil {
-		return nil, nil, fmt.Errorf("发送go命令失败: %v", err)
+// sendStartup 与send相同，但不要求客户端已启动：启动握手在设置started之前用它发送命令
+func (c *Client) sendStartup(ctx context.Context, command string) (*pendingCommand, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sendLocked(ctx, command)
+}
+
+// sendLocked 在持有mu时写入命令并登记到等待队列
+func (c *Client) sendLocked(ctx context.Context, command string) (*pendingCommand, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("命令 '%s' 未发送: %v", command, err)
 	}
 
-	// 创建输出通道
-	outputChan := make(chan string, 100) // 缓冲通道避免阻塞
-	errChan := make(chan error, 1)
+	// 先登记再写入，保证应答到达时已有对应的等待者
+	p, err := c.demux.enqueue(command)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintln(c.stdin, command); err != nil {
+		// 写入管道失败说明进程已退出
+		err = fmt.Errorf("发送命令失败: %w (%v)", ErrProcessExited, err)
+		c.demux.fail(err)
+		return nil, err
+	}
+	return p, nil
+}
 
-	// 启动goroutine持续读取输出
-	go func() {
-		defer close(outputChan)
-		defer close(errChan)
-
-		reader := bufio.NewReader(c.stdout)
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				if err == io.EOF {
-					// PioSolver进程结束
-					return
-				}
-				errChan <- err
-				return
-			}
+// wait 等待命令的应答
+func (c *Client) wait(ctx context.Context, p *pendingCommand) ([]string, error) {
+	select {
+	case r := <-p.done:
+		if r.err != nil {
+			return nil, fmt.Errorf("读取响应时出错: %w", r.err)
+		}
+		return r.lines, nil
+	case <-ctx.Done():
+	}
 
-			// 处理行
-			line = strings.TrimSuffix(line, "\n")
-			line = strings.TrimSuffix(line, "\r")
+	// 命令被取消：应答到达后会被读取goroutine丢弃，只需确认PioSolver没有卡死
+	select {
+	case r := <-p.done:
+		if r.err == nil {
+			return nil, fmt.Errorf("命令 '%s' 被取消: %v", p.command, ctx.Err())
+		}
+	case <-time.After(resyncTimeout):
+	}
+	c.kill()
+	return nil, fmt.Errorf("命令 '%s' 被取消，无法与PioSolver重新同步，已终止进程: %v", p.command, ctx.Err())
+}
 
-			// 跳过空行
-			if line == "" {
-				continue
-			}
+// kill 强制终止PioSolver进程，之后客户端不可再使用
+func (c *Client) kill() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-			// 发送到输出通道
-			select {
-			case outputChan <- line:
-			default:
-				// 通道满了，跳过这行（避免阻塞）
-			}
+	if c.stdin == nil {
+		return
+	}
+	c.started = false
+	_ = c.stdin.Close()
+	_ = c.transport.kill() // 进程资源由startProcess中的等待goroutine回收
+}
+
+// Restart 终止当前PioSolver进程（如仍在运行）并启动新进程，
+// 重新设置结束标记并重新加载最近一次load_tree的文件
+func (c *Client) Restart() error {
+	c.restartMu.Lock()
+	defer c.restartMu.Unlock()
+	return c.restartLocked()
+}
+
+// restartLocked 在持有restartMu时重启进程
+func (c *Client) restartLocked() error {
+	if err := c.ctx.Err(); err != nil {
+		return fmt.Errorf("客户端上下文已取消，不再重启: %v", err)
+	}
+
+	// 终止旧进程并等待其退出，记录退出状态
+	c.kill()
+	if c.exited != nil {
+		select {
+		case <-c.exited:
+		case <-time.After(resyncTimeout):
+			return fmt.Errorf("等待旧PioSolver进程退出超时")
 		}
-	}()
+		c.mu.Lock()
+		exitErr := c.exitErr
+		c.mu.Unlock()
+		log.Printf("旧PioSolver进程退出状态: %v", exitErr)
+	}
+
+	if err := c.startProcess(); err != nil {
+		return err
+	}
+	c.restarts++
 
-	return outputChan, errChan, nil
+	if c.treePath != "" {
+		if _, err := c.query(fmt.Sprintf("load_tree %s", c.treePath), 30*time.Second); err != nil {
+			return fmt.Errorf("重启后重新加载树 %s 失败: %v", c.treePath, err)
+		}
+	}
+	return nil
 }
 
 // TestConnection 测试连接是否正常，尝试恢复通信
@@ -238,41 +474,172 @@ func (c *Client) IsReady() (bool, error) {
 	return false, nil
 }
 
-// LoadTree 加载树
-func (c *Client) LoadTree(filePath string) ([]string, error) {
-	return c.ExecuteCommand(fmt.Sprintf("load_tree %s", filePath), 30*time.Second)
+// query 执行命令并将ERROR行转换为错误
+func (c *Client) query(command string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, timeout)
+	defer cancel()
+	return c.queryContext(ctx, command)
+}
+
+// queryContext 与query相同，由ctx控制取消和截止时间
+func (c *Client) queryContext(ctx context.Context, command string) ([]string, error) {
+	lines, err := c.ExecuteCommandContext(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkError(command, lines); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// queryWithRestart 执行查询命令，PioSolver进程退出时重启并重试，最多重试maxRestarts次
+func (c *Client) queryWithRestart(command string, timeout time.Duration) ([]string, error) {
+	for attempt := 1; ; attempt++ {
+		lines, err := c.query(command, timeout)
+		if err == nil || !errors.Is(err, ErrProcessExited) || attempt > c.maxRestarts || c.ctx.Err() != nil {
+			return lines, err
+		}
+
+		log.Printf("⚠️  命令 '%s' 执行时PioSolver进程退出，正在重启 (%d/%d)...", command, attempt, c.maxRestarts)
+		if rerr := c.restartAfterExit(); rerr != nil {
+			return nil, fmt.Errorf("%w，重启PioSolver失败: %v", err, rerr)
+		}
+	}
+}
+
+// restartAfterExit 在进程已退出时重启；并发的调用者中只有第一个真正重启，其余直接重试
+func (c *Client) restartAfterExit() error {
+	c.restartMu.Lock()
+	defer c.restartMu.Unlock()
+	if c.Alive() {
+		return nil
+	}
+	return c.restartLocked()
+}
+
+// LoadTree 加载树，成功后记录路径以便进程重启后重新加载
+func (c *Client) LoadTree(filePath string) error {
+	if _, err := c.queryWithRestart(fmt.Sprintf("load_tree %s", filePath), 30*time.Second); err != nil {
+		return err
+	}
+	c.restartMu.Lock()
+	c.treePath = filePath
+	c.restartMu.Unlock()
+	return nil
+}
+
+// ShowHandOrder 获取PioSolver的1326手牌顺序
+func (c *Client) ShowHandOrder() ([]string, error) {
+	lines, err := c.queryWithRestart("show_hand_order", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("show_hand_order返回空响应")
+	}
+	hands := strings.Fields(lines[0])
+	if len(hands) != HandCount {
+		return nil, fmt.Errorf("手牌数量错误: 期望 %d，实际 %d", HandCount, len(hands))
+	}
+	return hands, nil
+}
+
+// ShowEffectiveStack 获取当前树的有效起始筹码
+func (c *Client) ShowEffectiveStack() (float64, error) {
+	lines, err := c.queryWithRestart("show_effective_stack", 10*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	if len(lines) == 0 {
+		return 0, fmt.Errorf("show_effective_stack返回空响应")
+	}
+	stackStr := strings.TrimSpace(lines[0])
+	stack, err := strconv.ParseFloat(stackStr, 64)
+	if err != nil {
+		return 0, fmt.Errorf("解析有效筹码失败: %s, %v", stackStr, err)
+	}
+	return stack, nil
 }
 
 // ShowChildren 显示节点的子节点
-func (c *Client) ShowChildren(node string) ([]string, error) {
-	return c.ExecuteCommand(fmt.Sprintf("show_children %s", node), 10*time.Second)
+func (c *Client) ShowChildren(node string) ([]model.ChildNode, error) {
+	lines, err := c.queryWithRestart(fmt.Sprintf("show_children %s", node), 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return parseChildren(lines)
 }
 
 // ShowNode 显示节点信息
-func (c *Client) ShowNode(node string) ([]string, error) {
-	return c.ExecuteCommand(fmt.Sprintf("show_node %s", node), 10*time.Second)
+func (c *Client) ShowNode(node string) (NodeInfo, error) {
+	lines, err := c.queryWithRestart(fmt.Sprintf("show_node %s", node), 10*time.Second)
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	return parseNodeInfo(lines)
+}
+
+// ShowStrategy 获取节点的策略，每个动作一行，每行1326手牌的频率
+func (c *Client) ShowStrategy(node string) ([][]float64, error) {
+	lines, err := c.queryWithRestart(fmt.Sprintf("show_strategy %s", node), 20*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return parseStrategy(lines)
 }
 
-// ShowStrategy 获取节点的策略
-func (c *Client) ShowStrategy(node string) ([]string, error) {
-	return c.ExecuteCommand(fmt.Sprintf("show_strategy %s", node), 20*time.Second)
+// ShowRange 获取玩家到达节点时1326手牌的范围权重
+func (c *Client) ShowRange(player, node string) ([]float64, error) {
+	if err := validPlayer(player); err != nil {
+		return nil, err
+	}
+	lines, err := c.queryWithRestart(fmt.Sprintf("show_range %s %s", player, node), 20*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return parseRange(lines)
 }
 
-// CalcEV 计算节点的期望值
-func (c *Client) CalcEV(node string) ([]string, error) {
-	return c.ExecuteCommand(fmt.Sprintf("calc_ev %s", node), 20*time.Second)
+// CalcEV 计算玩家在节点的期望值，返回1326手牌的EV和match-up
+func (c *Client) CalcEV(player, node string) (ev, matchup []float64, err error) {
+	if err := validPlayer(player); err != nil {
+		return nil, nil, err
+	}
+	command := fmt.Sprintf("calc_ev %s %s", player, node)
+	lines, err := c.queryWithRestart(command, 20*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseHandPair(command, lines)
 }
 
-// CalcEqNode 计算节点的均衡值
-func (c *Client) CalcEqNode(node string) ([]string, error) {
-	return c.ExecuteCommand(fmt.Sprintf("calc_eq_node %s", node), 20*time.Second)
+// CalcEqNode 计算玩家在节点的胜率，返回1326手牌的EQ和match-up
+func (c *Client) CalcEqNode(player, node string) (eq, matchup []float64, err error) {
+	if err := validPlayer(player); err != nil {
+		return nil, nil, err
+	}
+	command := fmt.Sprintf("calc_eq_node %s %s", player, node)
+	lines, err := c.queryWithRestart(command, 20*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseHandPair(command, lines)
 }
 
 // Close 关闭客户端并结束PioSolver进程
 func (c *Client) Close() error {
+	if c.recorder != nil {
+		defer c.recorder.close()
+	}
+
+	c.mu.Lock()
 	if !c.started {
+		c.mu.Unlock()
 		return nil
 	}
+	c.started = false
+	c.mu.Unlock()
 
 	// 尝试发送exit命令
 	_, _ = fmt.Fprintln(c.stdin, "exit")
@@ -282,19 +649,18 @@ func (c *Client) Close() error {
 		return err
 	}
 
-	// 给一点时间让进程自己退出
-	time.Sleep(1 * time.Second)
-
-	// 终止进程
-	if err := c.cmd.Process.Kill(); err != nil {
-		return err
+	// 给一点时间让进程自己退出，超时则终止进程
+	select {
+	case <-c.exited:
+		return nil
+	case <-time.After(1 * time.Second):
 	}
 
-	c.started = false
-	return nil
+	return c.transport.kill()
 }
 
 // GetStdin 获取标准输入写入器，用于直接发送命令
+// 注意：直接写入的命令不会登记到等待队列，其应答会被当作无主应答丢弃
 func (c *Client) GetStdin() io.WriteCloser {
 	return c.stdin
-} 
\ No newline at end of file
+}
@@ -0,0 +1,419 @@
+package upi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"piodatasolver/internal/fakepio"
+)
+
+// fakepioExe 是TestMain编译的fakepio可执行文件
+var fakepioExe string
+
+// fixturePath 是fakepio加载的合成博弈树
+var fixturePath, _ = filepath.Abs("../../testdata/fakepio/40bb_COvsBB_Ks7d2c.cfr")
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "fakepio")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fakepioExe, err = fakepio.Build(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// handshakeCommands 是Start在握手期间发送的命令数，FAKEPIO_CRASH_AFTER按命令数计算崩溃时机
+const handshakeCommands = 2
+
+// startFakepio 启动一个连接fakepio的客户端并加载fixture，测试结束时关闭
+func startFakepio(t *testing.T) *Client {
+	t.Helper()
+	client := NewClient(fakepioExe, t.TempDir())
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("启动fakepio失败: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if err := client.LoadTree(fixturePath); err != nil {
+		t.Fatalf("LoadTree: %v", err)
+	}
+	return client
+}
+
+// handLine 返回n个用空格分隔的数值
+func handLine(n int, value string) string {
+	return strings.TrimSpace(strings.Repeat(value+" ", n))
+}
+
+func TestParseNodeInfo(t *testing.T) {
+	info, err := parseNodeInfo([]string{"r:0:c", "IP_DEC", "Ks 7d 2c", "0 0 60", "2 children", "flags:"})
+	if err != nil || info.NodeID != "r:0:c" || info.ChildCount != 2 || info.Player() != "IP" {
+		t.Fatalf("parseNodeInfo = %+v, %v", info, err)
+	}
+	if _, err := parseNodeInfo([]string{"r:0:c", "IP_DEC", "Ks 7d 2c", "0 0 60", "two children"}); err == nil {
+		t.Error("无法解析子节点数量时应返回错误")
+	}
+	if err := checkError("show_node r:1", []string{"ERROR: node r:1 not found"}); err == nil {
+		t.Error("ERROR行应转换为错误")
+	}
+}
+
+func TestParseChildrenValidation(t *testing.T) {
+	child := func(i int) []string {
+		return []string{fmt.Sprintf("child %d:", i), fmt.Sprintf("r:0:%d", i), "IP_DEC", "Ks 7d 2c", "0 0 60", "2 children", "flags:"}
+	}
+
+	children, err := parseChildren(append(child(0), child(1)...))
+	if err != nil || len(children) != 2 || children[1].NodeID != "r:0:1" {
+		t.Fatalf("parseChildren = %v, %v", children, err)
+	}
+	if _, err := parseChildren(child(0)[:6]); err == nil {
+		t.Error("行数不是7的整数倍时应返回错误")
+	}
+	if _, err := parseChildren(append(child(0), child(2)...)); err == nil {
+		t.Error("子节点索引不连续时应返回错误")
+	}
+}
+
+func TestParseHandLinesValidation(t *testing.T) {
+	rows, err := parseHandLines([]string{handLine(HandCount, "0.5"), handLine(HandCount, "nan")}, 2)
+	if err != nil || len(rows) != 2 || len(rows[1]) != HandCount {
+		t.Fatalf("parseHandLines = %d 行, %v", len(rows), err)
+	}
+	if _, err := parseHandLines([]string{handLine(HandCount, "0.5"), handLine(HandCount-1, "0.5")}, 2); err == nil {
+		t.Error("某一行宽度不是1326时应返回错误")
+	}
+	if _, err := parseHandLines([]string{handLine(HandCount, "0.5")}, 2); err == nil {
+		t.Error("行数不足时应返回错误")
+	}
+	if _, err := parseHandLines([]string{handLine(HandCount, "x")}, 1); err == nil {
+		t.Error("无法解析的数值应返回错误")
+	}
+}
+
+func TestParseStrategyValidation(t *testing.T) {
+	rows, err := parseStrategy([]string{handLine(HandCount, "0.5"), handLine(HandCount, "0.5")})
+	if err != nil || len(rows) != 2 || len(rows[1]) != HandCount {
+		t.Fatalf("parseStrategy = %d 行, %v", len(rows), err)
+	}
+	if _, err := parseStrategy([]string{handLine(HandCount, "0.5"), handLine(HandCount-1, "0.5")}); err == nil {
+		t.Error("某一行宽度不是1326时应返回错误")
+	}
+	if _, err := parseStrategy(nil); err == nil {
+		t.Error("空应答应返回错误")
+	}
+	if _, _, err := parseHandPair("calc_ev OOP r:0", []string{handLine(HandCount, "1")}); err == nil {
+		t.Error("calc_ev缺少match-up行时应返回错误")
+	}
+}
+
+func TestTypedQueriesAgainstFakepio(t *testing.T) {
+	client := startFakepio(t)
+
+	if stack, err := client.ShowEffectiveStack(); err != nil || stack != 370 {
+		t.Errorf("ShowEffectiveStack = %v, %v", stack, err)
+	}
+	if hands, err := client.ShowHandOrder(); err != nil || len(hands) != HandCount {
+		t.Errorf("ShowHandOrder = %d 手牌, %v", len(hands), err)
+	}
+	children, err := client.ShowChildren("r:0")
+	if err != nil || len(children) != 2 {
+		t.Fatalf("ShowChildren = %v, %v", children, err)
+	}
+	strategy, err := client.ShowStrategy("r:0")
+	if err != nil || len(strategy) != len(children) || len(strategy[0]) != HandCount {
+		t.Fatalf("ShowStrategy = %d 行, %v", len(strategy), err)
+	}
+	if ev, matchup, err := client.CalcEV("OOP", "r:0"); err != nil || len(ev) != HandCount || len(matchup) != HandCount {
+		t.Errorf("CalcEV = %d, %d, %v", len(ev), len(matchup), err)
+	}
+	if _, _, err := client.CalcEV("BTN", "r:0"); err == nil {
+		t.Error("无效的玩家应返回错误")
+	}
+
+	_, err = client.ShowNode("r:0:no_such_node")
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Errorf("不存在的节点应返回*CommandError，实际 %v", err)
+	}
+}
+
+func TestShowRangeAgainstFakepio(t *testing.T) {
+	client := startFakepio(t)
+
+	root, err := client.ShowRange("OOP", "r:0")
+	if err != nil || len(root) != HandCount {
+		t.Fatalf("ShowRange = %d 手牌, %v", len(root), err)
+	}
+	strategy, err := client.ShowStrategy("r:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// OOP下注后的范围 = 根节点范围 × 每手牌选择下注（r:0的第二个动作）的频率
+	bet, err := client.ShowRange("OOP", "r:0:b20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := range bet {
+		if want := root[j] * strategy[1][j]; math.Abs(bet[j]-want) > 1e-5 {
+			t.Fatalf("第 %d 手牌的范围权重 = %v，应为 %v", j, bet[j], want)
+		}
+	}
+	// IP还没有行动，范围不变
+	ipRoot, err := client.ShowRange("IP", "r:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipBet, err := client.ShowRange("IP", "r:0:b20")
+	if err != nil || fmt.Sprint(ipRoot) != fmt.Sprint(ipBet) {
+		t.Errorf("IP的范围在OOP行动后改变了: %v", err)
+	}
+
+	if _, err := client.ShowRange("BTN", "r:0"); err == nil {
+		t.Error("无效的玩家应返回错误")
+	}
+	if _, err := parseRange([]string{handLine(HandCount-1, "1")}); err == nil {
+		t.Error("宽度不是1326时应返回错误")
+	}
+}
+
+func TestCommandContextCancellation(t *testing.T) {
+	client := startFakepio(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.ExecuteCommandContext(ctx, "show_node r:0"); err == nil {
+		t.Error("ctx已取消时命令应返回错误")
+	}
+	// 未发送的命令不影响之后的命令与应答对应
+	if info, err := client.ShowNode("r:0"); err != nil || info.NodeID != "r:0" {
+		t.Errorf("ShowNode = %+v, %v", info, err)
+	}
+}
+
+func TestStartContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client := NewClient(fakepioExe, t.TempDir())
+	if err := client.Start(ctx); err != nil {
+		t.Fatalf("启动fakepio失败: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.ExecuteCommand("is_ready", 5*time.Second); err != nil {
+		t.Fatalf("is_ready: %v", err)
+	}
+
+	cancel()
+	if _, err := client.ExecuteCommand("is_ready", 5*time.Second); err == nil {
+		t.Error("Start的ctx取消后命令应返回错误")
+	}
+}
+
+func TestDemuxFraming(t *testing.T) {
+	d := newDemux("END")
+	first, _ := d.enqueue("set_end_string END")
+	second, _ := d.enqueue("show_node r:0")
+
+	output := strings.Join([]string{
+		"PioSOLVER banner",
+		"SOLVER: started",
+		"set_end_string ok!",
+		"",
+		"END",
+		"r:0",
+		"SOLVER: stopped",
+		"OOP_DEC\r",
+		"END",
+		"idle output",
+	}, "\n") + "\n"
+	d.run(strings.NewReader(output))
+
+	if r := <-first.done; r.err != nil || strings.Join(r.lines, "|") != "PioSOLVER banner|set_end_string ok!" {
+		t.Errorf("第一帧: %v, %v", r.lines, r.err)
+	}
+	if r := <-second.done; r.err != nil || strings.Join(r.lines, "|") != "r:0|OOP_DEC" {
+		t.Errorf("第二帧: %v, %v", r.lines, r.err)
+	}
+
+	var events []string
+	for e := range d.events {
+		events = append(events, e)
+	}
+	if got := strings.Join(events, "|"); got != "SOLVER: started|SOLVER: stopped|idle output" {
+		t.Errorf("事件 = %q", got)
+	}
+	if got := d.preamble(); len(got) != 1 || got[0] != "PioSOLVER banner" {
+		t.Errorf("启动横幅 = %v", got)
+	}
+}
+
+func TestDemuxEOFFailsPending(t *testing.T) {
+	d := newDemux("END")
+	p, _ := d.enqueue("show_node r:0")
+	d.run(strings.NewReader("r:0\nOOP_DEC\n"))
+
+	if r := <-p.done; r.err == nil {
+		t.Error("未完成的命令应收到错误")
+	}
+	if _, err := d.enqueue("is_ready"); err == nil {
+		t.Error("EOF之后的命令应直接失败")
+	}
+}
+
+func TestSolverEventsAgainstFakepio(t *testing.T) {
+	client := startFakepio(t)
+
+	if _, err := client.ExecuteCommand("go", 5*time.Second); err != nil {
+		t.Fatalf("go: %v", err)
+	}
+	// 求解期间的命令应答不受SOLVER:事件影响
+	if _, err := client.ShowEffectiveStack(); err != nil {
+		t.Errorf("求解期间查询失败: %v", err)
+	}
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case e := <-client.Events():
+			if strings.HasPrefix(e, "SOLVER: stopped") {
+				return
+			}
+		case <-timeout:
+			t.Fatal("没有收到SOLVER: stopped事件")
+		}
+	}
+}
+
+func TestCrashRestart(t *testing.T) {
+	// 每个fakepio进程在握手和load_tree之后再处理3条查询，第4条查询时崩溃
+	t.Setenv("FAKEPIO_CRASH_AFTER", strconv.Itoa(handshakeCommands+4))
+	client := startFakepio(t)
+
+	for i := 0; i < 10; i++ {
+		if _, err := client.ShowNode("r:0"); err != nil {
+			t.Fatalf("第 %d 次查询失败: %v", i+1, err)
+		}
+	}
+	if client.Restarts() == 0 {
+		t.Error("fakepio崩溃后客户端应重启")
+	}
+}
+
+func TestCrashWithoutRestart(t *testing.T) {
+	t.Setenv("FAKEPIO_CRASH_AFTER", strconv.Itoa(handshakeCommands+2))
+	client := startFakepio(t)
+	client.SetMaxRestarts(0)
+
+	_, err := client.ShowNode("r:0")
+	if err != nil {
+		t.Fatalf("崩溃前的查询失败: %v", err)
+	}
+	_, err = client.ShowNode("r:0")
+	if !errors.Is(err, ErrProcessExited) {
+		t.Fatalf("崩溃后应返回ErrProcessExited，实际 %v", err)
+	}
+	if client.Alive() {
+		t.Error("进程崩溃后Alive应返回false")
+	}
+}
+
+func TestOutputBeforeExitIsDelivered(t *testing.T) {
+	// 进程写出应答后立即退出，应答不能因为等待进程退出而丢失
+	t.Setenv("FAKEPIO_CRASH_AFTER", strconv.Itoa(handshakeCommands+2))
+	for i := 0; i < 10; i++ {
+		client := startFakepio(t)
+		client.SetMaxRestarts(0)
+
+		pending, err := client.sendBatch(context.Background(), []string{"show_strategy r:0", "show_node r:0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines, err := client.wait(context.Background(), pending[0]); err != nil || len(lines) != 2 {
+			t.Fatalf("退出前写出的应答 = %d 行, %v", len(lines), err)
+		}
+		if _, err := client.wait(context.Background(), pending[1]); !errors.Is(err, ErrProcessExited) {
+			t.Fatalf("退出后的命令应返回ErrProcessExited，实际 %v", err)
+		}
+	}
+}
+
+func TestStartupHandshake(t *testing.T) {
+	client := startFakepio(t)
+	if got := client.Version(); got != fakepio.Banner {
+		t.Errorf("Version = %q", got)
+	}
+}
+
+func TestStartupExit(t *testing.T) {
+	// fixture不存在时fakepio在输出横幅之前退出
+	t.Setenv("FAKEPIO_TREE", filepath.Join(t.TempDir(), "missing.cfr"))
+	client := NewClient(fakepioExe, t.TempDir())
+	start := time.Now()
+	err := client.Start(context.Background())
+	if err == nil {
+		client.Close()
+		t.Fatal("进程启动阶段退出时Start应返回错误")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("进程退出后Start用了 %v 才返回", elapsed)
+	}
+	if client.Alive() {
+		t.Error("启动失败后Alive应返回false")
+	}
+	if _, err := client.ExecuteCommand("is_ready", time.Second); err == nil {
+		t.Error("启动失败后不应再接受命令")
+	}
+}
+
+func TestNotStartedDuringHandshake(t *testing.T) {
+	// 接受连接但从不应答的服务端，Start停在握手阶段
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	client := NewTCPClient(listener.Addr().String())
+	client.SetStartupTimeout(time.Second)
+	started := make(chan error, 1)
+	go func() { started <- client.Start(context.Background()) }()
+
+	time.Sleep(200 * time.Millisecond)
+	if client.Alive() {
+		t.Error("握手完成前Alive应返回false")
+	}
+	if _, err := client.ExecuteCommand("is_ready", time.Second); err == nil {
+		t.Error("握手完成前不应接受命令")
+	}
+	if err := client.Start(context.Background()); err == nil {
+		t.Error("Start进行中再次调用应返回错误")
+	}
+	if err := <-started; err == nil {
+		t.Fatal("服务端不应答时Start应返回错误")
+	}
+	if client.Alive() {
+		t.Error("握手失败后Alive应返回false")
+	}
+}
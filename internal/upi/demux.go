@@ -0,0 +1,170 @@
+package upi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+// eventBufferSize 是事件通道的缓冲大小
+const eventBufferSize = 256
+
+// response 是一条命令的完整应答
+type response struct {
+	lines []string
+	err   error
+}
+
+// pendingCommand 是已发送、正在等待应答的命令
+type pendingCommand struct {
+	command string
+	done    chan response // 缓冲为1，读取goroutine投递时不会阻塞
+}
+
+// demux 是常驻读取goroutine的状态：按结束标记切分输出，
+// 依照发送顺序把应答交给等待队列的队首，"SOLVER:"行、求解进度报告和没有命令等待时的输出作为事件分发
+type demux struct {
+	endString string
+	events    chan string
+	// onEvent 在每个事件分发前被调用（用于会话录制），可以为nil
+	onEvent func(line string)
+
+	mu      sync.Mutex
+	pending []*pendingCommand
+	err     error // 读取结束的原因，非nil后不再接受新命令
+	// 第一帧应答之前的输出（启动横幅），framed在第一帧应答结束后置为true
+	banner []string
+	framed bool
+}
+
+func newDemux(endString string) *demux {
+	return &demux{
+		endString: endString,
+		events:    make(chan string, eventBufferSize),
+	}
+}
+
+// enqueue 登记一条等待应答的命令
+func (d *demux) enqueue(command string) (*pendingCommand, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return nil, d.err
+	}
+	p := &pendingCommand{command: command, done: make(chan response, 1)}
+	d.pending = append(d.pending, p)
+	return p, nil
+}
+
+// hasPending 判断是否有命令在等待应答
+func (d *demux) hasPending() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.pending) > 0
+}
+
+// deliver 把一帧应答交给队首命令；已取消的命令不再读取，应答直接丢弃
+func (d *demux) deliver(lines []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.pending) == 0 {
+		d.framed = true
+		log.Printf("收到无主应答，已丢弃: %v", lines)
+		return
+	}
+	d.framed = true
+	p := d.pending[0]
+	d.pending = d.pending[1:]
+	p.done <- response{lines: lines}
+}
+
+// record 记录第一帧应答之前的输出
+func (d *demux) record(line string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.framed {
+		d.banner = append(d.banner, line)
+	}
+}
+
+// preamble 返回第一帧应答之前的输出，不含set_end_string自身的应答
+func (d *demux) preamble() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var lines []string
+	for _, line := range d.banner {
+		if line != "set_end_string ok!" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// fail 结束分发：所有等待中的命令都收到错误，之后的命令直接失败
+func (d *demux) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil {
+		d.err = err
+	}
+	for _, p := range d.pending {
+		p.done <- response{err: d.err}
+	}
+	d.pending = nil
+}
+
+// emit 发送事件，通道满时丢弃
+func (d *demux) emit(line string) {
+	if d.onEvent != nil {
+		d.onEvent(line)
+	}
+	select {
+	case d.events <- line:
+	default:
+	}
+}
+
+// run 读取PioSolver的全部输出，直到标准输出EOF（进程退出）或读取出错时返回
+func (d *demux) run(r io.Reader) {
+	defer close(d.events)
+
+	reader := bufio.NewReader(r)
+	var current []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = ErrProcessExited
+			} else {
+				err = fmt.Errorf("%w: %v", ErrProcessExited, err)
+			}
+			d.fail(err)
+			return
+		}
+
+		// 去除行尾的换行符
+		line = strings.TrimSuffix(line, "\n")
+		line = strings.TrimSuffix(line, "\r")
+
+		switch {
+		case line == d.endString:
+			// 一帧应答结束
+			d.deliver(current)
+			current = nil
+		case strings.HasPrefix(line, "SOLVER:"), isProgressLine(line):
+			// 求解状态和进度报告在后台求解期间随时输出，即使有命令在等待应答也作为事件分发
+			d.emit(line)
+		case line == "":
+			// 跳过空行
+		case d.hasPending():
+			d.record(line)
+			current = append(current, line)
+		default:
+			d.record(line)
+			d.emit(line)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package upi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// dumpTreeTimeout 是等待dump_tree应答的最长时间，完整保存大树可能需要数分钟
+	dumpTreeTimeout = 10 * time.Minute
+	// dumpStableTimeout 是等待导出文件大小稳定的最长时间
+	dumpStableTimeout = 30 * time.Second
+	// dumpStableInterval 是检查导出文件大小的间隔，连续两次大小相同视为写入完成
+	dumpStableInterval = 500 * time.Millisecond
+	// dumpTempSuffix 是DumpTreeAtomic导出期间临时文件名的后缀
+	dumpTempSuffix = ".tmp"
+)
+
+// DumpTree 保存当前的树并校验导出结果，mode为 full、no_rivers 或 no_turns
+//
+// 等待PioSolver应答dump_tree后，检查文件存在、大小非零且不再变化（仅限本机进程，
+// 远程PioSolver的文件不在本机），最后用load_tree重新加载确认文件可读；
+// 任一步失败都返回错误。校验成功后当前加载的树即为导出的文件。
+func (c *Client) DumpTree(path, mode string) error {
+	switch mode {
+	case "full", "no_rivers", "no_turns":
+	default:
+		return fmt.Errorf("无效的导出模式: %s（应为full、no_rivers或no_turns）", mode)
+	}
+
+	// 保存命令会改变磁盘状态，进程退出时不自动重试
+	if _, err := c.query(fmt.Sprintf(`dump_tree "%s" %s`, path, mode), dumpTreeTimeout); err != nil {
+		return fmt.Errorf("导出树失败: %v", err)
+	}
+
+	if t, ok := c.transport.(*execTransport); ok {
+		if err := waitFileStable(t.localPath(path)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.query(fmt.Sprintf(`load_tree "%s"`, path), 5*time.Minute); err != nil {
+		return fmt.Errorf("导出文件无法重新加载: %v", err)
+	}
+	c.restartMu.Lock()
+	c.treePath = path
+	c.restartMu.Unlock()
+	return nil
+}
+
+// DumpTreeAtomic 与DumpTree相同，但先导出到 path+".tmp"，校验成功后才重命名为path，
+// 中断或校验失败时不会留下不完整的path，已存在的path也不会被破坏；进程重启时从path重新加载。
+// 远程PioSolver的文件不在本机，无法重命名，直接导出到path。
+func (c *Client) DumpTreeAtomic(path, mode string) error {
+	t, ok := c.transport.(*execTransport)
+	if !ok {
+		return c.DumpTree(path, mode)
+	}
+
+	tmp := path + dumpTempSuffix
+	if err := c.DumpTree(tmp, mode); err != nil {
+		if rerr := os.Remove(t.localPath(tmp)); rerr != nil && !os.IsNotExist(rerr) {
+			return fmt.Errorf("%v（删除临时文件失败: %v）", err, rerr)
+		}
+		return err
+	}
+	if err := os.Rename(t.localPath(tmp), t.localPath(path)); err != nil {
+		return fmt.Errorf("重命名导出文件失败: %v", err)
+	}
+	c.restartMu.Lock()
+	c.treePath = path
+	c.restartMu.Unlock()
+	return nil
+}
+
+// localPath 返回PioSolver使用的路径在本机上的位置：相对路径相对于PioSolver的工作目录
+func (t *execTransport) localPath(path string) string {
+	if !filepath.IsAbs(path) && t.workingDir != "" {
+		return filepath.Join(t.workingDir, path)
+	}
+	return path
+}
+
+// waitFileStable 等待文件存在、大小非零且连续两次检查大小相同
+func waitFileStable(path string) error {
+	deadline := time.Now().Add(dumpStableTimeout)
+	lastSize := int64(-1)
+	for {
+		info, err := os.Stat(path)
+		switch {
+		case err != nil && !os.IsNotExist(err):
+			return fmt.Errorf("检查导出文件失败: %v", err)
+		case err == nil && info.Size() > 0 && info.Size() == lastSize:
+			return nil
+		case err == nil:
+			lastSize = info.Size()
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("导出文件不存在: %s", path)
+			}
+			if info.Size() == 0 {
+				return fmt.Errorf("导出文件大小为0: %s", path)
+			}
+			return fmt.Errorf("导出文件 %s 在 %v 内大小仍在变化", path, dumpStableTimeout)
+		}
+		time.Sleep(dumpStableInterval)
+	}
+}
@@ -0,0 +1,74 @@
+package upi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDumpTreeAgainstFakepio(t *testing.T) {
+	dir := t.TempDir()
+	client := NewClient(fakepioExe, dir)
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("启动fakepio失败: %v", err)
+	}
+	defer client.Close()
+	if err := client.LoadTree(fixturePath); err != nil {
+		t.Fatalf("LoadTree: %v", err)
+	}
+
+	// 相对路径按PioSolver的工作目录检查
+	if err := client.DumpTree("dump.cfr", "no_rivers"); err != nil {
+		t.Fatalf("DumpTree: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "dump.cfr")); err != nil || info.Size() == 0 {
+		t.Fatalf("导出文件无效: %v", err)
+	}
+	// 导出后当前加载的是导出的文件
+	if info, err := client.ShowNode("r:0"); err != nil || info.NodeID != "r:0" {
+		t.Errorf("重新加载后ShowNode = %+v, %v", info, err)
+	}
+
+	if err := client.DumpTree("dump.cfr", "partial"); err == nil {
+		t.Error("无效的导出模式应返回错误")
+	}
+	if err := client.DumpTree(filepath.Join(dir, "missing", "dump.cfr"), "full"); err == nil {
+		t.Error("无法写入的导出路径应返回错误")
+	}
+}
+
+func TestDumpTreeAtomicAgainstFakepio(t *testing.T) {
+	dir := t.TempDir()
+	client := NewClient(fakepioExe, dir)
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("启动fakepio失败: %v", err)
+	}
+	defer client.Close()
+	if err := client.LoadTree(fixturePath); err != nil {
+		t.Fatalf("LoadTree: %v", err)
+	}
+
+	path := filepath.Join(dir, "atomic.cfr")
+	if err := client.DumpTreeAtomic("atomic.cfr", "full"); err != nil {
+		t.Fatalf("DumpTreeAtomic: %v", err)
+	}
+	first, err := os.ReadFile(path)
+	if err != nil || len(first) == 0 {
+		t.Fatalf("导出文件无效: %v", err)
+	}
+	if _, err := os.Stat(path + dumpTempSuffix); !os.IsNotExist(err) {
+		t.Error("导出成功后不应留下临时文件")
+	}
+
+	// 导出失败时已存在的文件不变，也不留下临时文件
+	if err := client.DumpTreeAtomic("atomic.cfr", "partial"); err == nil {
+		t.Fatal("无效的导出模式应返回错误")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != string(first) {
+		t.Errorf("导出失败后已存在的文件被改变: %v", err)
+	}
+	if _, err := os.Stat(path + dumpTempSuffix); !os.IsNotExist(err) {
+		t.Error("导出失败后不应留下临时文件")
+	}
+}
@@ -0,0 +1,248 @@
+package upi
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryInfo 是show_memory报告的内存情况，单位字节，未报告的项为0
+type MemoryInfo struct {
+	Free  int64
+	Total int64
+}
+
+// TreeEstimate 是estimate_tree估算的博弈树大小
+type TreeEstimate struct {
+	// 节点数，未报告时为0
+	Nodes int64
+	// 估算的内存占用，单位字节
+	Memory int64
+}
+
+// RSSStats 是求解期间对PioSolver进程常驻内存（RSS）的采样结果，单位字节
+type RSSStats struct {
+	Peak    int64
+	Last    int64
+	Samples int
+}
+
+// ShowMemory 获取PioSolver报告的可用内存和总内存
+func (c *Client) ShowMemory() (MemoryInfo, error) {
+	lines, err := c.queryWithRestart("show_memory", 10*time.Second)
+	if err != nil {
+		return MemoryInfo{}, err
+	}
+	return parseMemoryInfo(lines)
+}
+
+// EstimateTree 估算当前设置下博弈树的节点数和内存占用
+func (c *Client) EstimateTree() (TreeEstimate, error) {
+	lines, err := c.query("estimate_tree", buildTreeTimeout)
+	if err != nil {
+		return TreeEstimate{}, err
+	}
+	return parseTreeEstimate(lines)
+}
+
+// Pid 返回本机PioSolver进程的PID；远程或回放的会话返回0
+func (c *Client) Pid() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.transport.(*execTransport)
+	if !ok || t.cmd == nil || t.cmd.Process == nil {
+		return 0
+	}
+	return t.cmd.Process.Pid
+}
+
+// SampleRSS 开始按interval从/proc采样PioSolver进程的RSS，调用返回的stop结束采样并获取结果
+// 无法采样时（远程会话、非Linux系统）结果的Samples为0
+func (c *Client) SampleRSS(interval time.Duration) (stop func() RSSStats) {
+	var (
+		mu    sync.Mutex
+		stats RSSStats
+	)
+	sample := func() bool {
+		pid := c.Pid()
+		if pid == 0 {
+			return false
+		}
+		rss, err := ProcessRSS(pid)
+		if err != nil {
+			return false
+		}
+		mu.Lock()
+		stats.Last = rss
+		stats.Samples++
+		if rss > stats.Peak {
+			stats.Peak = rss
+		}
+		mu.Unlock()
+		return true
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		if !sample() {
+			return
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sample()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() RSSStats {
+		once.Do(func() { close(done) })
+		<-finished
+		mu.Lock()
+		defer mu.Unlock()
+		return stats
+	}
+}
+
+// ProcessRSS 从/proc/<pid>/status读取进程的常驻内存（VmRSS），单位字节
+func ProcessRSS(pid int) (int64, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, fmt.Errorf("读取进程状态失败: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "VmRSS:"); ok {
+			return parseMemoryAmount(value, 1<<10)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("读取进程状态失败: %v", err)
+	}
+	return 0, fmt.Errorf("进程 %d 的状态中没有VmRSS", pid)
+}
+
+// parseMemoryInfo 解析show_memory的应答，如 "free memory: 16384 MB"、"total memory: 32768 MB"
+func parseMemoryInfo(lines []string) (MemoryInfo, error) {
+	var info MemoryInfo
+	found := false
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(key)
+		var target *int64
+		switch {
+		case strings.Contains(key, "free") || strings.Contains(key, "available"):
+			target = &info.Free
+		case strings.Contains(key, "total"):
+			target = &info.Total
+		default:
+			continue
+		}
+		amount, err := parseMemoryAmount(value, 1<<20)
+		if err != nil {
+			return MemoryInfo{}, fmt.Errorf("解析show_memory失败: %s, %v", line, err)
+		}
+		*target = amount
+		found = true
+	}
+	if !found {
+		return MemoryInfo{}, fmt.Errorf("show_memory应答中没有内存信息: %v", lines)
+	}
+	return info, nil
+}
+
+// parseTreeEstimate 解析estimate_tree的应答，如 "nodes: 1234"、"estimated memory: 512.00 MB"
+func parseTreeEstimate(lines []string) (TreeEstimate, error) {
+	var estimate TreeEstimate
+	foundMemory := false
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(key)
+		switch {
+		case strings.Contains(key, "node"):
+			fields := strings.Fields(value)
+			if len(fields) == 0 {
+				continue
+			}
+			nodes, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				return TreeEstimate{}, fmt.Errorf("解析estimate_tree节点数失败: %s", line)
+			}
+			estimate.Nodes = nodes
+		case strings.Contains(key, "memory") || strings.Contains(key, "size"):
+			amount, err := parseMemoryAmount(value, 1<<20)
+			if err != nil {
+				return TreeEstimate{}, fmt.Errorf("解析estimate_tree失败: %s, %v", line, err)
+			}
+			estimate.Memory = amount
+			foundMemory = true
+		}
+	}
+	if !foundMemory {
+		return TreeEstimate{}, fmt.Errorf("estimate_tree应答中没有内存估算: %v", lines)
+	}
+	return estimate, nil
+}
+
+// parseMemoryAmount 解析 "512.5 MB"、"2 GB"、"1048576 kB" 形式的数值，返回字节数
+// 没有单位时按defaultUnit（字节数）换算
+func parseMemoryAmount(s string, defaultUnit int64) (int64, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("数值为空")
+	}
+	number, unit := fields[0], ""
+	if len(fields) > 1 {
+		unit = fields[1]
+	} else {
+		// 数值和单位连在一起，如 "512MB"
+		i := strings.IndexFunc(number, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.'
+		})
+		if i > 0 {
+			number, unit = number[:i], number[i:]
+		}
+	}
+
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("无法解析数值: %s", s)
+	}
+
+	multiplier := defaultUnit
+	switch strings.TrimSuffix(strings.ToLower(unit), "ib") {
+	case "":
+	case "b", "byte", "bytes":
+		multiplier = 1
+	case "k", "kb":
+		multiplier = 1 << 10
+	case "m", "mb":
+		multiplier = 1 << 20
+	case "g", "gb":
+		multiplier = 1 << 30
+	case "t", "tb":
+		multiplier = 1 << 40
+	default:
+		return 0, fmt.Errorf("未知的单位: %s", unit)
+	}
+	return int64(v * float64(multiplier)), nil
+}
@@ -0,0 +1,121 @@
+package upi
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseMemoryAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		unit int64
+		want int64
+		ok   bool
+	}{
+		{"512 MB", 1, 512 << 20, true},
+		{" 2 GB", 1, 2 << 30, true},
+		{"1.5 GiB", 1, 3 << 29, true},
+		{"1048576 kB", 1, 1 << 30, true},
+		{"512MB", 1, 512 << 20, true},
+		{"100 bytes", 1 << 20, 100, true},
+		{"2 TB", 1, 2 << 40, true},
+		{"64", 1 << 20, 64 << 20, true},
+		{"64", 1 << 10, 64 << 10, true},
+		{"", 1, 0, false},
+		{"MB", 1, 0, false},
+		{"12 parsecs", 1, 0, false},
+		{"-5 MB", 1, 0, false},
+		{"1.2.3 GB", 1, 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseMemoryAmount(tt.in, tt.unit)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("parseMemoryAmount(%q, %d) = %d, %v，应为 %d", tt.in, tt.unit, got, err, tt.want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("parseMemoryAmount(%q) = %d，应返回错误", tt.in, got)
+		}
+	}
+}
+
+func TestParseMemoryInfo(t *testing.T) {
+	tests := []struct {
+		lines []string
+		want  MemoryInfo
+		ok    bool
+	}{
+		{[]string{"free memory: 16384 MB", "total memory: 32768 MB"}, MemoryInfo{Free: 16 << 30, Total: 32 << 30}, true},
+		{[]string{"Available: 2 GB"}, MemoryInfo{Free: 2 << 30}, true},
+		{[]string{"total: 1024"}, MemoryInfo{Total: 1 << 30}, true},
+		{[]string{"free memory: lots"}, MemoryInfo{}, false},
+		{[]string{"show_memory ok!"}, MemoryInfo{}, false},
+		{nil, MemoryInfo{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseMemoryInfo(tt.lines)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("parseMemoryInfo(%q) = %+v, %v，应为 %+v", tt.lines, got, err, tt.want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("parseMemoryInfo(%q) = %+v，应返回错误", tt.lines, got)
+		}
+	}
+}
+
+func TestParseTreeEstimate(t *testing.T) {
+	tests := []struct {
+		lines []string
+		want  TreeEstimate
+		ok    bool
+	}{
+		{[]string{"nodes: 1234", "estimated memory: 512.00 MB"}, TreeEstimate{Nodes: 1234, Memory: 512 << 20}, true},
+		{[]string{"tree size: 3 GB"}, TreeEstimate{Memory: 3 << 30}, true},
+		{[]string{"estimated memory: 100"}, TreeEstimate{Memory: 100 << 20}, true},
+		{[]string{"nodes: many", "estimated memory: 1 GB"}, TreeEstimate{}, false},
+		{[]string{"nodes: 1234"}, TreeEstimate{}, false},
+		{[]string{"estimated memory: ? MB"}, TreeEstimate{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseTreeEstimate(tt.lines)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("parseTreeEstimate(%q) = %+v, %v，应为 %+v", tt.lines, got, err, tt.want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("parseTreeEstimate(%q) = %+v，应返回错误", tt.lines, got)
+		}
+	}
+}
+
+func TestMemoryQueriesAgainstFakepio(t *testing.T) {
+	client := startFakepio(t)
+
+	if info, err := client.ShowMemory(); err != nil || info.Free != 16<<30 || info.Total != 32<<30 {
+		t.Errorf("ShowMemory = %+v, %v", info, err)
+	}
+	if err := client.SetBoard("Ks7d2c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetPot(0, 0, 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetEffStack(370); err != nil {
+		t.Fatal(err)
+	}
+	if estimate, err := client.EstimateTree(); err != nil || estimate.Nodes == 0 || estimate.Memory == 0 {
+		t.Errorf("EstimateTree = %+v, %v", estimate, err)
+	}
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if rss, err := ProcessRSS(os.Getpid()); err != nil || rss == 0 {
+		t.Errorf("ProcessRSS = %d, %v", rss, err)
+	}
+	stop := client.SampleRSS(10 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	if stats := stop(); stats.Samples == 0 || stats.Peak == 0 || stats.Peak < stats.Last {
+		t.Errorf("SampleRSS = %+v", stats)
+	}
+}
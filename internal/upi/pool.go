@@ -0,0 +1,209 @@
+package upi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// healthCheckTimeout 是从连接池取出客户端时is_ready检查的超时时间
+const healthCheckTimeout = 5 * time.Second
+
+// Pool 管理一组PioSolver客户端进程，供多个worker并发使用
+//
+// Get取出一个空闲客户端并用IsReady做健康检查，进程已退出或检查失败时自动启动新进程替换；
+// 用完后必须Put归还。归还已关闭的客户端会在下次Get时被替换。
+type Pool struct {
+	size int
+	// 创建未启动的客户端，默认启动本机PioSolver进程
+	factory func() (*Client, error)
+
+	// 连接池上下文，新启动的客户端都使用它，取消时所有进程被终止
+	ctx context.Context
+	// 空闲槽位，nil表示该槽位需要启动新客户端
+	idle chan *Client
+
+	mu     sync.Mutex
+	closed bool
+	// 所有已取出或空闲的客户端，用于Close时统一关闭
+	clients map[*Client]struct{}
+}
+
+// NewPool 创建一个最多包含size个PioSolver进程的连接池
+func NewPool(exePath, workingDir string, size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{
+		size: size,
+		factory: func() (*Client, error) {
+			return NewClient(exePath, workingDir), nil
+		},
+		ctx:     context.Background(),
+		idle:    make(chan *Client, size),
+		clients: make(map[*Client]struct{}),
+	}
+}
+
+// SetFactory 设置创建客户端的函数（如录制或回放客户端），必须在Start之前调用
+func (p *Pool) SetFactory(factory func() (*Client, error)) {
+	p.factory = factory
+}
+
+// Size 返回连接池的并发上限
+func (p *Pool) Size() int {
+	return p.size
+}
+
+// Start 并行启动所有PioSolver进程；部分启动失败时对应槽位留空，在Get时重试
+func (p *Pool) Start(ctx context.Context) error {
+	p.ctx = ctx
+
+	var wg sync.WaitGroup
+	results := make(chan error, p.size)
+	for i := 0; i < p.size; i++ {
+		wg.Add(1)
+		go func(slot int) {
+			defer wg.Done()
+			client, err := p.newClient()
+			if err != nil {
+				log.Printf("连接池启动第 %d 个PioSolver失败: %v", slot+1, err)
+			}
+			p.idle <- client
+			results <- err
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	failed := 0
+	var lastErr error
+	for err := range results {
+		if err != nil {
+			failed++
+			lastErr = err
+		}
+	}
+	if failed == p.size {
+		p.Close()
+		return fmt.Errorf("连接池中所有PioSolver都启动失败: %v", lastErr)
+	}
+	return nil
+}
+
+// Get 取出一个健康的客户端，没有空闲客户端时等待，直到ctx被取消
+func (p *Pool) Get(ctx context.Context) (*Client, error) {
+	var client *Client
+	select {
+	case client = <-p.idle:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("等待空闲PioSolver被取消: %v", ctx.Err())
+	}
+
+	if p.isClosed() {
+		p.idle <- client
+		return nil, fmt.Errorf("连接池已关闭")
+	}
+
+	if client != nil && p.healthy(client) {
+		return client, nil
+	}
+
+	// 进程已退出或健康检查失败，启动新进程替换
+	if client != nil {
+		log.Printf("PioSolver健康检查失败，启动新进程替换")
+		p.discard(client)
+	}
+	replacement, err := p.newClient()
+	if err != nil {
+		p.idle <- nil
+		return nil, fmt.Errorf("替换PioSolver失败: %v", err)
+	}
+	return replacement, nil
+}
+
+// Put 归还客户端；已关闭的客户端会在下次Get时被替换
+func (p *Pool) Put(client *Client) {
+	if client != nil && !client.Alive() {
+		p.discard(client)
+		client = nil
+	}
+	p.idle <- client
+}
+
+// Close 关闭连接池中的所有PioSolver进程
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	clients := make([]*Client, 0, len(p.clients))
+	for client := range p.clients {
+		clients = append(clients, client)
+	}
+	p.clients = make(map[*Client]struct{})
+	p.mu.Unlock()
+
+	var firstErr error
+	for _, client := range clients {
+		if err := client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// newClient 启动一个新的客户端并登记
+func (p *Pool) newClient() (*Client, error) {
+	client, err := p.factory()
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Start(p.ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	log.Printf("PioSolver已就绪: %s", client.Version())
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		client.Close()
+		return nil, fmt.Errorf("连接池已关闭")
+	}
+	p.clients[client] = struct{}{}
+	return client, nil
+}
+
+// discard 关闭并注销客户端
+func (p *Pool) discard(client *Client) {
+	p.mu.Lock()
+	delete(p.clients, client)
+	p.mu.Unlock()
+	client.Close()
+}
+
+// healthy 检查客户端进程是否存活且能应答is_ready
+func (p *Pool) healthy(client *Client) bool {
+	if !client.Alive() {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, healthCheckTimeout)
+	defer cancel()
+	responses, err := client.ExecuteCommandContext(ctx, "is_ready")
+	if err != nil {
+		return false
+	}
+	for _, resp := range responses {
+		if resp == "is_ready ok!" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
@@ -0,0 +1,90 @@
+package upi
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startPool 启动一个包含size个fakepio进程的连接池，测试结束时关闭
+func startPool(t *testing.T, size int) *Pool {
+	t.Helper()
+	pool := NewPool(fakepioExe, t.TempDir(), size)
+	if err := pool.Start(context.Background()); err != nil {
+		t.Fatalf("启动连接池失败: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	return pool
+}
+
+func TestPoolGetWaitsForIdleClient(t *testing.T) {
+	pool := startPool(t, 1)
+
+	client, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	// 没有空闲客户端时Get等待到ctx取消
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(ctx); err == nil {
+		t.Fatal("连接池耗尽时Get应在ctx取消后返回错误")
+	}
+
+	pool.Put(client)
+	again, err := pool.Get(context.Background())
+	if err != nil || again != client {
+		t.Errorf("归还后应取回同一个客户端: %v", err)
+	}
+	pool.Put(again)
+}
+
+func TestPoolReplacesClosedClient(t *testing.T) {
+	pool := startPool(t, 1)
+
+	client, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	client.Close()
+	pool.Put(client)
+
+	replacement, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer pool.Put(replacement)
+	if replacement == client || !replacement.Alive() {
+		t.Error("归还已关闭的客户端后Get应启动新进程替换")
+	}
+	if _, err := replacement.ExecuteCommand("is_ready", 5*time.Second); err != nil {
+		t.Errorf("替换的客户端不可用: %v", err)
+	}
+}
+
+func TestPoolReplacesCrashedClient(t *testing.T) {
+	// 每个fakepio进程只应答握手和一次is_ready健康检查
+	t.Setenv("FAKEPIO_CRASH_AFTER", strconv.Itoa(handshakeCommands+1))
+	pool := startPool(t, 1)
+
+	client, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if _, err := client.ExecuteCommand("is_ready", 5*time.Second); !errors.Is(err, ErrProcessExited) {
+		t.Fatalf("fakepio应已崩溃，实际 %v", err)
+	}
+	pool.Put(client)
+
+	replacement, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer pool.Put(replacement)
+	if replacement == client || !replacement.Alive() {
+		t.Error("归还崩溃的客户端后Get应启动新进程替换")
+	}
+}
@@ -0,0 +1,193 @@
+package upi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"piodatasolver/model"
+)
+
+// HandCount 是PioSolver按手牌输出的数据宽度（1326个起手牌组合）
+const HandCount = 1326
+
+// showChildrenStride 是show_children每个子节点占用的行数
+const showChildrenStride = 7
+
+// CommandError 表示PioSolver对命令返回了ERROR行
+type CommandError struct {
+	Command string // 出错的命令
+	Message string // ERROR行内容
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("命令 '%s' 返回错误: %s", e.Command, e.Message)
+}
+
+// NodeInfo 是show_node返回的节点信息
+type NodeInfo struct {
+	NodeID     string // 节点ID
+	NodeType   string // 节点类型 IP_DEC/OOP_DEC/SPLIT_NODE/END_NODE
+	Board      string // 公牌
+	Pot        string // 底池信息 "oop ip dead"
+	ChildCount int    // 子节点数量
+	Flags      string // 标志
+}
+
+// IsDecision 判断节点是否为决策节点
+func (n NodeInfo) IsDecision() bool {
+	return n.NodeType == "IP_DEC" || n.NodeType == "OOP_DEC"
+}
+
+// Player 返回决策节点行动方对应的UPI玩家名（IP/OOP），非决策节点返回空字符串
+func (n NodeInfo) Player() string {
+	switch n.NodeType {
+	case "IP_DEC":
+		return "IP"
+	case "OOP_DEC":
+		return "OOP"
+	}
+	return ""
+}
+
+// checkError 检查应答中是否包含ERROR行
+func checkError(command string, lines []string) error {
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "ERROR") {
+			return &CommandError{Command: command, Message: strings.TrimSpace(line)}
+		}
+	}
+	return nil
+}
+
+// parseNodeInfo 解析show_node的应答
+func parseNodeInfo(lines []string) (NodeInfo, error) {
+	if len(lines) < 5 {
+		return NodeInfo{}, fmt.Errorf("show_node应答行数不足: %d", len(lines))
+	}
+
+	info := NodeInfo{
+		NodeID:   lines[0],
+		NodeType: lines[1],
+		Board:    lines[2],
+		Pot:      lines[3],
+	}
+	count, err := parseChildCount(lines[4])
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	info.ChildCount = count
+	if len(lines) > 5 {
+		info.Flags = lines[5]
+	}
+	return info, nil
+}
+
+// parseChildCount 解析 "3 children" 形式的子节点数量行
+func parseChildCount(line string) (int, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[1], "child") {
+		return 0, fmt.Errorf("无法解析子节点数量: %s", line)
+	}
+	count, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, fmt.Errorf("无法解析子节点数量: %s", line)
+	}
+	return count, nil
+}
+
+// parseChildren 解析show_children的应答，每个子节点固定7行
+func parseChildren(lines []string) ([]model.ChildNode, error) {
+	if len(lines)%showChildrenStride != 0 {
+		return nil, fmt.Errorf("show_children应答行数 %d 不是 %d 的整数倍", len(lines), showChildrenStride)
+	}
+
+	var children []model.ChildNode
+	for i := 0; i < len(lines); i += showChildrenStride {
+		var index int
+		if _, err := fmt.Sscanf(lines[i], "child %d:", &index); err != nil {
+			return nil, fmt.Errorf("解析子节点索引失败: %s, %v", lines[i], err)
+		}
+		if index != len(children) {
+			return nil, fmt.Errorf("子节点索引不连续: 期望 %d，实际 %d", len(children), index)
+		}
+		children = append(children, model.ChildNode{
+			Index:    index,
+			NodeID:   lines[i+1],
+			NodeType: lines[i+2],
+			Board:    lines[i+3],
+			PotInfo:  lines[i+4],
+			ChildNum: lines[i+5],
+			Flag:     lines[i+6],
+		})
+	}
+	return children, nil
+}
+
+// parseHandLine 解析一行1326个数值，NaN保留为math.NaN()
+func parseHandLine(line string) ([]float64, error) {
+	fields := strings.Fields(line)
+	if len(fields) != HandCount {
+		return nil, fmt.Errorf("数据宽度错误: 期望 %d，实际 %d", HandCount, len(fields))
+	}
+	values := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, fmt.Errorf("解析第 %d 个数值失败: %s", i, f)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// parseHandLines 解析前n行1326宽的数值
+func parseHandLines(lines []string, n int) ([][]float64, error) {
+	if len(lines) < n {
+		return nil, fmt.Errorf("应答行数不足: 期望至少 %d 行，实际 %d 行", n, len(lines))
+	}
+	rows := make([][]float64, n)
+	for i := 0; i < n; i++ {
+		row, err := parseHandLine(lines[i])
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: %v", i+1, err)
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+// parseStrategy 解析show_strategy的应答，每个动作一行
+func parseStrategy(lines []string) ([][]float64, error) {
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("show_strategy返回空响应")
+	}
+	return parseHandLines(lines, len(lines))
+}
+
+// parseHandPair 解析calc_ev/calc_eq_node的应答：第一行为数值，第二行为match-up
+func parseHandPair(command string, lines []string) (values, matchup []float64, err error) {
+	rows, err := parseHandLines(lines, 2)
+	if err != nil {
+		name, _, _ := strings.Cut(command, " ")
+		return nil, nil, fmt.Errorf("解析%s结果失败: %v", name, err)
+	}
+	return rows[0], rows[1], nil
+}
+
+// parseRange 解析show_range的应答：一行1326手牌的范围权重
+func parseRange(lines []string) ([]float64, error) {
+	rows, err := parseHandLines(lines, 1)
+	if err != nil {
+		return nil, fmt.Errorf("解析show_range结果失败: %v", err)
+	}
+	return rows[0], nil
+}
+
+// validPlayer 检查UPI玩家名
+func validPlayer(player string) error {
+	if player != "OOP" && player != "IP" {
+		return fmt.Errorf("无效的玩家: %s（应为OOP或IP）", player)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package upi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stopGracePeriod 是发送stop命令后等待求解器报告停止的最长时间
+const stopGracePeriod = 30 * time.Second
+
+// SolveProgress 是求解过程中PioSolver报告的一次进度
+type SolveProgress struct {
+	// 求解已运行的时间（running time）
+	Elapsed time.Duration
+	// 双方在根节点的EV
+	EVOOP float64
+	EVIP  float64
+	// 当前策略的可剥削值（Exploitable for）
+	Exploitability float64
+	// 第几次进度报告；PioSolver输出迭代次数时为其报告的迭代数
+	Iteration int
+	// 求解器已停止，Reason为停止原因，如 "required accuracy reached"
+	Stopped bool
+	Reason  string
+}
+
+// StopPolicy 决定WaitForSolve何时认为求解完成
+type StopPolicy struct {
+	// 可剥削值小于等于该值时认为完成，求解器仍在运行时发送stop；0表示只等待求解器自行停止
+	TargetExploitability float64
+	// 求解超过该时长时发送stop并返回错误；0表示不限制
+	MaxDuration time.Duration
+	// 超过该时长没有任何进度报告时返回错误；0表示不限制
+	StallTimeout time.Duration
+	// 每次收到进度报告时调用，可以为nil
+	OnProgress func(SolveProgress)
+}
+
+// Solve 发送go命令开始求解，返回解析后的进度流
+// 求解器报告停止（Stopped为true的一项是最后一项）、进程退出或ctx被取消时通道关闭
+func (c *Client) Solve(ctx context.Context) (<-chan SolveProgress, error) {
+	events := c.Events()
+	if events == nil {
+		return nil, fmt.Errorf("客户端未启动")
+	}
+
+	// 丢弃上一次求解残留的事件，避免旧的停止报告被当作本次求解的结果
+drain:
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return nil, ErrProcessExited
+			}
+		default:
+			break drain
+		}
+	}
+
+	lines, err := c.ExecuteCommandContext(ctx, "go")
+	if err != nil {
+		return nil, fmt.Errorf("发送go命令失败: %v", err)
+	}
+	if err := checkError("go", lines); err != nil {
+		return nil, err
+	}
+
+	progress := make(chan SolveProgress, 16)
+	go func() {
+		defer close(progress)
+		var parser progressParser
+		for {
+			select {
+			case line, ok := <-events:
+				if !ok {
+					return
+				}
+				p, ok := parser.feed(line)
+				if !ok {
+					continue
+				}
+				select {
+				case progress <- p:
+				case <-ctx.Done():
+					return
+				}
+				if p.Stopped {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return progress, nil
+}
+
+// Stop 让正在进行的求解停止，求解器随后报告 "SOLVER: stopped"
+func (c *Client) Stop() error {
+	_, err := c.query("stop", 10*time.Second)
+	return err
+}
+
+// WaitForSolve 发送go命令开始求解，并根据解析出的求解状态按policy等待完成，返回最后一次进度
+//
+// 求解器报告停止，或可剥削值达到TargetExploitability（此时发送stop并等待停止报告）时返回；
+// 进程退出、超过MaxDuration、超过StallTimeout没有进度或ctx被取消时返回错误。
+func (c *Client) WaitForSolve(ctx context.Context, policy StopPolicy) (SolveProgress, error) {
+	solveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progress, err := c.Solve(solveCtx)
+	if err != nil {
+		return SolveProgress{}, err
+	}
+
+	start := time.Now()
+	var last SolveProgress
+	lastReport := time.Now()
+	var stopSent time.Time
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case p, ok := <-progress:
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					return last, fmt.Errorf("等待求解被取消: %v", err)
+				}
+				return last, fmt.Errorf("求解未完成: %w", ErrProcessExited)
+			}
+			last = p
+			lastReport = time.Now()
+			if policy.OnProgress != nil {
+				policy.OnProgress(p)
+			}
+			if p.Stopped {
+				return last, nil
+			}
+			if policy.TargetExploitability > 0 && p.Exploitability <= policy.TargetExploitability && stopSent.IsZero() {
+				stopSent = time.Now()
+				if err := c.Stop(); err != nil {
+					return last, fmt.Errorf("可剥削值已达到目标，但发送stop失败: %v", err)
+				}
+			}
+
+		case <-ticker.C:
+			switch {
+			case !stopSent.IsZero() && time.Since(stopSent) > stopGracePeriod:
+				return last, fmt.Errorf("发送stop后 %v 内求解器没有报告停止", stopGracePeriod)
+			case policy.MaxDuration > 0 && time.Since(start) > policy.MaxDuration:
+				if err := c.Stop(); err != nil {
+					log.Printf("求解超时后发送stop失败: %v", err)
+				}
+				return last, fmt.Errorf("求解超时，超过最大求解时间 %v（可剥削值: %.6f）", policy.MaxDuration, last.Exploitability)
+			case policy.StallTimeout > 0 && time.Since(lastReport) > policy.StallTimeout:
+				return last, fmt.Errorf("求解器超过 %v 没有报告进度", policy.StallTimeout)
+			}
+		}
+	}
+}
+
+// progressParser 把求解过程中的输出行组装为SolveProgress
+// PioSolver每次报告输出 running time / EV OOP / EV IP / Exploitable for 几行，以Exploitable for结束
+type progressParser struct {
+	current SolveProgress
+	reports int
+	// PioSolver输出了迭代次数时使用其值
+	iteration int
+}
+
+// feed 处理一行输出，组装出一次完整的进度报告时返回true
+func (p *progressParser) feed(line string) (SolveProgress, bool) {
+	line = strings.TrimSpace(line)
+
+	if rest, ok := strings.CutPrefix(line, "SOLVER:"); ok {
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, "stopped") {
+			return SolveProgress{}, false
+		}
+		result := p.current
+		result.Stopped = true
+		result.Reason = strings.TrimSpace(strings.TrimPrefix(rest, "stopped"))
+		result.Reason = strings.TrimSuffix(strings.TrimPrefix(result.Reason, "("), ")")
+		return result, true
+	}
+
+	key, number, ok := progressField(line)
+	if !ok {
+		return SolveProgress{}, false
+	}
+
+	switch key {
+	case "running time":
+		p.current.Elapsed = time.Duration(number * float64(time.Second))
+	case "ev oop":
+		p.current.EVOOP = number
+	case "ev ip":
+		p.current.EVIP = number
+	case "iteration", "iterations":
+		p.iteration = int(number)
+	case "exploitable for":
+		p.current.Exploitability = number
+		p.reports++
+		p.current.Iteration = p.reports
+		if p.iteration > 0 {
+			p.current.Iteration = p.iteration
+		}
+		return p.current, true
+	}
+	return SolveProgress{}, false
+}
+
+// progressField 解析进度报告中的一行，返回小写的字段名和数值；不是进度报告的行返回false
+func progressField(line string) (string, float64, bool) {
+	key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+	if !ok {
+		return "", 0, false
+	}
+	key = strings.ToLower(strings.TrimSpace(key))
+	switch key {
+	case "running time", "ev oop", "ev ip", "iteration", "iterations", "exploitable for":
+	default:
+		return "", 0, false
+	}
+	number, ok := leadingNumber(value)
+	return key, number, ok
+}
+
+// isProgressLine 判断是否为求解进度报告的一行，这些行随时可能输出，不属于任何命令的应答
+func isProgressLine(line string) bool {
+	_, _, ok := progressField(line)
+	return ok
+}
+
+// leadingNumber 解析字符串中第一个字段的数值，忽略其后的单位等内容
+func leadingNumber(s string) (float64, bool) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(strings.TrimSuffix(fields[0], "s"), 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
@@ -0,0 +1,155 @@
+package upi
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressParser(t *testing.T) {
+	var parser progressParser
+	lines := []string{
+		"running time: 1.50",
+		"EV OOP: 31.200",
+		"EV IP: 28.800",
+		"Exploitable for: 0.420000",
+		"running time: 3.00s",
+		"iterations: 120",
+		"Exploitable for: 0.210000",
+		"unrelated output",
+		"SOLVER: stopped (required accuracy reached)",
+	}
+	var reports []SolveProgress
+	for _, line := range lines {
+		if p, ok := parser.feed(line); ok {
+			reports = append(reports, p)
+		}
+	}
+	if len(reports) != 3 {
+		t.Fatalf("应组装出3次进度报告，实际 %d: %+v", len(reports), reports)
+	}
+	if p := reports[0]; p.Elapsed != 1500*time.Millisecond || p.EVOOP != 31.2 || p.EVIP != 28.8 || p.Exploitability != 0.42 || p.Iteration != 1 {
+		t.Errorf("第一次报告 = %+v", p)
+	}
+	if p := reports[1]; p.Elapsed != 3*time.Second || p.Exploitability != 0.21 || p.Iteration != 120 {
+		t.Errorf("第二次报告 = %+v", p)
+	}
+	if p := reports[2]; !p.Stopped || p.Reason != "required accuracy reached" || p.Exploitability != 0.21 {
+		t.Errorf("停止报告 = %+v", p)
+	}
+
+	for _, line := range lines[:7] {
+		if !isProgressLine(line) {
+			t.Errorf("%q 应识别为进度报告", line)
+		}
+	}
+	for _, line := range []string{"unrelated output", "r:0:c", "EV OOP: n/a", "set_end_string ok!"} {
+		if isProgressLine(line) {
+			t.Errorf("%q 不应识别为进度报告", line)
+		}
+	}
+}
+
+func TestDemuxRoutesProgressWhileCommandPending(t *testing.T) {
+	d := newDemux("END")
+	p, _ := d.enqueue("show_node r:0")
+	d.run(strings.NewReader("r:0\nrunning time: 1.00\nOOP_DEC\nExploitable for: 0.5\nEND\n"))
+
+	if r := <-p.done; r.err != nil || strings.Join(r.lines, "|") != "r:0|OOP_DEC" {
+		t.Errorf("应答 = %v, %v", r.lines, r.err)
+	}
+	var events []string
+	for line := range d.events {
+		events = append(events, line)
+	}
+	if strings.Join(events, "|") != "running time: 1.00|Exploitable for: 0.5" {
+		t.Errorf("事件 = %v", events)
+	}
+}
+
+func TestWaitForSolveUntilSolverStops(t *testing.T) {
+	client := startFakepio(t)
+
+	reports := 0
+	last, err := client.WaitForSolve(context.Background(), StopPolicy{
+		StallTimeout: 5 * time.Second,
+		OnProgress:   func(SolveProgress) { reports++ },
+	})
+	if err != nil {
+		t.Fatalf("WaitForSolve: %v", err)
+	}
+	if !last.Stopped || last.Reason != "required accuracy reached" {
+		t.Errorf("最后一次进度 = %+v", last)
+	}
+	if reports < 2 {
+		t.Errorf("OnProgress只被调用了 %d 次", reports)
+	}
+}
+
+func TestWaitForSolveStopsAtTarget(t *testing.T) {
+	client := startFakepio(t)
+
+	last, err := client.WaitForSolve(context.Background(), StopPolicy{TargetExploitability: 1, StallTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("WaitForSolve: %v", err)
+	}
+	if !last.Stopped || last.Reason != "stopped by user" || last.Exploitability > 1 {
+		t.Errorf("达到目标可剥削值后应发送stop，最后一次进度 = %+v", last)
+	}
+}
+
+func TestWaitForSolveCancelled(t *testing.T) {
+	client := startFakepio(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := client.WaitForSolve(ctx, StopPolicy{}); err == nil {
+		t.Error("ctx取消时WaitForSolve应返回错误")
+	}
+}
+
+func TestWaitForSolveWithConcurrentCommands(t *testing.T) {
+	t.Setenv("FAKEPIO_TICK", "10ms")
+	client := startFakepio(t)
+
+	// 求解期间不停地查询，进度报告不能混入查询的应答
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	queries := 0
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if info, err := client.ShowNode("r:0"); err != nil || info.NodeID != "r:0" || info.ChildCount != 2 {
+				t.Errorf("求解期间ShowNode = %+v, %v", info, err)
+				return
+			}
+			queries++
+		}
+	}()
+
+	reports := 0
+	last, err := client.WaitForSolve(context.Background(), StopPolicy{
+		TargetExploitability: 0.5,
+		StallTimeout:         5 * time.Second,
+		OnProgress:           func(SolveProgress) { reports++ },
+	})
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("WaitForSolve: %v", err)
+	}
+	if !last.Stopped || last.Reason != "stopped by user" || last.Exploitability > 0.5 {
+		t.Errorf("达到目标可剥削值后应发送stop，最后一次进度 = %+v", last)
+	}
+	if reports < 3 || queries == 0 {
+		t.Errorf("收到 %d 次进度报告，完成 %d 次查询", reports, queries)
+	}
+}
@@ -0,0 +1,67 @@
+package upi
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// strategySumTolerance 是每手牌各动作频率之和与1的允许误差
+const strategySumTolerance = 1e-3
+
+// SetStrategy 设置节点的策略，strategy每行对应一个动作（顺序与show_children一致），每行1326手牌的频率
+// 每手牌各动作频率之和必须为1，或全为0（不在范围内或与公牌冲突的手牌）
+func (c *Client) SetStrategy(node string, strategy [][]float64) error {
+	if err := validStrategy(strategy); err != nil {
+		return fmt.Errorf("节点 %s 的策略无效: %v", node, err)
+	}
+
+	var b strings.Builder
+	b.WriteString("set_strategy ")
+	b.WriteString(node)
+	for _, row := range strategy {
+		for _, v := range row {
+			b.WriteByte(' ')
+			b.WriteString(strconv.FormatFloat(v, 'g', 6, 64))
+		}
+	}
+	return c.setup(b.String())
+}
+
+// LockNode 锁定节点，之后的求解中该节点的策略保持不变
+func (c *Client) LockNode(node string) error {
+	return c.setup(fmt.Sprintf("lock_node %s", node))
+}
+
+// UnlockNode 解除节点锁定
+func (c *Client) UnlockNode(node string) error {
+	return c.setup(fmt.Sprintf("unlock_node %s", node))
+}
+
+// validStrategy 检查策略的形状和每手牌的频率之和
+func validStrategy(strategy [][]float64) error {
+	if len(strategy) == 0 {
+		return fmt.Errorf("策略为空")
+	}
+	for a, row := range strategy {
+		if len(row) != HandCount {
+			return fmt.Errorf("第 %d 个动作的频率数量错误: 期望 %d，实际 %d", a+1, HandCount, len(row))
+		}
+		for j, v := range row {
+			if !(v >= 0 && v <= 1) {
+				return fmt.Errorf("第 %d 个动作第 %d 手牌的频率超出[0,1]: %v", a+1, j, v)
+			}
+		}
+	}
+	for j := 0; j < HandCount; j++ {
+		sum := 0.0
+		for _, row := range strategy {
+			sum += row[j]
+		}
+		if sum != 0 && math.Abs(sum-1) > strategySumTolerance {
+			return fmt.Errorf("第 %d 手牌的频率之和为 %.6f，应为1", j, sum)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package upi
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// pureStrategy 返回所有手牌都选择action的策略，blocked中的手牌频率全为0
+func pureStrategy(actions, action int, blocked []bool) [][]float64 {
+	strategy := make([][]float64, actions)
+	for a := range strategy {
+		strategy[a] = make([]float64, HandCount)
+	}
+	for j := 0; j < HandCount; j++ {
+		if !blocked[j] {
+			strategy[action][j] = 1
+		}
+	}
+	return strategy
+}
+
+func TestValidStrategy(t *testing.T) {
+	none := make([]bool, HandCount)
+	if err := validStrategy(pureStrategy(2, 1, none)); err != nil {
+		t.Errorf("纯策略校验失败: %v", err)
+	}
+	blocked := make([]bool, HandCount)
+	blocked[5] = true
+	if err := validStrategy(pureStrategy(2, 0, blocked)); err != nil {
+		t.Errorf("频率全为0的手牌应允许: %v", err)
+	}
+
+	half := pureStrategy(2, 0, none)
+	half[0][7] = 0.5
+	wide := pureStrategy(2, 0, none)
+	wide[1] = wide[1][:HandCount-1]
+	over := pureStrategy(2, 0, none)
+	over[1][3] = 1.5
+	for name, strategy := range map[string][][]float64{"空策略": nil, "频率之和不为1": half, "宽度错误": wide, "频率超出[0,1]": over} {
+		if err := validStrategy(strategy); err == nil {
+			t.Errorf("%s应返回错误", name)
+		}
+	}
+}
+
+func TestLockNodeAgainstFakepio(t *testing.T) {
+	client := startFakepio(t)
+
+	before, _, err := client.CalcEV("OOP", "r:0")
+	if err != nil {
+		t.Fatalf("CalcEV: %v", err)
+	}
+	current, err := client.ShowStrategy("r:0")
+	if err != nil {
+		t.Fatalf("ShowStrategy: %v", err)
+	}
+	blocked := make([]bool, HandCount)
+	for j := range blocked {
+		blocked[j] = current[0][j]+current[1][j] == 0
+	}
+
+	locked := pureStrategy(2, 0, blocked)
+	if err := client.SetStrategy("r:0", locked); err != nil {
+		t.Fatalf("SetStrategy: %v", err)
+	}
+	if err := client.LockNode("r:0"); err != nil {
+		t.Fatalf("LockNode: %v", err)
+	}
+	got, err := client.ShowStrategy("r:0")
+	if err != nil || fmt.Sprint(got) != fmt.Sprint(locked) {
+		t.Fatalf("锁定后的策略与设置的不同: %v", err)
+	}
+
+	if _, err := client.WaitForSolve(context.Background(), StopPolicy{StallTimeout: 5 * time.Second}); err != nil {
+		t.Fatalf("WaitForSolve: %v", err)
+	}
+	after, _, err := client.CalcEV("OOP", "r:0")
+	if err != nil {
+		t.Fatalf("CalcEV: %v", err)
+	}
+	changed := false
+	for j := range before {
+		if !math.IsNaN(before[j]) && before[j] != after[j] {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		t.Error("锁定节点并重新求解后EV应改变")
+	}
+	if err := client.UnlockNode("r:0"); err != nil {
+		t.Errorf("UnlockNode: %v", err)
+	}
+}
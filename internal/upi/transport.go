@@ -0,0 +1,146 @@
+package upi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// transport 是客户端与PioSolver之间的底层连接
+//
+// 每次open建立一个新的会话（如启动一个新进程），返回命令写入端和输出读取端；
+// 进程重启时客户端会在同一个transport上再次调用open。
+type transport interface {
+	// open 建立会话，ctx被取消时会话应被终止
+	open(ctx context.Context) (io.WriteCloser, io.ReadCloser, error)
+	// wait 阻塞直到当前会话结束，返回退出状态
+	wait() error
+	// kill 强制结束当前会话
+	kill() error
+}
+
+// execTransport 以子进程方式启动本机的PioSolver
+type execTransport struct {
+	exePath    string
+	workingDir string
+	cmd        *exec.Cmd
+}
+
+func (t *execTransport) open(ctx context.Context) (io.WriteCloser, io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, t.exePath)
+	cmd.Dir = t.workingDir
+
+	// 获取标准输入和输出管道
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("获取标准输入管道失败: %v", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("获取标准输出管道失败: %v", err)
+	}
+
+	// 启动进程
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("启动进程失败: %v", err)
+	}
+
+	t.cmd = cmd
+	return stdin, stdout, nil
+}
+
+func (t *execTransport) wait() error {
+	return t.cmd.Wait()
+}
+
+func (t *execTransport) kill() error {
+	if t.cmd == nil || t.cmd.Process == nil {
+		return nil
+	}
+	if err := t.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	return nil
+}
+
+// tcpTransport 通过TCP连接到upibridge，由远程机器上的PioSolver应答
+type tcpTransport struct {
+	addr string
+
+	conn net.Conn
+	done chan struct{}
+}
+
+func (t *tcpTransport) open(ctx context.Context) (io.WriteCloser, io.ReadCloser, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("连接PioSolver桥接服务 %s 失败: %v", t.addr, err)
+	}
+
+	done := make(chan struct{})
+	once := &sync.Once{}
+	t.conn, t.done = conn, done
+
+	// ctx被取消时断开连接，远程进程随之被终止
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return tcpWriter{conn}, &tcpReader{conn: conn, done: done, once: once}, nil
+}
+
+func (t *tcpTransport) wait() error {
+	<-t.done
+	return nil
+}
+
+func (t *tcpTransport) kill() error {
+	if t.conn == nil {
+		return nil
+	}
+	return t.conn.Close()
+}
+
+// tcpWriter 关闭时只关闭写方向，远程PioSolver的标准输入收到EOF后自行退出，剩余输出仍可读取
+type tcpWriter struct {
+	net.Conn
+}
+
+func (w tcpWriter) Close() error {
+	if tc, ok := w.Conn.(*net.TCPConn); ok {
+		return tc.CloseWrite()
+	}
+	return w.Conn.Close()
+}
+
+// tcpReader 读到EOF或出错时标记会话结束
+type tcpReader struct {
+	conn net.Conn
+	done chan struct{}
+	once *sync.Once
+}
+
+func (r *tcpReader) Read(p []byte) (int, error) {
+	n, err := r.conn.Read(p)
+	if err != nil {
+		r.once.Do(func() {
+			r.conn.Close()
+			close(r.done)
+		})
+	}
+	return n, err
+}
+
+func (r *tcpReader) Close() error {
+	return r.conn.Close()
+}
@@ -0,0 +1,361 @@
+package upi
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setupTimeout 是建树类设置命令的超时时间
+const setupTimeout = 10 * time.Second
+
+// buildTreeTimeout 是build_tree/estimate_tree的超时时间，大树的构建可能需要较长时间
+const buildTreeTimeout = 5 * time.Minute
+
+// TreeConfig 描述一棵待构建的博弈树，对应PioSolver建树脚本中的各条命令
+type TreeConfig struct {
+	// 公牌，3到5张，如 "Ks7d2c" 或 "Ks 7d 2c"
+	Board string
+	// 底池：OOP投入、IP投入、死钱
+	Pot [3]float64
+	// 有效筹码
+	EffectiveStack float64
+	// 双方范围，1326个权重（按show_hand_order的顺序），为nil时不设置
+	OOPRange []float64
+	IPRange  []float64
+	// 是否在翻牌/转牌启用同构压缩
+	FlopIsomorphism bool
+	TurnIsomorphism bool
+	// 下注线：每条线是依次累计投入的筹码数，如 []int{60, 60, 180, 180}
+	Lines [][]int
+	// 目标精度（可剥削值），0表示不设置
+	Accuracy float64
+}
+
+// Validate 检查配置是否合法
+func (cfg TreeConfig) Validate() error {
+	if _, err := parseBoard(cfg.Board); err != nil {
+		return err
+	}
+	if err := validPot(cfg.Pot[0], cfg.Pot[1], cfg.Pot[2]); err != nil {
+		return err
+	}
+	if !(cfg.EffectiveStack > 0) {
+		return fmt.Errorf("有效筹码必须大于0: %v", cfg.EffectiveStack)
+	}
+	if cfg.OOPRange != nil {
+		if err := validRange(cfg.OOPRange); err != nil {
+			return fmt.Errorf("OOP范围无效: %v", err)
+		}
+	}
+	if cfg.IPRange != nil {
+		if err := validRange(cfg.IPRange); err != nil {
+			return fmt.Errorf("IP范围无效: %v", err)
+		}
+	}
+	for i, line := range cfg.Lines {
+		if err := validLine(line); err != nil {
+			return fmt.Errorf("第 %d 条下注线无效: %v", i+1, err)
+		}
+	}
+	if cfg.Accuracy < 0 {
+		return fmt.Errorf("精度不能为负数: %v", cfg.Accuracy)
+	}
+	return nil
+}
+
+// SetupTree 校验配置后依次发送set_pot、set_eff_stack、set_board、set_range、set_isomorphism、
+// add_line、set_accuracy，最后build_tree
+func (c *Client) SetupTree(cfg TreeConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := c.SetPot(cfg.Pot[0], cfg.Pot[1], cfg.Pot[2]); err != nil {
+		return err
+	}
+	if err := c.SetEffStack(cfg.EffectiveStack); err != nil {
+		return err
+	}
+	if err := c.SetBoard(cfg.Board); err != nil {
+		return err
+	}
+	if cfg.OOPRange != nil {
+		if err := c.SetRange("OOP", cfg.OOPRange); err != nil {
+			return err
+		}
+	}
+	if cfg.IPRange != nil {
+		if err := c.SetRange("IP", cfg.IPRange); err != nil {
+			return err
+		}
+	}
+	if err := c.SetIsomorphism(cfg.FlopIsomorphism, cfg.TurnIsomorphism); err != nil {
+		return err
+	}
+	for _, line := range cfg.Lines {
+		if err := c.AddLine(line); err != nil {
+			return err
+		}
+	}
+	if cfg.Accuracy > 0 {
+		if err := c.SetAccuracy(cfg.Accuracy); err != nil {
+			return err
+		}
+	}
+	return c.BuildTree()
+}
+
+// SetRange 设置玩家的范围，weights为按show_hand_order顺序排列的1326个权重（0到1）
+func (c *Client) SetRange(player string, weights []float64) error {
+	if err := validPlayer(player); err != nil {
+		return err
+	}
+	if err := validRange(weights); err != nil {
+		return err
+	}
+	values := make([]string, len(weights))
+	for i, w := range weights {
+		values[i] = strconv.FormatFloat(w, 'g', -1, 64)
+	}
+	return c.setup(fmt.Sprintf("set_range %s %s", player, strings.Join(values, " ")))
+}
+
+// SetPot 设置底池：OOP投入、IP投入和死钱
+func (c *Client) SetPot(oop, ip, dead float64) error {
+	if err := validPot(oop, ip, dead); err != nil {
+		return err
+	}
+	return c.setup(fmt.Sprintf("set_pot %s %s %s", formatAmount(oop), formatAmount(ip), formatAmount(dead)))
+}
+
+// SetEffStack 设置有效筹码
+func (c *Client) SetEffStack(stack float64) error {
+	if !(stack > 0) || math.IsInf(stack, 0) {
+		return fmt.Errorf("有效筹码必须大于0: %v", stack)
+	}
+	return c.setup(fmt.Sprintf("set_eff_stack %s", formatAmount(stack)))
+}
+
+// SetBoard 设置公牌（3到5张），接受 "Ks7d2c" 或 "Ks 7d 2c"
+func (c *Client) SetBoard(board string) error {
+	cards, err := parseBoard(board)
+	if err != nil {
+		return err
+	}
+	return c.setup(fmt.Sprintf("set_board %s", strings.Join(cards, "")))
+}
+
+// SetIsomorphism 设置翻牌/转牌是否启用同构压缩
+func (c *Client) SetIsomorphism(flop, turn bool) error {
+	return c.setup(fmt.Sprintf("set_isomorphism %d %d", boolFlag(flop), boolFlag(turn)))
+}
+
+// AddLine 添加一条下注线，line为依次累计投入的筹码数
+func (c *Client) AddLine(line []int) error {
+	if err := validLine(line); err != nil {
+		return err
+	}
+	return c.setup("add_line " + formatLine(line))
+}
+
+// RemoveLine 删除一条下注线
+func (c *Client) RemoveLine(line []int) error {
+	if err := validLine(line); err != nil {
+		return err
+	}
+	return c.setup("remove_line " + formatLine(line))
+}
+
+// BuildTree 根据之前的设置构建博弈树
+func (c *Client) BuildTree() error {
+	_, err := c.query("build_tree", buildTreeTimeout)
+	return err
+}
+
+// SetAccuracy 设置求解的目标精度（可剥削值）
+func (c *Client) SetAccuracy(accuracy float64) error {
+	if !(accuracy > 0) || math.IsInf(accuracy, 0) {
+		return fmt.Errorf("精度必须大于0: %v", accuracy)
+	}
+	return c.setup(fmt.Sprintf("set_accuracy %s", strconv.FormatFloat(accuracy, 'g', -1, 64)))
+}
+
+// setup 执行一条设置命令；设置命令会改变求解器状态，进程退出时不自动重试
+func (c *Client) setup(command string) error {
+	_, err := c.query(command, setupTimeout)
+	return err
+}
+
+// parseBoard 解析并校验公牌，返回每张牌
+func parseBoard(board string) ([]string, error) {
+	compact := strings.ReplaceAll(strings.TrimSpace(board), " ", "")
+	if len(compact)%2 != 0 {
+		return nil, fmt.Errorf("公牌格式错误: %q", board)
+	}
+	cards := make([]string, 0, len(compact)/2)
+	seen := make(map[string]bool)
+	for i := 0; i < len(compact); i += 2 {
+		card := strings.ToUpper(compact[i:i+1]) + strings.ToLower(compact[i+1:i+2])
+		if !strings.Contains("23456789TJQKA", card[:1]) || !strings.Contains("cdhs", card[1:]) {
+			return nil, fmt.Errorf("公牌 %q 中的牌无效: %s", board, compact[i:i+2])
+		}
+		if seen[card] {
+			return nil, fmt.Errorf("公牌 %q 中有重复的牌: %s", board, card)
+		}
+		seen[card] = true
+		cards = append(cards, card)
+	}
+	if len(cards) < 3 || len(cards) > 5 {
+		return nil, fmt.Errorf("公牌必须是3到5张: %q", board)
+	}
+	return cards, nil
+}
+
+// validPot 检查底池各项非负
+func validPot(oop, ip, dead float64) error {
+	for _, v := range []float64{oop, ip, dead} {
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("底池数值无效: %v %v %v", oop, ip, dead)
+		}
+	}
+	if oop+ip+dead <= 0 {
+		return fmt.Errorf("底池不能为0")
+	}
+	return nil
+}
+
+// validRange 检查范围是1326个0到1之间的权重
+func validRange(weights []float64) error {
+	if len(weights) != HandCount {
+		return fmt.Errorf("范围必须有 %d 个权重，实际 %d", HandCount, len(weights))
+	}
+	for i, w := range weights {
+		if !(w >= 0 && w <= 1) {
+			return fmt.Errorf("第 %d 个权重超出[0,1]: %v", i, w)
+		}
+	}
+	return nil
+}
+
+// validLine 检查下注线非空、非负且累计投入不递减
+func validLine(line []int) error {
+	if len(line) == 0 {
+		return fmt.Errorf("下注线不能为空")
+	}
+	for i, v := range line {
+		if v < 0 {
+			return fmt.Errorf("下注线中有负数: %v", line)
+		}
+		// 同一玩家的累计投入不会减少（双方交替行动，比较隔一个位置的值）
+		if i >= 2 && v < line[i-2] {
+			return fmt.Errorf("下注线中的累计投入递减: %v", line)
+		}
+	}
+	return nil
+}
+
+func formatLine(line []int) string {
+	parts := make([]string, len(line))
+	for i, v := range line {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func boolFlag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// RunScriptLine 执行建树脚本中的一行命令（如calc命令的脚本），ctx被取消时中断
+// set_pot、set_eff_stack、set_board、set_range、set_isomorphism、add_line、remove_line、set_accuracy
+// 先按对应的类型化方法校验参数，无效时不发送；PioSolver返回ERROR行时返回*CommandError
+func (c *Client) RunScriptLine(ctx context.Context, line string) error {
+	if err := validScriptLine(line); err != nil {
+		return fmt.Errorf("脚本命令 '%s' 无效: %v", line, err)
+	}
+	timeout := setupTimeout
+	if line == "build_tree" || line == "estimate_tree" {
+		timeout = buildTreeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	_, err := c.queryContext(ctx, line)
+	return err
+}
+
+// validScriptLine 按类型化方法的规则检查脚本命令的参数，其余命令不检查
+func validScriptLine(line string) error {
+	fields := strings.Fields(line)
+	args := fields[1:]
+	switch fields[0] {
+	case "set_pot":
+		values, err := parseAmounts(args, 3)
+		if err != nil {
+			return err
+		}
+		return validPot(values[0], values[1], values[2])
+	case "set_eff_stack", "set_accuracy":
+		values, err := parseAmounts(args, 1)
+		if err != nil {
+			return err
+		}
+		if !(values[0] > 0) || math.IsInf(values[0], 0) {
+			return fmt.Errorf("数值必须大于0: %v", values[0])
+		}
+	case "set_board":
+		_, err := parseBoard(strings.Join(args, ""))
+		return err
+	case "set_range":
+		if len(args) < 2 {
+			return fmt.Errorf("需要玩家和范围")
+		}
+		if err := validPlayer(args[0]); err != nil {
+			return err
+		}
+		// 范围也可以是PioSolver的手牌类别写法（如 AA,AKs:0.5），只有逐手牌权重时才检查
+		if weights, err := parseAmounts(args[1:], len(args)-1); err == nil {
+			return validRange(weights)
+		}
+	case "set_isomorphism":
+		if len(args) != 2 || (args[0] != "0" && args[0] != "1") || (args[1] != "0" && args[1] != "1") {
+			return fmt.Errorf("需要两个0或1的参数")
+		}
+	case "add_line", "remove_line":
+		line := make([]int, len(args))
+		for i, arg := range args {
+			v, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("下注线中的数值无效: %s", arg)
+			}
+			line[i] = v
+		}
+		return validLine(line)
+	}
+	return nil
+}
+
+// parseAmounts 解析n个数值参数
+func parseAmounts(args []string, n int) ([]float64, error) {
+	if len(args) != n {
+		return nil, fmt.Errorf("需要 %d 个数值，实际 %d 个", n, len(args))
+	}
+	values := make([]float64, n)
+	for i, arg := range args {
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil || math.IsNaN(v) {
+			return nil, fmt.Errorf("数值无效: %s", arg)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
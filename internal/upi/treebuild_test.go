@@ -0,0 +1,101 @@
+package upi
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// uniformRange 返回1326个相同权重的范围
+func uniformRange(w float64) []float64 {
+	weights := make([]float64, HandCount)
+	for i := range weights {
+		weights[i] = w
+	}
+	return weights
+}
+
+func validTreeConfig() TreeConfig {
+	return TreeConfig{
+		Board:          "Ks7d2c",
+		Pot:            [3]float64{0, 0, 60},
+		EffectiveStack: 370,
+		OOPRange:       uniformRange(1),
+		IPRange:        uniformRange(0.5),
+		Lines:          [][]int{{0, 0}, {20, 20}, {20, 60, 60}},
+		Accuracy:       0.5,
+	}
+}
+
+func TestTreeConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*TreeConfig)
+	}{
+		{"公牌太少", func(c *TreeConfig) { c.Board = "Ks7d" }},
+		{"公牌太多", func(c *TreeConfig) { c.Board = "Ks7d2c3h4h5h" }},
+		{"重复的牌", func(c *TreeConfig) { c.Board = "KsKs2c" }},
+		{"无效的牌", func(c *TreeConfig) { c.Board = "Kx7d2c" }},
+		{"底池为负", func(c *TreeConfig) { c.Pot = [3]float64{-1, 0, 60} }},
+		{"底池为0", func(c *TreeConfig) { c.Pot = [3]float64{} }},
+		{"有效筹码为0", func(c *TreeConfig) { c.EffectiveStack = 0 }},
+		{"范围宽度错误", func(c *TreeConfig) { c.OOPRange = uniformRange(1)[:HandCount-1] }},
+		{"范围权重超出", func(c *TreeConfig) { c.IPRange[3] = 1.5 }},
+		{"下注线为空", func(c *TreeConfig) { c.Lines = [][]int{{}} }},
+		{"下注线递减", func(c *TreeConfig) { c.Lines = [][]int{{60, 60, 20}} }},
+		{"精度为负", func(c *TreeConfig) { c.Accuracy = -1 }},
+	}
+
+	if err := validTreeConfig().Validate(); err != nil {
+		t.Fatalf("有效配置校验失败: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTreeConfig()
+			tt.modify(&cfg)
+			if err := cfg.Validate(); err == nil {
+				t.Error("应返回错误")
+			}
+		})
+	}
+}
+
+func TestSetupTreeAgainstFakepio(t *testing.T) {
+	client := startFakepio(t)
+
+	if err := client.SetupTree(validTreeConfig()); err != nil {
+		t.Fatalf("SetupTree: %v", err)
+	}
+	if info, err := client.ShowNode("r:0"); err != nil || info.Board != "Ks 7d 2c" {
+		t.Errorf("建树后ShowNode = %+v, %v", info, err)
+	}
+	if err := client.RemoveLine([]int{20, 60, 60}); err != nil {
+		t.Errorf("RemoveLine: %v", err)
+	}
+
+	// PioSolver返回的ERROR转换为*CommandError
+	var cmdErr *CommandError
+	if err := client.RemoveLine([]int{20, 60, 60}); !errors.As(err, &cmdErr) {
+		t.Errorf("删除不存在的下注线应返回*CommandError，实际 %v", err)
+	}
+	// 校验失败的参数不发送
+	if err := client.SetBoard("Ks7d"); err == nil || errors.As(err, &cmdErr) {
+		t.Errorf("无效公牌应在本地校验失败，实际 %v", err)
+	}
+}
+
+func TestRunScriptLineReportsErrors(t *testing.T) {
+	client := startFakepio(t)
+	ctx := context.Background()
+
+	if err := client.RunScriptLine(ctx, "set_pot 0 0 60"); err != nil {
+		t.Errorf("set_pot: %v", err)
+	}
+	if err := client.RunScriptLine(ctx, "set_pot 0 0"); err == nil {
+		t.Error("参数个数错误的set_pot应返回错误")
+	}
+	var cmdErr *CommandError
+	if err := client.RunScriptLine(ctx, "no_such_command"); !errors.As(err, &cmdErr) {
+		t.Errorf("PioSolver返回ERROR时应返回*CommandError，实际 %v", err)
+	}
+}
@@ -1,45 +1,132 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"maps"
 	"math"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"piodatasolver/internal/cache"
+	"piodatasolver/internal/manifest"
 	"piodatasolver/internal/upi"
-	"piodatasolver/internal/util"
 	"piodatasolver/model"
+	"piodatasolver/parser"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
-var handOrder *cache.HandOrder
-var boardOrder *cache.BoardOrder
-
-// CFR文件路径 - 用于生成输出文件名（在处理过程中动态设置）
-var cfrFilePath string
-
 // PioSolver相关路径配置 - 方便修改
-const (
-	pioSolverExePath = "./PioSOLVER3-edge.exe"                  // PioSolver可执行文件路径
-	pioSolverWorkDir = `E:\zdsbddz\piosolver\piosolver3\`       // PioSolver工作目录
-	exportSavePath   = `E:\zdsbddz\piosolver\piosolver3\saves\` // 导出文件保存路径
+// 如果设置了环境变量 PIO_SOLVER_EXE / PIO_SOLVER_DIR / PIO_EXPORT_DIR，优先使用环境变量
+// （例如在Linux上指向fakepio替身进程进行离线测试）
+var (
+	pioSolverExePath = envOrDefault("PIO_SOLVER_EXE", "./PioSOLVER3-edge.exe")                  // PioSolver可执行文件路径
+	pioSolverWorkDir = envOrDefault("PIO_SOLVER_DIR", `E:\zdsbddz\piosolver\piosolver3\`)       // PioSolver工作目录
+	exportSavePath   = envOrDefault("PIO_EXPORT_DIR", `E:\zdsbddz\piosolver\piosolver3\saves\`) // 导出文件保存路径
 )
 
-// 全局变量，用于统计过滤的动作数量
+// PIO_SOLVER_ADDR 设置后通过TCP连接远程机器上的upibridge，不在本机启动PioSolver
+var pioSolverAddr = os.Getenv("PIO_SOLVER_ADDR")
+
+// 会话录制与回放：PIO_RECORD 把与PioSolver的全部交互录制到JSONL文件，
+// PIO_REPLAY 用录制文件代替PioSolver回放（不需要PioSolver，可在Linux上复现解析问题）
 var (
-	filteredActionCount int = 0
+	pioRecordPath = os.Getenv("PIO_RECORD")
+	pioReplayPath = os.Getenv("PIO_REPLAY")
 )
 
+// calcTaskTimeout 是calc命令中单个任务（脚本执行+求解+导出）的截止时间
+const calcTaskTimeout = 45 * time.Minute
+
+// solveAccuracy 是calc命令求解的目标可剥削值
+const solveAccuracy = 0.12
+
+// calcDumpMode 是calc命令导出CFR文件的dump_tree模式
+const calcDumpMode = "no_rivers"
+
+// rssSampleInterval 是calc求解期间采样PioSolver进程内存的间隔
+const rssSampleInterval = 5 * time.Second
+
+// envOrDefault 读取环境变量，未设置时返回默认值
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// newSolverClient 创建未启动的PioSolver客户端：设置了PIO_SOLVER_ADDR时连接远程桥接服务，否则启动本机进程
+func newSolverClient() *upi.Client {
+	if pioSolverAddr != "" {
+		return upi.NewTCPClient(pioSolverAddr)
+	}
+	return upi.NewClient(pioSolverExePath, pioSolverWorkDir)
+}
+
+// newSolverPool 创建PioSolver连接池，按PIO_REPLAY/PIO_RECORD环境变量使用回放或录制客户端
+// 连接池有多个实例或实例被替换时，录制文件名后依次加上 -1、-2 ... 以区分各实例的会话
+func newSolverPool(size int) (*upi.Pool, error) {
+	pool := upi.NewPool(pioSolverExePath, pioSolverWorkDir, size)
+	if pioSolverAddr != "" {
+		log.Printf("🌐 通过桥接服务连接远程PioSolver: %s", pioSolverAddr)
+		pool.SetFactory(func() (*upi.Client, error) {
+			return newSolverClient(), nil
+		})
+	}
+
+	if pioReplayPath != "" {
+		cassette, err := upi.LoadCassette(pioReplayPath)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("📼 回放录制文件: %s (%d 条命令)", pioReplayPath, cassette.Len())
+		pool.SetFactory(func() (*upi.Client, error) {
+			return upi.NewReplayClient(cassette), nil
+		})
+		return pool, nil
+	}
+
+	if pioRecordPath != "" {
+		log.Printf("📼 录制PioSolver会话到: %s", pioRecordPath)
+		var mu sync.Mutex
+		sessions := 0
+		pool.SetFactory(func() (*upi.Client, error) {
+			mu.Lock()
+			sessions++
+			path := pioRecordPath
+			if size > 1 || sessions > 1 {
+				ext := filepath.Ext(path)
+				path = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), sessions, ext)
+			}
+			mu.Unlock()
+
+			client := newSolverClient()
+			if err := client.RecordTo(path); err != nil {
+				return nil, err
+			}
+			return client, nil
+		})
+	}
+	return pool, nil
+}
+
 // 新增：从set_board命令提取公牌信息
 func extractBoardFromTemplate(templateContent string) string {
 	// 正则表达式：匹配set_board命令
@@ -55,43 +142,94 @@ func extractBoardFromTemplate(templateContent string) string {
 func main() {
 	// 检查命令行参数
 	if len(os.Args) < 2 {
-		fmt.Println("用法: piodatasolver.exe [parse|calc|merge|mergecsv|jsonl] [参数]")
-		fmt.Println("  parse <CFR文件夹路径> - 解析指定文件夹下的所有CFR文件并生成JSON/SQL文件")
+		fmt.Println("用法: piodatasolver.exe [parse|calc|lock|merge|mergecsv|jsonl] [参数]")
+		fmt.Println("  parse <CFR文件夹路径> [-workers N] [-format json|ndjson] [-street flop|turn|river] [-cards 牌] [-card-sample N] - 解析指定文件夹下的所有CFR文件并生成JSON/SQL文件，N个PioSolver实例并行解析")
+		fmt.Println("    -street: 解析到哪条街，turn/river会进入发牌节点；-cards/-card-sample: 只展开指定的牌（如 A,K,7h）或每个发牌节点抽样N张")
 		fmt.Println("    例如: piodatasolver.exe parse \"E:\\zdsbddz\\piosolver\\piosolver3\\saves\"")
-		fmt.Println("  calc <脚本路径> - 执行PioSolver批量计算功能")
-		fmt.Println("    例如: piodatasolver.exe calc \"D:\\gto\\piosolver3\\TreeBuilding\\mtt\\40bb\"")
+		fmt.Println("  calc <脚本路径> [-workers N] [-memory-budget MB] - 执行PioSolver批量计算功能，N个PioSolver实例并行计算")
+		fmt.Println("    -memory-budget: 建树前估算内存，超出预算的任务不计算，记入导出目录的oversized_tasks.jsonl")
+		fmt.Println("    例如: piodatasolver.exe calc \"D:\\gto\\piosolver3\\TreeBuilding\\mtt\\40bb\" -workers 4")
+		fmt.Println("  lock <CFR文件> <锁定策略JSON> [-out 输出文件] - 锁定节点策略后重新求解，输出锁定前后的EV对比")
+		fmt.Println("    例如: piodatasolver.exe lock saves\\40bb_COvsBB_Ks7d2c.cfr lock\\overfold.json")
 		fmt.Println("  merge - 汇总data目录下的所有SQL文件为data.sql")
 		fmt.Println("    例如: piodatasolver.exe merge")
 		fmt.Println("  mergecsv - 将data目录下的所有SQL文件转换为CSV格式")
 		fmt.Println("    例如: piodatasolver.exe mergecsv")
-		fmt.Println("  jsonl - 将data目录下的所有SQL文件转换为JSONL格式")
+		fmt.Println("  jsonl [-level combo|node] - 将数据库中的表转换为JSONL训练数据，node按决策节点汇总生成")
 		fmt.Println("    例如: piodatasolver.exe jsonl")
 		os.Exit(1)
 	}
 
 	command := os.Args[1]
 
+	// Ctrl-C或SIGTERM时取消上下文，进行中的PioSolver命令会被中断，进程会被终止
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	switch command {
 	case "parse":
 		if len(os.Args) < 3 {
 			fmt.Println("错误: parse命令需要指定CFR文件夹路径")
-			fmt.Println("用法: piodatasolver.exe parse <CFR文件夹路径>")
+			fmt.Println("用法: piodatasolver.exe parse <CFR文件夹路径> [-workers N] [-format json|ndjson] [-street flop|turn|river] [-cards 牌] [-card-sample N] [-root 节点] [-max-depth N] [-max-bet-level N] [-node-pattern 正则] [-actor IP|OOP]")
 			fmt.Println("例如: piodatasolver.exe parse \"E:\\zdsbddz\\piosolver\\piosolver3\\saves\"")
 			os.Exit(1)
 		}
 		cfrFolderPath := os.Args[2]
-		log.Printf("执行解析功能，CFR文件夹路径: %s", cfrFolderPath)
-		runParseCommand(cfrFolderPath)
+		parseFlags := flag.NewFlagSet("parse", flag.ExitOnError)
+		workers := parseFlags.Int("workers", 1, "并行解析的PioSolver实例数")
+		format := parseFlags.String("format", parser.FormatJSON, "记录输出格式: json（JSON数组）或 ndjson（每行一条记录）")
+		street := parseFlags.String("street", parser.StreetFlop, "解析到哪条街: flop（不进入发牌节点）、turn 或 river")
+		cards := parseFlags.String("cards", "", "发牌节点只展开这些牌，逗号分隔，可以是具体的牌（Ah）或点数（A）")
+		cardSample := parseFlags.Int("card-sample", 0, "每个发牌节点只展开抽样的N张牌，0表示全部展开")
+		rootNode := parseFlags.String("root", parser.DefaultRootNode, "开始遍历的节点，如 r:0:c")
+		maxDepth := parseFlags.Int("max-depth", 0, "只遍历从开始节点起的前N层决策节点，0表示不限制")
+		maxBetLevel := parseFlags.Int("max-bet-level", 0, "只遍历主动下注次数不超过N的节点，0表示不限制")
+		nodePattern := parseFlags.String("node-pattern", "", "只输出节点ID匹配该正则表达式的决策节点，如 ^r:0:c")
+		actor := parseFlags.String("actor", "", "只输出该玩家的决策节点: IP 或 OOP")
+		parseFlags.Parse(os.Args[3:])
+		if *format != parser.FormatJSON && *format != parser.FormatNDJSON {
+			fmt.Printf("错误: 不支持的输出格式 %s（应为json或ndjson）\n", *format)
+			os.Exit(1)
+		}
+		config := parser.Config{
+			RootNode:    *rootNode,
+			Street:      *street,
+			CardSample:  *cardSample,
+			MaxDepth:    *maxDepth,
+			MaxBetLevel: *maxBetLevel,
+			NodePattern: *nodePattern,
+			Actor:       *actor,
+		}
+		if *cards != "" {
+			config.Cards = strings.Split(*cards, ",")
+		}
+		log.Printf("执行解析功能，CFR文件夹路径: %s，并发数: %d，输出格式: %s，解析到: %s", cfrFolderPath, *workers, *format, *street)
+		runParseCommand(ctx, cfrFolderPath, *workers, *format, config)
 	case "calc":
 		if len(os.Args) < 3 {
 			fmt.Println("错误: calc命令需要指定脚本路径")
-			fmt.Println("用法: piodatasolver.exe calc <脚本路径>")
+			fmt.Println("用法: piodatasolver.exe calc <脚本路径> [-workers N] [-memory-budget MB]")
 			fmt.Println("例如: piodatasolver.exe calc \"D:\\gto\\piosolver3\\TreeBuilding\\mtt\\40bb\"")
 			os.Exit(1)
 		}
 		scriptPath := os.Args[2]
-		log.Printf("执行计算功能，脚本路径: %s", scriptPath)
-		runCalcCommand(scriptPath)
+		calcFlags := flag.NewFlagSet("calc", flag.ExitOnError)
+		workers := calcFlags.Int("workers", 1, "并行运行的PioSolver实例数")
+		memoryBudget := calcFlags.Float64("memory-budget", 0, "每个任务的内存预算（MB），估算超出的任务不计算；0表示不限制")
+		calcFlags.Parse(os.Args[3:])
+		log.Printf("执行计算功能，脚本路径: %s，并发数: %d", scriptPath, *workers)
+		runCalcCommand(ctx, scriptPath, *workers, *memoryBudget)
+	case "lock":
+		if len(os.Args) < 4 {
+			fmt.Println("错误: lock命令需要指定CFR文件和锁定策略JSON文件")
+			fmt.Println("用法: piodatasolver.exe lock <CFR文件> <锁定策略JSON> [-out 输出文件]")
+			os.Exit(1)
+		}
+		lockFlags := flag.NewFlagSet("lock", flag.ExitOnError)
+		outPath := lockFlags.String("out", "", "EV对比结果输出文件，默认 lock/<CFR文件名>_<策略文件名>.json")
+		lockFlags.Parse(os.Args[4:])
+		log.Printf("执行节点锁定功能，CFR文件: %s，锁定策略: %s", os.Args[2], os.Args[3])
+		runLockCommand(ctx, os.Args[2], os.Args[3], *outPath)
 	case "merge":
 		log.Printf("执行SQL文件汇总功能")
 		runMergeCommand()
@@ -99,36 +237,22 @@ func main() {
 		log.Printf("执行SQL转CSV功能")
 		runMergeCSVCommand()
 	case "jsonl":
-		runJSONLCommand()
+		jsonlFlags := flag.NewFlagSet("jsonl", flag.ExitOnError)
+		level := jsonlFlags.String("level", jsonlLevelCombo, "训练样本粒度: combo（每个动作一条）或 node（每个决策节点一条，使用节点汇总）")
+		jsonlFlags.Parse(os.Args[2:])
+		if *level != jsonlLevelCombo && *level != jsonlLevelNode {
+			fmt.Printf("错误: 不支持的粒度 %s（应为combo或node）\n", *level)
+			os.Exit(1)
+		}
+		runJSONLCommand(*level)
 	default:
 		log.Printf("未知命令: %s", command)
-		log.Println("支持的命令: parse, calc, merge, mergecsv, jsonl")
-	}
-}
-
-// getEffectiveStack 获取当前树的有效起始筹码
-func getEffectiveStack(client *upi.Client) (float64, error) {
-	responses, err := client.ExecuteCommand("show_effective_stack", 10*time.Second)
-	if err != nil {
-		return 0, fmt.Errorf("执行show_effective_stack命令失败: %v", err)
-	}
-
-	if len(responses) == 0 {
-		return 0, fmt.Errorf("show_effective_stack返回空响应")
-	}
-
-	// 解析第一行响应，应该是一个数值
-	stackStr := strings.TrimSpace(responses[0])
-	stack, err := strconv.ParseFloat(stackStr, 64)
-	if err != nil {
-		return 0, fmt.Errorf("解析有效筹码失败: %s, %v", stackStr, err)
+		log.Println("支持的命令: parse, calc, lock, merge, mergecsv, jsonl")
 	}
-
-	return stack, nil
 }
 
-// runParseCommand 执行解析功能，处理指定文件夹下的所有CFR文件
-func runParseCommand(cfrFolderPath string) {
+// runParseCommand 执行解析功能，处理指定文件夹下的所有CFR文件，workers个PioSolver实例并行解析
+func runParseCommand(ctx context.Context, cfrFolderPath string, workers int, format string, config parser.Config) {
 	log.Println("==================================")
 	log.Println("【批量解析功能】正在初始化...")
 	log.Printf("CFR文件夹路径: %s", cfrFolderPath)
@@ -150,167 +274,196 @@ func runParseCommand(cfrFolderPath string) {
 		log.Printf("  %d. %s", i+1, filepath.Base(file))
 	}
 
-	// 创建输出目录
-	err = os.MkdirAll("data", 0755)
+	// 创建输出目录并读取清单
+	outputs, err := manifest.Open("data")
 	if err != nil {
-		log.Fatalf("创建输出目录失败: %v", err)
+		log.Fatalf("读取data目录清单失败: %v", err)
 	}
 
-	// 启动PioSolver
-	client := upi.NewClient(pioSolverExePath, pioSolverWorkDir)
-	if err := client.Start(); err != nil {
+	// 启动PioSolver连接池，每个worker使用其中一个实例
+	// 每个文件开始前从连接池取出实例，进程已退出时会自动替换
+	log.Printf("→ 启动 %d 个PioSolver实例...", workers)
+	pool, err := newSolverPool(workers)
+	if err != nil {
+		log.Fatalf("创建PioSolver连接池失败: %v", err)
+	}
+	if err := pool.Start(ctx); err != nil {
 		log.Fatalf("启动PioSolver失败: %v", err)
 	}
-	defer client.Close()
+	defer pool.Close()
 
 	// 检查PioSolver是否准备好
-	ready, err := client.IsReady()
-	if err != nil || !ready {
+	client, err := pool.Get(ctx)
+	if err != nil {
 		log.Fatalf("PioSolver未准备好: %v", err)
 	}
 
-	//创建HandOrder实例
-	handOrder = &cache.HandOrder{}
-	boardOrder = &cache.BoardOrder{}
-
-	// 初始化HandOrder
-	if err := handOrder.Init(client); err != nil {
-		log.Fatalf("初始化HandOrder失败: %v", err)
-	}
-
-	// 初始化BoardOrder
-	if err := boardOrder.Init(); err != nil {
-		log.Fatalf("初始化BoardOrder失败: %v", err)
+	// 创建解析器：查询手牌顺序并初始化公牌索引，之后只读，各worker换用自己的PioSolver实例共用
+	config.Sinks = parser.FileSinks("data", format)
+	base, err := parser.New(client, config)
+	if err != nil {
+		log.Fatalf("创建解析器失败: %v", err)
 	}
 
-	// 设置目标节点
-	targetNode := "r:0"
+	// 输出参数不同（过滤配置、格式）时已有的结果不能沿用
+	params := base.Params()
+	params["format"] = format
 
-	// 检查已存在的解析结果文件
+	// 按清单检查已完成的解析结果
 	log.Println("\n==================================")
 	log.Println("【检查已存在的解析结果】")
-	existingResults, err := checkExistingParseResults()
-	if err != nil {
-		log.Fatalf("检查已存在解析结果失败: %v", err)
-	}
+	log.Printf("清单: %s，已记录 %d 个SQL文件", filepath.Join("data", manifest.FileName), len(outputs.Entries(manifest.KindSQL)))
 
 	// 统计需要处理的任务
 	totalFiles := len(cfrFiles)
 	skippedFiles := 0
-	currentFile := 0
 
 	// 预先统计会跳过多少文件
 	for _, cfrFile := range cfrFiles {
-		_, cfrFileName := filepath.Split(cfrFile)
-		cfrFileName = strings.TrimSuffix(cfrFileName, filepath.Ext(cfrFileName))
-		jsonFileName := cfrFileName + ".json"
-		sqlFileName := cfrFileName + ".sql"
-
-		if existingResults[jsonFileName] && existingResults[sqlFileName] {
+		if parseCompleted(outputs, cfrFile, format, params) {
 			skippedFiles++
 		}
 	}
 
 	actualFiles := totalFiles - skippedFiles
-	log.Printf("总CFR文件数: %d，已解析: %d，需要处理: %d", totalFiles, skippedFiles, actualFiles)
+	log.Printf("总CFR文件数: %d，已解析: %d，需要处理: %d，并发数: %d", totalFiles, skippedFiles, actualFiles, workers)
 	log.Println("==================================")
 
+	pool.Put(client)
+
 	if actualFiles == 0 {
 		log.Println("🎉 所有CFR文件都已解析完成，无需重新处理！")
 		return
 	}
 
-	// 循环处理每个CFR文件
+	progress := &parseProgress{total: totalFiles, pending: actualFiles, workers: workers}
+	tasks := make(chan parseTask)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				parseCfrFile(ctx, pool, base, task, outputs, format, params, progress)
+			}
+		}()
+	}
+
+	// 分发每个CFR文件
+dispatch:
 	for i, cfrFile := range cfrFiles {
-		currentFile = i + 1
+		currentFile := i + 1
 
 		// 检查文件是否已经解析过
-		_, cfrFileName := filepath.Split(cfrFile)
-		cfrFileName = strings.TrimSuffix(cfrFileName, filepath.Ext(cfrFileName))
-		jsonFileName := cfrFileName + ".json"
-		sqlFileName := cfrFileName + ".sql"
-
-		if existingResults[jsonFileName] && existingResults[sqlFileName] {
-			log.Printf("\n[%d/%d] ⏭️  跳过已解析: %s (JSON和SQL文件已存在)", currentFile, totalFiles, filepath.Base(cfrFile))
+		if parseCompleted(outputs, cfrFile, format, params) {
+			log.Printf("\n[%d/%d] ⏭️  跳过已解析: %s (清单中%s、节点汇总和SQL文件已按相同参数完成)", currentFile, totalFiles, filepath.Base(cfrFile), strings.ToUpper(format))
 			continue
 		}
 
-		log.Printf("\n[%d/%d] 🚀 开始处理CFR文件: %s", currentFile, totalFiles, filepath.Base(cfrFile))
+		// 收到中断信号时停止分发后续文件
+		select {
+		case tasks <- parseTask{seq: currentFile, cfrFile: cfrFile}:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(tasks)
+	wg.Wait()
 
-		// 重置过滤计数器
-		filteredActionCount = 0
+	if ctx.Err() != nil {
+		log.Printf("\n⛔ 解析被中断: %v，已停止处理剩余文件", ctx.Err())
+	}
 
-		// 设置全局CFR文件路径
-		cfrFilePath = cfrFile
+	log.Println("\n==================================")
+	log.Println("【批量解析功能】全部完成！")
+	log.Printf("📊 总共处理了 %d 个CFR文件", totalFiles)
+	log.Println("==================================")
+}
 
-		// 加载树
-		_, err = client.LoadTree(cfrFilePath)
-		if err != nil {
-			log.Printf("  ❌ 加载树失败: %v，跳过此文件", err)
-			continue
-		}
+// parseTask 是parse命令中的一个解析任务
+type parseTask struct {
+	seq     int    // 文件序号，从1开始
+	cfrFile string // CFR文件路径
+}
 
-		log.Printf("  ✓ CFR文件加载成功")
+// parseProgress 汇总所有worker的解析进度
+type parseProgress struct {
+	mu        sync.Mutex
+	total     int // CFR文件总数
+	pending   int // 需要处理的文件数
+	workers   int
+	completed int
+	failed    int
+}
 
-		// 获取有效筹码
-		log.Printf("  → 获取有效筹码...")
-		effectiveStack, err := getEffectiveStack(client)
-		if err != nil {
-			log.Printf("  ❌ 获取有效筹码失败: %v，使用默认值60bb", err)
-			effectiveStack = 60.0
-		} else {
-			log.Printf("  ✓ 有效筹码: %.2f bb", effectiveStack)
-		}
+// finish 记录一个文件处理结束，并在多个worker并行时输出整体进度
+func (p *parseProgress) finish(ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ok {
+		p.completed++
+	} else {
+		p.failed++
+	}
+	if p.workers > 1 {
+		log.Printf("  📈 整体进度: 已完成 %d，失败 %d，剩余 %d (共需处理 %d)",
+			p.completed, p.failed, p.pending-p.completed-p.failed, p.pending)
+	}
+}
 
-		// 解析节点并生成JSON
-		log.Printf("  → 开始解析节点并生成JSON...")
-		parseNode(client, targetNode, effectiveStack)
-		log.Printf("  ✓ 节点解析完成")
+// parseCfrFile 用连接池中的一个PioSolver实例解析单个CFR文件，输出写入data目录，完成后记入清单
+func parseCfrFile(ctx context.Context, pool *upi.Pool, base *parser.Parser, task parseTask, outputs *manifest.Manifest, format string, params map[string]string, progress *parseProgress) {
+	cfrFile := task.cfrFile
+	currentFile, totalFiles := task.seq, progress.total
 
-		// 读取生成的JSON文件并统计有效record总数
-		_, cfrFileNameForOutput := filepath.Split(cfrFilePath)
-		cfrFileNameForOutput = strings.TrimSuffix(cfrFileNameForOutput, filepath.Ext(cfrFileNameForOutput))
-		outputPath := filepath.Join("data", cfrFileNameForOutput+".json")
+	// 收到中断信号时不再开始新文件
+	if ctx.Err() != nil {
+		return
+	}
 
-		// 读取JSON文件
-		fileData, err := os.ReadFile(outputPath)
-		if err != nil {
-			log.Printf("  ❌ 读取JSON文件失败: %v", err)
-		} else {
-			// 解析JSON数据
-			var records []*model.Record
-			err = json.Unmarshal(fileData, &records)
-			if err != nil {
-				log.Printf("  ❌ 解析JSON数据失败: %v", err)
-			} else {
-				// 统计总记录数和有效动作数
-				totalActions := 0
-				for _, record := range records {
-					totalActions += len(record.Actions)
-				}
+	log.Printf("\n[%d/%d] 🚀 开始处理CFR文件: %s", currentFile, totalFiles, filepath.Base(cfrFile))
 
-				// 计算过滤比例
-				totalOriginalActions := totalActions + filteredActionCount
-				filterRatio := float64(filteredActionCount) / float64(totalOriginalActions) * 100
+	// 从连接池取出PioSolver实例
+	client, err := pool.Get(ctx)
+	if err != nil {
+		log.Printf("  ❌ 获取PioSolver实例失败: %v，跳过此文件", err)
+		progress.finish(false)
+		return
+	}
 
-				log.Printf("  ✓ [%d/%d] 文件处理完成: %s", currentFile, totalFiles, filepath.Base(cfrFile))
-				log.Printf("    📊 生成有效record %d 条，包含有效动作 %d 个", len(records), totalActions)
-				log.Printf("    🗑️  过滤掉无效动作 %d 个 (占总数的 %.2f%%)", filteredActionCount, filterRatio)
-			}
-		}
+	// 加载树并解析节点，输出在解析期间流式写入
+	stats, err := base.WithClient(client).ParseFile(ctx, cfrFile)
+	pool.Put(client)
+	if stats.Restarts > 0 {
+		log.Printf("  ⚠️  解析过程中PioSolver重启了 %d 次", stats.Restarts)
+	}
+	if ctx.Err() != nil {
+		log.Printf("  ⛔ 解析被中断，文件 %s 已完成的节点记录在断点文件中，下次运行时从中断处继续", filepath.Base(cfrFile))
+		return
+	}
+	if err != nil {
+		log.Printf("  ❌ 解析失败: %v，已完成的节点记录在断点文件中，下次运行时从中断处继续", err)
+		progress.finish(false)
+		return
 	}
+	log.Printf("  ✓ 节点解析完成: %s", filepath.Base(cfrFile))
 
-	log.Println("\n==================================")
-	log.Println("【批量解析功能】全部完成！")
-	log.Printf("📊 总共处理了 %d 个CFR文件", totalFiles)
-	log.Println("==================================")
+	if err := recordParseOutputs(outputs, cfrFile, format, params, stats); err != nil {
+		log.Printf("  ❌ 记入清单失败: %v，下次运行时重新解析此文件", err)
+		progress.finish(false)
+		return
+	}
 
-	// 给程序时间响应
-	time.Sleep(5 * time.Second)
+	// 统计有效record总数和过滤比例
+	log.Printf("  ✓ [%d/%d] 文件处理完成: %s (用时 %v)", currentFile, totalFiles, filepath.Base(cfrFile), stats.Duration.Round(time.Millisecond))
+	log.Printf("    📊 生成有效record %d 条，包含有效动作 %d 个", stats.Records, stats.Actions)
+	log.Printf("    📋 生成节点汇总 %d 条", stats.Summaries)
+	log.Printf("    🗑️  过滤掉无效动作 %d 个 (占总数的 %.2f%%)", stats.FilteredActions, stats.FilterRatio())
+	progress.finish(true)
 }
 
-// runCalcCommand 执行批量计算功能
-func runCalcCommand(scriptPath string) {
+// runCalcCommand 执行批量计算功能，workers个PioSolver实例并行计算
+func runCalcCommand(ctx context.Context, scriptPath string, workers int, memoryBudgetMB float64) {
 	log.Println("==================================")
 	log.Println("【批量计算功能】正在初始化...")
 	log.Printf("脚本路径: %s", scriptPath)
@@ -342,13 +495,14 @@ func runCalcCommand(scriptPath string) {
 	flopSubsets := allFlopSubsets
 	log.Printf("已加载 %d 个公牌组合 (生产模式，处理全部公牌)", len(flopSubsets))
 
-	// 检查已存在的文件
+	// 按导出目录的清单检查已完成的任务
 	log.Println("\n==================================")
 	log.Println("【检查已存在文件】")
-	existingFiles, err := checkExistingFiles()
+	cfrs, err := manifest.Open(exportSavePath)
 	if err != nil {
-		log.Fatalf("检查已存在文件失败: %v", err)
+		log.Fatalf("读取导出目录清单失败: %v", err)
 	}
+	log.Printf("清单: %s，已记录 %d 个CFR文件", filepath.Join(exportSavePath, manifest.FileName), len(cfrs.Entries(manifest.KindCFR)))
 
 	// 统计需要处理的任务
 	totalTasks := len(scriptFiles) * len(flopSubsets)
@@ -358,9 +512,13 @@ func runCalcCommand(scriptPath string) {
 	// 预先统计会跳过多少任务
 	for _, scriptFile := range scriptFiles {
 		scriptName := getScriptName(scriptFile)
+		scriptContent, err := readScriptContent(scriptFile)
+		if err != nil {
+			continue
+		}
 		for _, flop := range flopSubsets {
 			taskFileName := generateTaskFileName(pathPrefix, scriptName, flop)
-			if existingFiles[taskFileName] {
+			if cfrs.Completed(taskFileName+".cfr", calcParams(scriptContent, flop)) {
 				skippedTasks++
 			}
 		}
@@ -375,13 +533,91 @@ func runCalcCommand(scriptPath string) {
 		return
 	}
 
-	// 时间统计变量
-	var totalTime time.Duration = 0
-	var completedTasks int = 0
+	// 时间统计变量（多个worker共享）
+	var (
+		statsMu        sync.Mutex
+		totalTime      time.Duration
+		completedTasks int
+		oversizedTasks int
+	)
+
+	// 内存预算和内存使用记录
+	guard := &calcMemoryGuard{
+		budget:    int64(memoryBudgetMB * (1 << 20)),
+		queuePath: filepath.Join(exportSavePath, "oversized_tasks.jsonl"),
+		usagePath: filepath.Join(exportSavePath, "memory_usage.jsonl"),
+	}
+	if guard.budget > 0 {
+		log.Printf("内存预算: %.0f MB，超出预算的任务将记入 %s", memoryBudgetMB, guard.queuePath)
+	}
+
+	log.Printf("总任务数: %d (脚本文件: %d × 公牌组合: %d)，并发数: %d", totalTasks, len(scriptFiles), len(flopSubsets), workers)
+
+	// 启动PioSolver连接池，每个worker使用其中一个实例
+	log.Printf("→ 启动 %d 个PioSolver实例...", workers)
+	pool, err := newSolverPool(workers)
+	if err != nil {
+		log.Fatalf("创建PioSolver连接池失败: %v", err)
+	}
+	if err := pool.Start(ctx); err != nil {
+		log.Fatalf("启动PioSolver连接池失败: %v", err)
+	}
+	defer pool.Close()
+
+	// 总用时按墙钟时间统计，多个worker并行时不是各任务用时之和
+	calcStart := time.Now()
+	tasks := make(chan calcTask)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				// 计算平均时间显示
+				statsMu.Lock()
+				var avgTimeStr string
+				if completedTasks > 0 {
+					avgTime := totalTime / time.Duration(completedTasks)
+					avgTimeStr = fmt.Sprintf(", 平均用时: %v", avgTime.Round(time.Second))
+				}
+				statsMu.Unlock()
+
+				log.Printf("\n[%d/%d] 🚀 开始计算: %s, 公牌: %s (%d/%d)%s", task.seq, totalTasks, task.scriptName, task.flop, task.flopProgress, len(flopSubsets), avgTimeStr)
+
+				// 记录任务开始时间
+				taskStartTime := time.Now()
+				err := runCalcTask(ctx, pool, task, pathPrefix, len(flopSubsets), guard, cfrs)
+				taskDuration := time.Since(taskStartTime)
+
+				var budgetErr *memoryBudgetError
+				if errors.As(err, &budgetErr) {
+					statsMu.Lock()
+					oversizedTasks++
+					statsMu.Unlock()
+					log.Printf("  📦 %v，已加入待大内存机器处理的队列 (%d/%d)", err, task.flopProgress, len(flopSubsets))
+					continue
+				}
+				if err != nil {
+					log.Printf("  ❌ 处理任务失败: %v (%d/%d)", err, task.flopProgress, len(flopSubsets))
+					continue
+				}
+
+				// 更新时间统计
+				statsMu.Lock()
+				totalTime += taskDuration
+				completedTasks++
+				avgTime := totalTime / time.Duration(completedTasks)
+				statsMu.Unlock()
 
-	log.Printf("总任务数: %d (脚本文件: %d × 公牌组合: %d)", totalTasks, len(scriptFiles), len(flopSubsets))
+				log.Printf("  ✓ [%d/%d] 任务完成: %s_%s (%d/%d) [用时: %v, 平均: %v]",
+					task.seq, totalTasks, task.scriptName, task.flop, task.flopProgress, len(flopSubsets),
+					taskDuration.Round(time.Second), avgTime.Round(time.Second))
+			}
+		}()
+	}
 
-	// 遍历脚本文件
+	// 遍历脚本文件，分发任务
+dispatch:
 	for _, scriptFile := range scriptFiles {
 		scriptName := getScriptName(scriptFile)
 		log.Printf("\n处理脚本文件: %s", scriptName)
@@ -390,6 +626,7 @@ func runCalcCommand(scriptPath string) {
 		scriptContent, err := readScriptContent(scriptFile)
 		if err != nil {
 			log.Printf("读取脚本内容失败: %v，跳过此文件", err)
+			currentTask += len(flopSubsets)
 			continue
 		}
 
@@ -398,857 +635,527 @@ func runCalcCommand(scriptPath string) {
 			currentTask++
 			flopProgress := flopIndex + 1 // 从1开始计数
 
-			// 检查文件是否已存在
+			// 检查清单中是否已按相同的脚本和参数完成
 			taskFileName := generateTaskFileName(pathPrefix, scriptName, flop)
-			if existingFiles[taskFileName] {
-				log.Printf("\n[%d/%d] ⏭️  跳过已存在: %s, 公牌: %s (%d/%d)", currentTask, totalTasks, scriptName, flop, flopProgress, len(flopSubsets))
-				continue
-			}
-
-			// 记录任务开始时间
-			taskStartTime := time.Now()
-
-			// 计算平均时间显示
-			var avgTimeStr string
-			if completedTasks > 0 {
-				avgTime := totalTime / time.Duration(completedTasks)
-				avgTimeStr = fmt.Sprintf(", 平均用时: %v", avgTime.Round(time.Second))
-			} else {
-				avgTimeStr = ""
-			}
-
-			log.Printf("\n[%d/%d] 🚀 开始计算: %s, 公牌: %s (%d/%d)%s", currentTask, totalTasks, scriptName, flop, flopProgress, len(flopSubsets), avgTimeStr)
-
-			// 为每个任务创建新的PioSolver实例
-			log.Printf("  → 启动新的PioSolver实例... (%d/%d)", flopProgress, len(flopSubsets))
-			client := upi.NewClient(pioSolverExePath, pioSolverWorkDir)
-
-			// 启动PioSolver
-			if err := client.Start(); err != nil {
-				log.Printf("  ❌ 启动PioSolver失败: %v，跳过此任务 (%d/%d)", err, flopProgress, len(flopSubsets))
+			if cfrs.Completed(taskFileName+".cfr", calcParams(scriptContent, flop)) {
+				log.Printf("\n[%d/%d] ⏭️  跳过已完成: %s, 公牌: %s (%d/%d)", currentTask, totalTasks, scriptName, flop, flopProgress, len(flopSubsets))
 				continue
 			}
 
-			// 检查PioSolver是否准备好
-			ready, err := client.IsReady()
-			if err != nil || !ready {
-				log.Printf("  ❌ PioSolver未准备好: %v，跳过此任务 (%d/%d)", err, flopProgress, len(flopSubsets))
-				client.Close()
-				continue
+			task := calcTask{
+				seq:           currentTask,
+				scriptFile:    scriptFile,
+				scriptName:    scriptName,
+				scriptContent: scriptContent,
+				flop:          flop,
+				flopProgress:  flopProgress,
 			}
 
-			log.Printf("  ✓ PioSolver实例就绪 (%d/%d)", flopProgress, len(flopSubsets))
-
-			// 处理单个任务（计算+导出）
-			err = processSingleTask(client, scriptContent, scriptName, flop, pathPrefix, flopProgress, len(flopSubsets))
-
-			// 关闭PioSolver实例
-			log.Printf("  → 关闭PioSolver实例... (%d/%d)", flopProgress, len(flopSubsets))
-			client.Close()
-
-			// 计算任务用时并更新统计
-			taskDuration := time.Since(taskStartTime)
-
-			if err != nil {
-				log.Printf("  ❌ 处理任务失败: %v (%d/%d)", err, flopProgress, len(flopSubsets))
-			} else {
-				// 更新时间统计
-				totalTime += taskDuration
-				completedTasks++
-
-				// 计算新的平均时间
-				avgTime := totalTime / time.Duration(completedTasks)
-
-				log.Printf("  ✓ [%d/%d] 任务完成: %s_%s (%d/%d) [用时: %v, 平均: %v]",
-					currentTask, totalTasks, scriptName, flop, flopProgress, len(flopSubsets),
-					taskDuration.Round(time.Second), avgTime.Round(time.Second))
+			// 收到中断信号时停止分发后续任务
+			select {
+			case tasks <- task:
+			case <-ctx.Done():
+				break dispatch
 			}
 		}
 	}
+	close(tasks)
+	wg.Wait()
+	elapsed := time.Since(calcStart)
+
+	if ctx.Err() != nil {
+		log.Printf("\n⛔ 批量计算被中断: %v，已停止处理剩余任务", ctx.Err())
+	}
 
 	log.Println("\n==================================")
 	log.Println("【批量计算功能】全部完成！")
 	log.Printf("📊 任务统计:")
 	log.Printf("   总任务数: %d", totalTasks)
-	log.Printf("   已跳过: %d (文件已存在)", skippedTasks)
+	log.Printf("   已跳过: %d (清单中已完成)", skippedTasks)
 	log.Printf("   新完成: %d", completedTasks)
+	if oversizedTasks > 0 {
+		log.Printf("   超出内存预算: %d (已记入 %s)", oversizedTasks, guard.queuePath)
+	}
 	if completedTasks > 0 {
 		avgTime := totalTime / time.Duration(completedTasks)
-		log.Printf("   总用时: %v，平均用时: %v", totalTime.Round(time.Second), avgTime.Round(time.Second))
+		log.Printf("   总用时: %v，平均每个任务用时: %v", elapsed.Round(time.Second), avgTime.Round(time.Second))
 	}
 	log.Println("==================================")
 }
 
-func parseNode(client *upi.Client, node string, effectiveStack float64) {
-	//show_node 获取当前节点信息，公牌，行动方（IP/OOP）
-	cmd := fmt.Sprintf("show_node %s", node)
-	responses, err := client.ExecuteCommand(cmd, 10*time.Second)
+// calcTask 是calc命令中的一个计算任务（一个脚本 × 一个公牌组合）
+type calcTask struct {
+	seq           int    // 任务序号，从1开始
+	scriptFile    string // 脚本文件路径
+	scriptName    string // 脚本名（不含扩展名）
+	scriptContent string // 脚本内容
+	flop          string // 公牌组合
+	flopProgress  int    // 公牌序号，从1开始
+}
+
+// runCalcTask 从连接池取出一个PioSolver实例执行单个计算任务，导出的CFR文件记入导出目录的清单
+func runCalcTask(ctx context.Context, pool *upi.Pool, task calcTask, pathPrefix string, totalFlops int, guard *calcMemoryGuard, cfrs *manifest.Manifest) error {
+	// 每个任务有独立的截止时间，避免批量任务卡死
+	taskCtx, cancelTask := context.WithTimeout(ctx, calcTaskTimeout)
+	defer cancelTask()
+
+	log.Printf("  → 获取PioSolver实例... (%d/%d)", task.flopProgress, totalFlops)
+	client, err := pool.Get(taskCtx)
 	if err != nil {
-		log.Printf("执行指令失败: %v，跳过此节点", err)
-		return
+		return fmt.Errorf("获取PioSolver实例失败: %v", err)
 	}
+	log.Printf("  ✓ PioSolver实例就绪 (%d/%d)", task.flopProgress, totalFlops)
 
-	// 检查响应是否足够
-	if len(responses) < 4 {
-		log.Printf("响应数据不足，跳过此节点: %v", responses)
-		return
+	// 处理单个任务（计算+导出）
+	err = processSingleTask(taskCtx, client, task.scriptContent, task.scriptName, task.flop, pathPrefix, task.flopProgress, totalFlops, guard)
+	var budgetErr *memoryBudgetError
+	if err != nil && !errors.As(err, &budgetErr) {
+		// 任务失败时PioSolver的状态未知（可能仍在求解），关闭后由连接池替换
+		log.Printf("  → 关闭状态未知的PioSolver实例... (%d/%d)", task.flopProgress, totalFlops)
+		client.Close()
 	}
-
-	actor := responses[1]
-	board := responses[2]
-	pot := responses[3]
-
-	// 检查是否为终端节点（无子节点）
-	childrenCount := "0"
-	for _, resp := range responses {
-		if strings.Contains(resp, "children") {
-			parts := strings.Fields(resp)
-			if len(parts) > 0 {
-				childrenCount = parts[0]
-			}
-			break
-		}
+	pool.Put(client)
+	if err != nil {
+		return err
 	}
 
-	// 如果是终端节点，则不需要进一步处理
-	if childrenCount == "0" {
-		log.Printf("节点 %s 没有子节点，跳过进一步处理", node)
-		return
+	taskFileName := generateTaskFileName(pathPrefix, task.scriptName, task.flop)
+	if _, err := cfrs.Record(taskFileName+".cfr", manifest.KindCFR, task.scriptFile, calcParams(task.scriptContent, task.flop), 0); err != nil {
+		return fmt.Errorf("记入清单失败: %v", err)
 	}
+	return nil
+}
 
-	//show_children 获取当前节点下的子节点，每一个子节点代表一个行动，与后续的show_strategy、每一行的结果对应
-	cmd = fmt.Sprintf("show_children %s", node)
-	responses, err = client.ExecuteCommand(cmd, 10*time.Second)
-	if err != nil {
-		log.Printf("执行指令show_children失败: %v，跳过此节点", err)
-		return
+// calcParams 返回影响calc任务导出结果的参数：脚本内容、公牌、求解精度和导出模式
+func calcParams(scriptContent, flop string) map[string]string {
+	sum := sha256.Sum256([]byte(scriptContent))
+	return map[string]string{
+		"script_sha256": hex.EncodeToString(sum[:]),
+		"flop":          flop,
+		"accuracy":      strconv.FormatFloat(solveAccuracy, 'g', -1, 64),
+		"dump_mode":     calcDumpMode,
 	}
+}
 
-	// 如果返回为空，表示没有子节点
-	if len(responses) == 0 {
-		log.Printf("节点 %s 返回空的子节点列表，跳过进一步处理", node)
-		return
-	}
+// calcMemoryGuard 是calc命令的内存保护：建树前用estimate_tree估算内存，超出预算的任务不计算，
+// 记入队列文件留给内存更大的机器；求解期间采样PioSolver进程的RSS，记入内存使用文件
+type calcMemoryGuard struct {
+	budget    int64  // 内存预算（字节），0表示不限制
+	queuePath string // 超出预算的任务（JSONL）
+	usagePath string // 每个任务的估算内存和实际RSS（JSONL）
+
+	mu sync.Mutex
+}
+
+// calcMemoryRecord 是队列文件和内存使用文件中的一行
+type calcMemoryRecord struct {
+	Time        time.Time `json:"time"`
+	Script      string    `json:"script"`
+	Flop        string    `json:"flop"`
+	Task        string    `json:"task"`
+	EstimatedMB float64   `json:"estimated_mb,omitempty"`
+	BudgetMB    float64   `json:"budget_mb,omitempty"`
+	PeakRssMB   float64   `json:"peak_rss_mb,omitempty"`
+	RssSamples  int       `json:"rss_samples,omitempty"`
+	Reason      string    `json:"reason,omitempty"` // 无法估算内存时的原因
+}
+
+// memoryBudgetError 表示任务估算的内存超出预算，或设置了预算但无法估算内存
+type memoryBudgetError struct {
+	estimate int64
+	budget   int64
+	cause    error // 估算失败的原因，nil表示超出预算
+}
 
-	// 解析子节点信息,生成对应的action
-	var children []model.ChildNode
-	var actions []model.Action
+func (e *memoryBudgetError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("无法估算内存（%v），不能保证在预算 %.0f MB 内", e.cause, bytesToMB(e.budget))
+	}
+	return fmt.Sprintf("估算内存 %.0f MB 超出预算 %.0f MB", bytesToMB(e.estimate), bytesToMB(e.budget))
+}
 
-	for i := 0; i < len(responses); i += 7 {
-		// 确保有足够的行数
-		if i+6 >= len(responses) {
-			break
+// check 在build_tree之前估算内存，超出预算或设置了预算却无法估算时记入队列并返回*memoryBudgetError；
+// 返回估算的内存（字节，无法估算时为0）
+func (g *calcMemoryGuard) check(client *upi.Client, record calcMemoryRecord) (int64, error) {
+	estimate, err := client.EstimateTree()
+	if err != nil {
+		if g.budget <= 0 {
+			log.Printf("  警告：估算树内存失败: %v，未设置内存预算，跳过内存检查", err)
+			return 0, nil
 		}
-		// 解析索引行，格式如 "child 0:"
-		var index int
-		_, err := fmt.Sscanf(responses[i], "child %d:", &index)
-		if err != nil {
-			log.Printf("解析子节点索引失败: %s, %v", responses[i], err)
-			continue
+		record.BudgetMB = roundMB(g.budget)
+		record.Reason = fmt.Sprintf("估算树内存失败: %v", err)
+		if !g.enqueue(record) {
+			log.Printf("  → 任务 %s 已在队列 %s 中，不重复记录", record.Task, filepath.Base(g.queuePath))
 		}
+		return 0, &memoryBudgetError{budget: g.budget, cause: err}
+	}
+	if mem, err := client.ShowMemory(); err == nil && mem.Free > 0 {
+		log.Printf("  → 估算树: %d 个节点，%.0f MB（PioSolver报告可用内存 %.0f MB）", estimate.Nodes, bytesToMB(estimate.Memory), bytesToMB(mem.Free))
+	} else {
+		log.Printf("  → 估算树: %d 个节点，%.0f MB", estimate.Nodes, bytesToMB(estimate.Memory))
+	}
 
-		// 创建ChildNode对象并填充数据
-		child := model.ChildNode{
-			Index:    index,
-			NodeID:   responses[i+1], // 节点ID
-			NodeType: responses[i+2], // 节点类型 IP_DEC/OOP_DEC/SPLIT_NODE
-			Board:    responses[i+3], // 公牌
-			PotInfo:  responses[i+4], // 底池信息
-			ChildNum: responses[i+5], // 子节点数量
-			Flag:     responses[i+6], // 标志
+	if g.budget > 0 && estimate.Memory > g.budget {
+		record.EstimatedMB = roundMB(estimate.Memory)
+		record.BudgetMB = roundMB(g.budget)
+		if !g.enqueue(record) {
+			log.Printf("  → 任务 %s 已在队列 %s 中，不重复记录", record.Task, filepath.Base(g.queuePath))
 		}
+		return estimate.Memory, &memoryBudgetError{estimate: estimate.Memory, budget: g.budget}
+	}
+	return estimate.Memory, nil
+}
 
-		// 打印提取的子节点信息
-		log.Printf("解析到子节点 %d: NodeID=%s, NodeType=%s, Board=%s, PotInfo=%s, Flag=%s",
-			child.Index, child.NodeID, child.NodeType, child.Board, child.PotInfo, child.Flag)
-		label, _ := util.BuildActionLabel(pot, child)
-		action := model.Action{
-			Label:       label,
-			ChildNodeID: child.NodeID,
-		}
+// enqueue 把超出预算的任务追加到队列文件，队列中已有同一任务（重复运行时）则不追加，返回是否追加
+func (g *calcMemoryGuard) enqueue(record calcMemoryRecord) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 
-		children = append(children, child)
-		actions = append(actions, action)
+	if data, err := os.ReadFile(g.queuePath); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			var queued calcMemoryRecord
+			if json.Unmarshal([]byte(line), &queued) == nil && queued.Task == record.Task {
+				return false
+			}
+		}
 	}
+	g.appendLocked(g.queuePath, record)
+	return true
+}
+
+// append 追加一行JSON记录，写入失败只记录日志
+func (g *calcMemoryGuard) append(path string, record calcMemoryRecord) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.appendLocked(path, record)
+}
 
-	// 如果没有解析到任何子节点，则返回
-	if len(children) == 0 {
-		log.Printf("节点 %s 没有解析到有效子节点，跳过进一步处理", node)
+// appendLocked 在持有mu时追加一行JSON记录
+func (g *calcMemoryGuard) appendLocked(path string, record calcMemoryRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("  警告：序列化内存记录失败: %v", err)
 		return
 	}
-
-	//show_strategy 获取当前节点1326手牌各行动对应的策略频率，行动类别参考show_children的结果
-	cmd = fmt.Sprintf("show_strategy %s", node)
-	strategy_lines, err := client.ExecuteCommand(cmd, 10*time.Second)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
-		log.Printf("执行指令show_strategy失败: %v，尝试继续处理", err)
-		// 不返回，继续尝试其他命令
-	} else if len(strategy_lines) == 0 || strings.Contains(strategy_lines[0], "ERROR") {
-		log.Printf("show_strategy返回错误或为空: %v", strategy_lines)
-		// 不返回，继续尝试其他命令
+		log.Printf("  警告：打开内存记录文件失败: %v", err)
+		return
 	}
-
-	// 获取所有手牌
-	handCards := handOrder.Order()
-	if len(handCards) != 1326 {
-		log.Printf("手牌数量错误: %d，使用现有手牌继续", len(handCards))
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		log.Printf("  警告：写入内存记录文件失败: %v", err)
 	}
+}
 
-	// 计算当前节点的bet_pct、spr和stack_depth
-	betPct, spr, stackDepth := calculateBetMetrics(pot, node, effectiveStack)
-
-	// 计算策略执行者（IP或OOP）
-	ipOrOop := calculateIpOrOop(node)
+func bytesToMB(n int64) float64 {
+	return float64(n) / (1 << 20)
+}
 
-	// 计算主动下注次数（在convertNodePath之前计算，因为convertNodePath会移除b和r前缀）
-	betLevel := calculateBetLevel(node)
+// roundMB 换算为MB并保留两位小数，用于写入记录文件
+func roundMB(n int64) float64 {
+	return math.Round(bytesToMB(n)*100) / 100
+}
 
-	// 创建一个映射，存储每个手牌的Record
-	handRecords := make(map[string]*model.Record)
+// lockNodeEV 是节点锁定前后某一方在某个节点的平均EV（按match-up加权）
+type lockNodeEV struct {
+	Node     string  `json:"node"`
+	Player   string  `json:"player"`
+	EvBefore float64 `json:"ev_before"`
+	EvAfter  float64 `json:"ev_after"`
+	EvDelta  float64 `json:"ev_delta"`
+}
 
-	// 先为每个手牌创建一个Record
-	for _, hand := range handCards {
-		// 计算手牌的combo_id
-		comboId, ok := handOrder.Index(hand)
-		if !ok {
-			log.Printf("警告：无法找到手牌 %s 的索引", hand)
-			comboId = -1 // 设置为-1表示未找到
-		}
+// lockHandEV 是节点锁定前后某一方在根节点上单个手牌的EV
+type lockHandEV struct {
+	Hand     string  `json:"hand"`
+	Player   string  `json:"player"`
+	EvBefore float64 `json:"ev_before"`
+	EvAfter  float64 `json:"ev_after"`
+	EvDelta  float64 `json:"ev_delta"`
+}
 
-		// 标准化公牌并计算board_id
-		standardizedBoard := standardizeBoard(board)
-		boardId, ok := boardOrder.Index(standardizedBoard)
-		if !ok {
-			log.Printf("警告：无法找到公牌 %s (标准化后: %s) 的索引", board, standardizedBoard)
-			boardId = -1 // 设置为-1表示未找到
-		}
-
-		handRecords[hand] = &model.Record{
-			Node:       node,
-			Actor:      actor,
-			Board:      board,
-			BoardId:    boardId, // 设置公牌ID
-			Hand:       hand,
-			ComboId:    comboId,          // 设置手牌ID
-			Actions:    []model.Action{}, // 初始化空的Actions数组
-			PotInfo:    pot,              // 设置底池信息
-			StackDepth: stackDepth,       // 设置筹码深度
-			Spr:        spr,              // 设置栈底比
-			BetPct:     betPct,           // 设置下注比例
-			IpOrOop:    ipOrOop,          // 设置策略执行者
-			BetLevel:   betLevel,         // 设置主动下注次数
-		}
-	}
-
-	// 只有当strategy_lines有效时才处理策略频率
-	if len(strategy_lines) > 0 && !strings.Contains(strategy_lines[0], "ERROR") {
-		// 收集每个手牌在所有动作下的频率
-		for i := 0; i < len(actions); i++ {
-			if i >= len(strategy_lines) {
-				log.Printf("警告: 动作数量 %d 超出策略行数 %d", len(actions), len(strategy_lines))
-				break
-			}
+// lockComparison 是lock命令输出的EV对比结果
+type lockComparison struct {
+	CfrFile        string       `json:"cfr_file"`
+	LockFile       string       `json:"lock_file"`
+	LockedNodes    []string     `json:"locked_nodes"`
+	Exploitability float64      `json:"exploitability"` // 重新求解后的可剥削值
+	Nodes          []lockNodeEV `json:"nodes"`
+	RootHands      []lockHandEV `json:"root_hands"`
+}
 
-			sline := strategy_lines[i]
-			sline_split := strings.Fields(sline) // 使用Fields代替Split，可以处理多个空格
+// nodeEVs 是某一方在某个节点上每手牌的EV及加权平均EV
+type nodeEVs struct {
+	hands   []float64
+	average float64
+}
 
-			for j, hand := range handCards {
-				if j >= len(sline_split) {
-					continue // 防止数组越界
-				}
+// runLockCommand 执行节点锁定：读取model.Record格式的锁定策略，应用到树上并锁定节点，
+// 重新求解后输出锁定前后根节点和各锁定节点上双方EV的对比
+func runLockCommand(ctx context.Context, cfrPath, lockPath, outPath string) {
+	log.Println("==================================")
+	log.Println("【节点锁定功能】正在初始化...")
+	log.Printf("CFR文件: %s", cfrPath)
+	log.Printf("锁定策略: %s", lockPath)
+	log.Println("==================================")
 
-				freq, err := strconv.ParseFloat(sline_split[j], 64)
-				if err != nil {
-					log.Printf("转换策略频率失败: %v，使用0.0替代", err)
-					freq = 0.0
-				}
+	// 出错时先由lockAndCompare的defer归还并关闭PioSolver，再退出
+	outPath, err := lockAndCompare(ctx, cfrPath, lockPath, outPath)
+	if err != nil {
+		log.Fatalf("❌ 节点锁定失败: %v", err)
+	}
 
-				// 始终添加所有动作，无论频率是否为0
-				// 复制action，并设置频率
-				action := actions[i]
-				action.Freq = freq
-				action.ChildNodeID = children[i].NodeID
+	log.Println("\n==================================")
+	log.Println("【节点锁定功能】完成！")
+	log.Printf("📄 EV对比已写入: %s", outPath)
+	log.Println("==================================")
+}
 
-				// 添加到对应手牌的Record中
-				record := handRecords[hand]
-				record.Actions = append(record.Actions, action)
-			}
+// lockAndCompare 执行runLockCommand的全部步骤，返回EV对比结果的输出路径
+func lockAndCompare(ctx context.Context, cfrPath, lockPath, outPath string) (string, error) {
+	// 读取锁定策略，按节点分组
+	lockData, err := os.ReadFile(lockPath)
+	if err != nil {
+		return "", fmt.Errorf("读取锁定策略文件失败: %v", err)
+	}
+	var lockRecords []*model.Record
+	if err := json.Unmarshal(lockData, &lockRecords); err != nil {
+		return "", fmt.Errorf("解析锁定策略文件失败: %v", err)
+	}
+	var lockedNodes []string
+	recordsByNode := make(map[string][]*model.Record)
+	for _, record := range lockRecords {
+		if _, ok := recordsByNode[record.Node]; !ok {
+			lockedNodes = append(lockedNodes, record.Node)
 		}
-
-	} else {
-		log.Printf("节点 %s 的策略数据无效，跳过策略处理", node)
-	}
-
-	//calc_ev 计算当前节点下1326手牌各行动的期望值,返回结果两行，只取第一行的ev值
-	// 先根据actor生成actorCmd
-	// actor如果是IP_DEC，则actorCmd为IP
-	// actor如果是OOP_DEC，则actorCmd为OOP
-	// actor如果是SPLIT_NODE，则RETURN
-	var actorCmd string
-	if actor == "IP_DEC" {
-		actorCmd = "IP"
-	} else if actor == "OOP_DEC" {
-		actorCmd = "OOP"
-	} else {
-		log.Printf("节点 %s 的actor不是IP_DEC或OOP_DEC: %s，跳过EV和EQ计算", node, actor)
-		// 这里不返回，因为我们可能已经有部分有用数据
+		recordsByNode[record.Node] = append(recordsByNode[record.Node], record)
+	}
+	if len(lockedNodes) == 0 {
+		return "", fmt.Errorf("锁定策略文件中没有任何记录: %s", lockPath)
 	}
+	log.Printf("✓ 读取锁定策略: %d 个节点，%d 条手牌记录", len(lockedNodes), len(lockRecords))
 
-	// 只有当actorCmd有效时才计算EV
-	if actorCmd != "" {
-		// 遍历所有动作获取EV
-		for i := 0; i < len(actions); i++ {
-			action := actions[i]
-			childNodeID := action.ChildNodeID
+	pool, err := newSolverPool(1)
+	if err != nil {
+		return "", fmt.Errorf("创建PioSolver连接池失败: %v", err)
+	}
+	if err := pool.Start(ctx); err != nil {
+		return "", fmt.Errorf("启动PioSolver失败: %v", err)
+	}
+	defer pool.Close()
+	client, err := pool.Get(ctx)
+	if err != nil {
+		return "", fmt.Errorf("PioSolver未准备好: %v", err)
+	}
+	defer pool.Put(client)
 
-			// 计算当前动作的EV值
-			cmd = fmt.Sprintf("calc_ev %s %s", actorCmd, childNodeID)
-			ev_lines, err := client.ExecuteCommand(cmd, 10*time.Second)
-			if err != nil {
-				log.Printf("执行指令失败: %v，跳过当前动作", err)
-				continue
-			}
+	hands, err := client.ShowHandOrder()
+	if err != nil {
+		return "", fmt.Errorf("获取手牌顺序失败: %v", err)
+	}
+	if err := client.LoadTree(cfrPath); err != nil {
+		return "", fmt.Errorf("加载树失败: %v", err)
+	}
+	log.Printf("✓ CFR文件加载成功")
 
-			// 检查响应是否合法
-			if len(ev_lines) == 0 || strings.Contains(ev_lines[0], "ERROR") {
-				log.Printf("calc_ev命令返回错误或无效响应: %v，跳过当前动作", ev_lines)
-				continue
-			}
+	// 根据锁定记录构造每个节点的完整策略
+	strategies := make(map[string][][]float64)
+	for _, node := range lockedNodes {
+		strategy, err := buildLockedStrategy(client, node, recordsByNode[node], hands)
+		if err != nil {
+			return "", fmt.Errorf("构造节点 %s 的锁定策略失败: %v", node, err)
+		}
+		strategies[node] = strategy
+	}
 
-			// 通常ev_lines的第一行包含所有手牌的EV值
-			ev_line := ev_lines[0] // 使用第0行
-			ev_split := strings.Fields(ev_line)
+	// 对比的节点：根节点和所有锁定节点
+	compareNodes := []string{"r:0"}
+	for _, node := range lockedNodes {
+		if node != "r:0" {
+			compareNodes = append(compareNodes, node)
+		}
+	}
 
-			//ev_lines的第二行包含所有手牌的match-up值
-			matchup_line := ev_lines[1]
-			matchup_split := strings.Fields(matchup_line)
-
-			// 遍历所有手牌，添加EV值到对应的Action中
-			for j, hand := range handCards {
-				if j >= len(ev_split) {
-					continue // 防止数组越界
-				}
-
-				// 解析EV值
-				ev, err := strconv.ParseFloat(ev_split[j], 64)
-				if err != nil || strings.Contains(strings.ToLower(ev_split[j]), "nan") {
-					// 跳过解析失败或NaN的值
-					continue
-				}
-
-				// 解析match-up值
-				matchup, err := strconv.ParseFloat(matchup_split[j], 64)
-				if err != nil || strings.Contains(strings.ToLower(matchup_split[j]), "nan") {
-					// 跳过解析失败或NaN的值
-					continue
-				}
-
-				// 在手牌的记录中查找对应的action并更新EV
-				record := handRecords[hand]
-				if record == nil {
-					continue
-				}
-
-				// 查找action并更新EV
-				for k := range record.Actions {
-					// 确认是否为同一个action（通过比较ChildNodeID或其他唯一标识）
-					if record.Actions[k].ChildNodeID == childNodeID {
-						record.Actions[k].Ev = ev
-						record.Actions[k].Matchup = matchup
-						break
-					}
-				}
-			}
-		}
-
-		//calc_eq_node 计算当前节点下1326手牌的胜率，只取第一行的eq值
-		cmd = fmt.Sprintf("calc_eq_node %s %s", actorCmd, node)
-
-		eq_lines, err := client.ExecuteCommand(cmd, 10*time.Second)
-		if err != nil {
-			log.Printf("执行指令calc_eq_node失败: %v，跳过EQ处理", err)
-		} else if len(eq_lines) == 0 || strings.Contains(eq_lines[0], "ERROR") {
-			log.Printf("calc_eq_node返回错误或为空: %v，跳过EQ处理", eq_lines)
-		} else {
-			//只读取第一行的数据
-			eq_line := eq_lines[0]
-			eq_split := strings.Fields(eq_line)
-
-			// 按照handCards顺序为每个手牌设置EQ值
-			for j, hand := range handCards {
-				if j >= len(eq_split) {
-					continue // 防止数组越界
-				}
-
-				// 跳过NaN值
-				if strings.Contains(strings.ToLower(eq_split[j]), "nan") {
-					continue
-				}
-
-				eq, err := strconv.ParseFloat(eq_split[j], 64)
-				if err != nil {
-					log.Printf("转换eq失败: %s, %v", eq_split[j], err)
-					continue
-				}
-
-				record := handRecords[hand]
-				if record == nil {
-					continue
-				}
-
-				// 为所有action设置相同的EQ值
-				for k := range record.Actions {
-					record.Actions[k].Eq = eq
-				}
-			}
-		}
+	log.Printf("  → 计算锁定前的EV...")
+	before, err := collectNodeEVs(client, compareNodes)
+	if err != nil {
+		return "", fmt.Errorf("计算锁定前EV失败: %v", err)
 	}
 
-	// 过滤NaN值和空记录并按手牌顺序重建records
-	var finalRecords []*model.Record
-	for _, hand := range handCards {
-		record := handRecords[hand]
-		if record == nil {
-			continue
+	// 应用策略并锁定节点
+	for _, node := range lockedNodes {
+		if err := client.SetStrategy(node, strategies[node]); err != nil {
+			return "", fmt.Errorf("设置节点 %s 的策略失败: %v", node, err)
 		}
-
-		// 过滤掉EV或EQ为0、NaN或Inf的Action，以及freq为0的Action
-		var validActions []model.Action
-		for _, action := range record.Actions {
-			// 检查是否所有三个值(freq、ev、eq)都是无效值(0、NaN或Inf)
-			freqIsInvalid := action.Freq == 0
-			evIsInvalid := action.Ev == 0 || math.IsInf(action.Ev, 0) || math.IsNaN(action.Ev)
-			eqIsInvalid := action.Eq == 0 || math.IsInf(action.Eq, 0) || math.IsNaN(action.Eq)
-
-			// 检查ev*matchup是否等于0且不是fold动作
-			evMultMatchupIsZero := action.Ev*action.Matchup == 0 && action.Label != "fold"
-
-			// 只有当所有三个值都无效时或者ev*matchup=0（非fold）时才过滤
-			if (freqIsInvalid && evIsInvalid && eqIsInvalid) || evMultMatchupIsZero {
-				filteredActionCount++ // 增加过滤计数
-				continue
-			}
-
-			validActions = append(validActions, action)
-		}
-
-		// 更新record的Actions
-		record.Actions = validActions
-
-		// 只有当有有效Action时才添加到finalRecords
-		// 新增条件：如果只有一个action且为fold，也过滤掉
-		if len(record.Actions) > 0 {
-			// 过滤掉只有一个fold动作的record
-			if len(record.Actions) == 1 && record.Actions[0].Label == "fold" {
-				continue
-			}
-			finalRecords = append(finalRecords, record)
+		if err := client.LockNode(node); err != nil {
+			return "", fmt.Errorf("锁定节点 %s 失败: %v", node, err)
 		}
+		log.Printf("  🔒 已锁定节点 %s", node)
 	}
 
-	// 打印JSON格式并写入到文件
-	if len(finalRecords) > 0 {
-		// 从CFR文件路径提取文件名
-		_, cfrFileName := filepath.Split(cfrFilePath)
-		cfrFileName = strings.TrimSuffix(cfrFileName, filepath.Ext(cfrFileName))
-
-		// 构建输出文件路径
-		outputJsonPath := filepath.Join("data", cfrFileName+".json")
-		outputSqlPath := filepath.Join("data", cfrFileName+".sql")
-
-		log.Printf("准备写入数据到文件: %s, 记录数: %d", outputJsonPath, len(finalRecords))
-
-		// 检查输出目录是否存在，不存在则创建
-		err = os.MkdirAll("data", 0755)
-		if err != nil {
-			log.Printf("创建输出目录失败: %v", err)
-			return
-		}
-
-		// 判断是否为根节点(深度为1)
-		isRootNode := strings.Count(node, ":") <= 1
-
-		// 处理JSON文件：根节点创建新文件，子节点追加到现有文件
-		var allRecords []*model.Record
-		if isRootNode {
-			// 根节点：创建新的JSON文件
-			allRecords = finalRecords
-		} else {
-			// 子节点：读取现有文件并追加新记录
-			fileData, err := os.ReadFile(outputJsonPath)
-			if err == nil && len(fileData) > 0 {
-				// 文件存在且不为空，尝试解析现有记录
-				err = json.Unmarshal(fileData, &allRecords)
-				if err != nil {
-					log.Printf("解析现有JSON文件失败: %v，将创建新文件", err)
-					allRecords = []*model.Record{}
-				}
-			} else {
-				// 文件不存在或为空，创建空记录数组
-				allRecords = []*model.Record{}
+	// 重新求解
+	if err := client.SetAccuracy(solveAccuracy); err != nil {
+		log.Printf("  警告：设置精度失败: %v", err)
+	}
+	log.Printf("  → 重新求解...")
+	final, err := client.WaitForSolve(ctx, upi.StopPolicy{
+		TargetExploitability: solveAccuracy,
+		MaxDuration:          30 * time.Minute,
+		StallTimeout:         10 * time.Minute,
+		OnProgress: func(p upi.SolveProgress) {
+			if !p.Stopped {
+				log.Printf("    PioSolver: 可剥削值 %.6f (用时: %v)", p.Exploitability, p.Elapsed.Round(time.Second))
 			}
-			// 将新记录追加到现有记录中
-			allRecords = append(allRecords, finalRecords...)
-		}
-
-		// 序列化所有记录并写入JSON文件
-		jsonData, err := json.MarshalIndent(allRecords, "", "  ")
-		if err != nil {
-			log.Printf("JSON序列化失败: %v", err)
-			return
-		}
-
-		err = os.WriteFile(outputJsonPath, jsonData, 0644)
-		if err != nil {
-			log.Printf("写入JSON文件失败: %v", err)
-			return
-		}
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("重新求解失败: %v", err)
+	}
+	log.Printf("  ✓ 求解完成，可剥削值 %.6f", final.Exploitability)
 
-		// 处理SQL文件：根节点创建新文件，子节点追加到现有文件
-		var sqlFile *os.File
-		if isRootNode {
-			// 根节点：创建新的SQL文件
-			sqlFile, err = os.Create(outputSqlPath)
-			if err != nil {
-				log.Printf("创建SQL文件失败: %v", err)
-				return
-			}
-			// 写入SQL文件头部
-			sqlFile.WriteString("-- Generated SQL insert statements\n")
-			sqlFile.WriteString(fmt.Sprintf("-- CFR File: %s\n", filepath.Base(cfrFilePath)))
-			sqlFile.WriteString(fmt.Sprintf("-- Total records will be added incrementally\n\n"))
-		} else {
-			// 子节点：以追加模式打开SQL文件
-			sqlFile, err = os.OpenFile(outputSqlPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				log.Printf("打开SQL文件失败: %v", err)
-				return
+	log.Printf("  → 计算锁定后的EV...")
+	after, err := collectNodeEVs(client, compareNodes)
+	if err != nil {
+		return "", fmt.Errorf("计算锁定后EV失败: %v", err)
+	}
+
+	comparison := lockComparison{
+		CfrFile:        cfrPath,
+		LockFile:       lockPath,
+		LockedNodes:    lockedNodes,
+		Exploitability: final.Exploitability,
+	}
+	for _, node := range compareNodes {
+		for _, player := range []string{"OOP", "IP"} {
+			key := node + " " + player
+			b, a := before[key], after[key]
+			comparison.Nodes = append(comparison.Nodes, lockNodeEV{
+				Node:     node,
+				Player:   player,
+				EvBefore: b.average,
+				EvAfter:  a.average,
+				EvDelta:  a.average - b.average,
+			})
+			log.Printf("    📊 %s %s: EV %.3f → %.3f (%+.3f)", node, player, b.average, a.average, a.average-b.average)
+
+			if node != "r:0" {
+				continue
 			}
-		}
-		defer sqlFile.Close()
-
-		// 为当前节点的所有记录生成SQL插入语句
-		log.Printf("开始生成SQL语句，当前节点记录数: %d", len(finalRecords))
-
-		// 从CFR文件路径提取文件名并生成表名
-		_, cfrFileName = filepath.Split(cfrFilePath)
-		tableName := generateTableName(cfrFileName)
-
-		// 统计变量
-		var (
-			totalProcessed   = 0
-			boardIndexFailed = 0
-			handIndexFailed  = 0
-			sqlGenerated     = 0
-			sqlWriteFailed   = 0
-		)
-
-		for _, record := range finalRecords {
-			totalProcessed++
-
-			// 转换节点路径为标准格式
-			nodePrefix := convertNodePath(record.Node)
-			// 使用Record中已计算的BetLevel，而不是重新计算
-			betLevel := record.BetLevel
-
-			// 生成SQL插入语句（使用Record中已计算的值和动态表名）
-			sqlInsert := generateSQLInsert(record, nodePrefix, betLevel, tableName)
-			if sqlInsert != "" {
-				sqlGenerated++
-				if _, err := sqlFile.WriteString(sqlInsert); err != nil {
-					sqlWriteFailed++
-					log.Printf("写入SQL语句失败: %v", err)
+			for j, hand := range hands {
+				if math.IsNaN(b.hands[j]) || math.IsNaN(a.hands[j]) {
+					continue
 				}
-			} else {
-				handIndexFailed++
+				comparison.RootHands = append(comparison.RootHands, lockHandEV{
+					Hand:     hand,
+					Player:   player,
+					EvBefore: b.hands[j],
+					EvAfter:  a.hands[j],
+					EvDelta:  a.hands[j] - b.hands[j],
+				})
 			}
 		}
-
-		// 输出详细统计信息
-		nodeType := "根节点"
-		if !isRootNode {
-			nodeType = "子节点"
-		}
-		log.Printf("%s SQL生成统计:", nodeType)
-		log.Printf("  当前节点处理记录数: %d", totalProcessed)
-		log.Printf("  公牌索引失败: %d", boardIndexFailed)
-		log.Printf("  手牌索引失败: %d", handIndexFailed)
-		log.Printf("  成功生成SQL: %d", sqlGenerated)
-		log.Printf("  写入失败: %d", sqlWriteFailed)
-
-		// 打印总结信息
-		log.Printf("处理完成节点 %s (%s)，JSON总记录数: %d，当前节点SQL: %d",
-			node, nodeType, len(allRecords), sqlGenerated)
 	}
 
-	//遍历子节点，递归调用解析，但是当子节点的类型为SPLIT_NODE时，不再递归调用
-	for _, child := range children {
-		if child.NodeType != "SPLIT_NODE" {
-			// 递归处理子节点
-			parseNode(client, child.NodeID, effectiveStack)
-		}
+	if outPath == "" {
+		cfrName := strings.TrimSuffix(filepath.Base(cfrPath), filepath.Ext(cfrPath))
+		lockName := strings.TrimSuffix(filepath.Base(lockPath), filepath.Ext(lockPath))
+		outPath = filepath.Join("lock", cfrName+"_"+lockName+".json")
 	}
-
-	// 如果是根节点(深度为1)，关闭JSON数组
-	if strings.Count(node, ":") <= 1 {
-		// 打印总结信息
-		log.Printf("处理完成根节点 %s，数据已保存到文件中", node)
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return "", fmt.Errorf("创建输出目录失败: %v", err)
 	}
+	data, err := json.MarshalIndent(comparison, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("JSON序列化失败: %v", err)
+	}
+	if err := os.WriteFile(outPath, data, 0644); err != nil {
+		return "", fmt.Errorf("写入对比结果失败: %v", err)
+	}
+	return outPath, nil
 }
 
-// 新增：转换节点路径为标准格式
-func convertNodePath(path string) string {
-	// 使用正则表达式匹配数字
-	re := regexp.MustCompile(`[rb](\d+)`)
-	// 将带数字的动作替换为单个字母
-	return re.ReplaceAllString(path, "${1}")
-}
-
-// 新增：计算下注次数（主动下注行为）
-func calculateBetLevel(nodePath string) int {
-	// 移除固定前缀 "r:0"
-	if !strings.HasPrefix(nodePath, "r:0") {
-		return 0
+// buildLockedStrategy 根据锁定记录构造节点的完整策略
+// 记录中的动作按ChildNodeID对应到show_children的顺序，缺少的动作频率为0，其余按比例归一化；
+// 锁定文件中没有的手牌保留当前策略
+func buildLockedStrategy(client *upi.Client, node string, records []*model.Record, hands []string) ([][]float64, error) {
+	children, err := client.ShowChildren(node)
+	if err != nil {
+		return nil, err
 	}
-
-	// 去掉 "r:0" 前缀
-	remaining := strings.TrimPrefix(nodePath, "r:0")
-	if remaining == "" {
-		return 0 // 只有 "r:0"，没有任何行动
+	current, err := client.ShowStrategy(node)
+	if err != nil {
+		return nil, err
 	}
-
-	// 移除开头的冒号
-	if strings.HasPrefix(remaining, ":") {
-		remaining = remaining[1:]
+	if len(current) != len(children) {
+		return nil, fmt.Errorf("动作数量 %d 与策略行数 %d 不一致", len(children), len(current))
 	}
 
-	if remaining == "" {
-		return 0
+	childIndex := make(map[string]int, len(children))
+	for i, child := range children {
+		childIndex[child.NodeID] = i
+	}
+	handIndex := make(map[string]int, len(hands))
+	for j, hand := range hands {
+		handIndex[hand] = j
 	}
 
-	// 按冒号分割行动
-	actions := strings.Split(remaining, ":")
-	betCount := 0
+	strategy := make([][]float64, len(current))
+	for i := range current {
+		strategy[i] = append([]float64(nil), current[i]...)
+	}
 
-	// 统计主动下注次数
-	for _, action := range actions {
-		action = strings.TrimSpace(action)
-		if action == "" {
+	for _, record := range records {
+		j, ok := handIndex[record.Hand]
+		if !ok {
+			return nil, fmt.Errorf("无法识别的手牌: %s", record.Hand)
+		}
+		freqs := make([]float64, len(children))
+		sum := 0.0
+		for _, action := range record.Actions {
+			i, ok := childIndex[action.ChildNodeID]
+			if !ok {
+				return nil, fmt.Errorf("手牌 %s 的动作 %s 不是节点 %s 的子节点", record.Hand, action.ChildNodeID, node)
+			}
+			freqs[i] = action.Freq
+			sum += action.Freq
+		}
+		if sum <= 0 {
 			continue
 		}
-
-		// 检查是否为下注行为：
-		// - 以 'b' 开头的是bet下注
-		// - 以 'r' 开头的是raise加注（也算作主动下注）
-		// - 'c' 是check，不算主动下注
-		// - 'f' 是fold，不算主动下注
-		if strings.HasPrefix(action, "b") || strings.HasPrefix(action, "r") {
-			betCount++
-			log.Printf("检测到主动下注行为: %s，当前bet_level: %d", action, betCount)
+		for i := range freqs {
+			strategy[i][j] = freqs[i] / sum
 		}
 	}
-
-	log.Printf("节点路径 %s 的bet_level: %d", nodePath, betCount)
-	return betCount
+	return strategy, nil
 }
 
-// 修改：计算bet_pct、spr和stack_depth
-func calculateBetMetrics(potInfo string, nodeId string, effectiveStack float64) (float64, float64, float64) {
-	log.Printf("解析底池信息: %s", potInfo)
-
-	// 默认值
-	var oop, ip, dead float64 = 0, 0, 0
-
-	// 解析potInfo：三个整数，以空格分隔，分别对应oop, ip, dead
-	if potInfo != "" {
-		potInfo = strings.TrimSpace(potInfo)
-		fields := strings.Fields(potInfo)
-
-		if len(fields) >= 3 {
-			// 解析oop（第一个值）
-			if val, err := strconv.ParseFloat(fields[0], 64); err == nil {
-				oop = val
-			}
-			// 解析ip（第二个值）
-			if val, err := strconv.ParseFloat(fields[1], 64); err == nil {
-				ip = val
-			}
-			// 解析dead（第三个值）
-			if val, err := strconv.ParseFloat(fields[2], 64); err == nil {
-				dead = val
-			}
-		} else {
-			log.Printf("警告：底池信息格式不正确，期望3个数值，实际得到: %d 个", len(fields))
-		}
-	}
-
-	// 计算总底池大小
-	totalPot := oop + ip + dead
-
-	// 计算bet_pct（最近一次下注占底池比例）
-	// 从nodeId中提取最后一个冒号后的值来判断最近的行动
-	betPct := 0.0
-	if nodeId != "" {
-		// 找到最后一个冒号的位置
-		lastColonIndex := strings.LastIndex(nodeId, ":")
-		if lastColonIndex != -1 && lastColonIndex < len(nodeId)-1 {
-			lastAction := nodeId[lastColonIndex+1:]
-			log.Printf("提取最后行动: %s", lastAction)
-
-			if lastAction == "c" {
-				// check行动，下注为0
-				betPct = 0.0
-				log.Printf("检测到check行动，bet_pct = 0.0")
-			} else if strings.HasPrefix(lastAction, "b") {
-				// 下注行动，提取下注金额
-				betAmountStr := strings.TrimPrefix(lastAction, "b")
-				if betAmount, err := strconv.ParseFloat(betAmountStr, 64); err == nil {
-					if totalPot > 0 {
-						betPct = betAmount / totalPot
-						log.Printf("检测到下注行动: b%s，下注金额: %.2f，底池: %.2f，bet_pct: %.3f",
-							betAmountStr, betAmount, totalPot, betPct)
-					}
-				} else {
-					log.Printf("警告：无法解析下注金额: %s", betAmountStr)
-				}
-			} else if strings.HasPrefix(lastAction, "r") {
-				// raise行动，提取加注金额
-				raiseAmountStr := strings.TrimPrefix(lastAction, "r")
-				if raiseAmount, err := strconv.ParseFloat(raiseAmountStr, 64); err == nil {
-					if totalPot > 0 {
-						betPct = raiseAmount / totalPot
-						log.Printf("检测到加注行动: r%s，加注金额: %.2f，底池: %.2f，bet_pct: %.3f",
-							raiseAmountStr, raiseAmount, totalPot, betPct)
-					}
-				} else {
-					log.Printf("警告：无法解析加注金额: %s", raiseAmountStr)
-				}
-			} else {
-				log.Printf("未识别的行动类型: %s", lastAction)
+// collectNodeEVs 计算双方在各节点上每手牌的EV和加权平均EV，键为 "节点 玩家"
+func collectNodeEVs(client *upi.Client, nodes []string) (map[string]nodeEVs, error) {
+	result := make(map[string]nodeEVs)
+	for _, node := range nodes {
+		for _, player := range []string{"OOP", "IP"} {
+			evs, matchups, err := client.CalcEV(player, node)
+			if err != nil {
+				return nil, err
 			}
-		} else {
-			log.Printf("nodeId中未找到有效的行动信息: %s", nodeId)
-		}
-	}
-
-	// 计算spr（栈底比）
-	// 使用传入的有效筹码，计算剩余筹码与底池的比例
-	remainingStack := effectiveStack - math.Max(oop, ip)
-	spr := 0.0
-	if totalPot > 0 && remainingStack > 0 {
-		spr = remainingStack / totalPot
-	}
-
-	// 计算筹码深度（后手筹码，两人中筹码量较少的一方）
-	stackDepth := math.Min(effectiveStack-oop, effectiveStack-ip)
-
-	log.Printf("计算结果: oop=%.2f, ip=%.2f, dead=%.2f, totalPot=%.2f, bet_pct=%.3f, spr=%.3f, stack_depth=%.2f",
-		oop, ip, dead, totalPot, betPct, spr, stackDepth)
-
-	return betPct, spr, stackDepth
-}
-
-// 新增：生成SQL插入语句
-func generateSQLInsert(record *model.Record, nodePrefix string, betLevel int, tableName string) string {
-	// 确保至少有一个动作
-	if len(record.Actions) == 0 {
-		return ""
-	}
-
-	// 准备第一个动作的数据
-	action1 := record.Actions[0]
-	action1Label := action1.Label
-	action1Freq := action1.Freq
-	action1Ev := action1.Ev
-	action1Eq := action1.Eq
-
-	// 准备第二个动作的数据（如果存在）
-	var action2Label string
-	var action2Freq, action2Ev, action2Eq float64
-	if len(record.Actions) > 1 {
-		action2 := record.Actions[1]
-		action2Label = action2.Label
-		action2Freq = action2.Freq
-		action2Ev = action2.Ev
-		action2Eq = action2.Eq
-	}
-
-	// 生成INSERT语句，使用动态表名
-	sql := fmt.Sprintf("INSERT IGNORE INTO %s (node_prefix, bet_level, board_id, combo_id, stack_depth, bet_pct, spr, "+
-		"board_str, combo_str, ip_or_oop, action1, freq1, ev1, eq1, action2, freq2, ev2, eq2) VALUES "+
-		"('%s', %d, %d, %d, %.3f, %.4f, %.4f, '%s', '%s', '%s', '%s', %.3f, %.3f, %.3f, '%s', %.3f, %.3f, %.3f);\n",
-		tableName, nodePrefix, betLevel, record.BoardId, record.ComboId, record.StackDepth, record.BetPct, record.Spr,
-		strings.TrimSpace(record.Board), record.Hand, record.IpOrOop, action1Label, action1Freq, action1Ev, action1Eq,
-		action2Label, action2Freq, action2Ev, action2Eq)
-
-	return sql
-}
-
-// 新增：标准化公牌顺序
-func standardizeBoard(board string) string {
-	// 移除多余的空格
-	board = strings.TrimSpace(board)
-
-	// 分割成单张牌
-	cards := strings.Fields(board)
-	if len(cards) != 3 {
-		return board // 如果不是3张牌，返回原始字符串
-	}
-
-	// 对牌进行排序（按照值和花色）
-	sort.Slice(cards, func(i, j int) bool {
-		// 获取牌值和花色
-		rank1, suit1 := cards[i][0], cards[i][1]
-		rank2, suit2 := cards[j][0], cards[j][1]
-
-		// 转换 T、J、Q、K、A 为对应的数值
-		rankValue := func(r byte) int {
-			switch r {
-			case 'T':
-				return 10
-			case 'J':
-				return 11
-			case 'Q':
-				return 12
-			case 'K':
-				return 13
-			case 'A':
-				return 14
-			default:
-				if r >= '2' && r <= '9' {
-					return int(r - '0')
+			sum, weight := 0.0, 0.0
+			for j := range evs {
+				if math.IsNaN(evs[j]) || math.IsNaN(matchups[j]) {
+					continue
 				}
-				return 0
+				sum += evs[j] * matchups[j]
+				weight += matchups[j]
 			}
-		}
-
-		// 首先按牌值比较
-		rank1Val := rankValue(rank1)
-		rank2Val := rankValue(rank2)
-		if rank1Val != rank2Val {
-			return rank1Val > rank2Val // 大的牌在前面
-		}
-
-		// 牌值相同时按花色排序 (s > h > d > c)
-		suitValue := func(s byte) int {
-			switch s {
-			case 's':
-				return 4
-			case 'h':
-				return 3
-			case 'd':
-				return 2
-			case 'c':
-				return 1
-			default:
-				return 0
+			average := 0.0
+			if weight > 0 {
+				average = sum / weight
 			}
+			result[node+" "+player] = nodeEVs{hands: evs, average: average}
 		}
-		return suitValue(suit1) > suitValue(suit2)
-	})
-
-	// 重新组合成字符串
-	return strings.Join(cards, " ")
+	}
+	return result, nil
 }
 
 // readCfrFiles 读取指定路径下的所有CFR文件
@@ -1337,83 +1244,39 @@ func replaceSetBoard(scriptContent, flop string) string {
 	return setBoardRegex.ReplaceAllString(scriptContent, newSetBoard)
 }
 
-// checkExistingParseResults 检查data目录中已存在的解析结果文件
-func checkExistingParseResults() (map[string]bool, error) {
-	existingFiles := make(map[string]bool)
-
-	// 检查data目录是否存在
-	if _, err := os.Stat("data"); os.IsNotExist(err) {
-		log.Printf("data目录不存在，将创建新目录")
-		// 创建目录
-		if err := os.MkdirAll("data", 0755); err != nil {
-			return nil, fmt.Errorf("创建data目录失败: %v", err)
+// parseCompleted 判断CFR文件是否已按params解析完成：记录、节点汇总和SQL输出都已记入清单且文件完整
+func parseCompleted(outputs *manifest.Manifest, cfrFile, format string, params map[string]string) bool {
+	recordPath, summaryPath, sqlPath := parser.OutputPaths(outputs.Dir(), cfrFile, format)
+	for _, path := range []string{recordPath, summaryPath, sqlPath} {
+		if !outputs.Completed(filepath.Base(path), params) {
+			return false
 		}
-		return existingFiles, nil
-	}
-
-	// 读取目录中的所有文件
-	files, err := os.ReadDir("data")
-	if err != nil {
-		return nil, fmt.Errorf("读取data目录失败: %v", err)
 	}
-
-	// 统计已存在的.json和.sql文件
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-
-		fileName := file.Name()
-		if strings.HasSuffix(strings.ToLower(fileName), ".json") ||
-			strings.HasSuffix(strings.ToLower(fileName), ".sql") {
-			existingFiles[fileName] = true
-		}
-	}
-
-	log.Printf("检查data目录: %s", "data")
-	log.Printf("发现已存在的解析结果文件: %d 个", len(existingFiles))
-
-	return existingFiles, nil
+	return true
 }
 
-// checkExistingFiles 检查导出目录中已存在的文件
-func checkExistingFiles() (map[string]bool, error) {
-	existingFiles := make(map[string]bool)
-
-	// 检查导出目录是否存在
-	if _, err := os.Stat(exportSavePath); os.IsNotExist(err) {
-		log.Printf("导出目录不存在: %s，将创建新目录", exportSavePath)
-		// 创建目录
-		if err := os.MkdirAll(exportSavePath, 0755); err != nil {
-			return nil, fmt.Errorf("创建导出目录失败: %v", err)
-		}
-		return existingFiles, nil
+// recordParseOutputs 把CFR文件的记录、节点汇总和SQL输出记入清单
+// 输出只有在是按params从头生成、或从记录了相同参数的断点继续时才记入，否则可能混有其他参数的结果
+func recordParseOutputs(outputs *manifest.Manifest, cfrFile, format string, params map[string]string, stats parser.Stats) error {
+	if !maps.Equal(stats.Params, params) {
+		return fmt.Errorf("输出的解析参数 %v 与本次的 %v 不同", stats.Params, params)
 	}
-
-	// 读取目录中的所有.cfr文件
-	files, err := os.ReadDir(exportSavePath)
-	if err != nil {
-		return nil, fmt.Errorf("读取导出目录失败: %v", err)
+	recordPath, summaryPath, sqlPath := parser.OutputPaths(outputs.Dir(), cfrFile, format)
+	artifacts := []struct {
+		path    string
+		kind    string
+		records int
+	}{
+		{recordPath, manifest.KindRecords, stats.Records},
+		{summaryPath, manifest.KindSummary, stats.Summaries},
+		{sqlPath, manifest.KindSQL, stats.Records},
 	}
-
-	// 统计已存在的.cfr文件
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-
-		fileName := file.Name()
-		if strings.HasSuffix(strings.ToLower(fileName), ".cfr") {
-			// 移除.cfr扩展名作为键
-			baseName := strings.TrimSuffix(fileName, ".cfr")
-			existingFiles[baseName] = true
+	for _, a := range artifacts {
+		if _, err := outputs.Record(filepath.Base(a.path), a.kind, cfrFile, params, a.records); err != nil {
+			return err
 		}
 	}
-
-	log.Printf("检查导出目录: %s", exportSavePath)
-	log.Printf("发现已存在的.cfr文件: %d 个", len(existingFiles))
-
-	return existingFiles, nil
+	return nil
 }
 
 // generateTaskFileName 生成任务文件名（不含扩展名）
@@ -1422,7 +1285,7 @@ func generateTaskFileName(pathPrefix, scriptName, flop string) string {
 }
 
 // processSingleTask 处理单个计算任务
-func processSingleTask(client *upi.Client, scriptContent, scriptName, flop, pathPrefix string, flopProgress, totalFlops int) error {
+func processSingleTask(ctx context.Context, client *upi.Client, scriptContent, scriptName, flop, pathPrefix string, flopProgress, totalFlops int, guard *calcMemoryGuard) error {
 	log.Printf("  → 开始执行任务... (%d/%d)", flopProgress, totalFlops)
 
 	log.Printf("  → 替换set_board命令为: set_board %s (%d/%d)", flop, flopProgress, totalFlops)
@@ -1435,6 +1298,13 @@ func processSingleTask(client *upi.Client, scriptContent, scriptName, flop, path
 
 	log.Printf("  → 执行脚本命令 (%d 行)", len(scriptLines))
 
+	memoryRecord := calcMemoryRecord{
+		Script: scriptName,
+		Flop:   flop,
+		Task:   generateTaskFileName(pathPrefix, scriptName, flop),
+	}
+	var estimatedMemory int64
+
 	// 逐行执行脚本命令
 	executedCount := 0
 	for _, line := range scriptLines {
@@ -1443,9 +1313,18 @@ func processSingleTask(client *upi.Client, scriptContent, scriptName, flop, path
 			continue // 跳过空行和注释
 		}
 
-		// 执行命令
-		_, err := client.ExecuteCommand(line, 30*time.Second)
-		if err != nil {
+		// 建树前估算内存，超出预算的任务不计算
+		if line == "build_tree" {
+			memoryRecord.Time = time.Now()
+			estimate, err := guard.check(client, memoryRecord)
+			if err != nil {
+				return err
+			}
+			estimatedMemory = estimate
+		}
+
+		// 执行命令：建树命令先校验参数，PioSolver返回ERROR时任务失败，不在错误的树上求解
+		if err := client.RunScriptLine(ctx, line); err != nil {
 			return fmt.Errorf("执行命令失败 '%s': %v", line, err)
 		}
 
@@ -1457,387 +1336,63 @@ func processSingleTask(client *upi.Client, scriptContent, scriptName, flop, path
 	log.Printf("  → 确保设置正确的精度...")
 
 	// 在执行go命令之前，确保设置正确的精度
-	accuracyResponses, err := client.ExecuteCommand("set_accuracy 0.12", 5*time.Second)
-	if err != nil {
+	if err := client.SetAccuracy(solveAccuracy); err != nil {
 		log.Printf("  警告：设置精度失败: %v", err)
 	} else {
-		for _, response := range accuracyResponses {
-			log.Printf("  精度设置响应: %s", response)
-		}
+		log.Printf("  ✓ 精度已设置为 %g", solveAccuracy)
 	}
 
 	log.Printf("  → 执行go命令启动计算... (%d/%d)", flopProgress, totalFlops)
 
-	// 使用专门的方法执行go命令，获取实时输出流
-	outputChan, errChan, err := client.ExecuteGoCommandWithStream()
-	if err != nil {
-		return fmt.Errorf("执行go命令失败: %v", err)
-	}
-
-	log.Printf("  → 计算已启动，开始监听PioSolver输出... (%d/%d)", flopProgress, totalFlops)
-
-	// 等待计算完成，使用实时输出流
-	err = waitForCalculationCompleteWithStream(outputChan, errChan)
-	if err != nil {
-		return fmt.Errorf("等待计算完成失败: %v", err)
-	}
-
-	// 简短等待让stream完全停止
-	log.Printf("  → 等待输出流停止... (%d/%d)", flopProgress, totalFlops)
-	time.Sleep(1 * time.Second)
-
-	log.Printf("  ✓ 计算完成，开始导出... (%d/%d)", flopProgress, totalFlops)
-
-	// 生成导出文件名
-	outputFileName := fmt.Sprintf("%s_%s_%s.cfr", pathPrefix, scriptName, flop)
-	outputPath := fmt.Sprintf(`%s%s`, exportSavePath, outputFileName)
-
-	log.Printf("  → 导出文件: %s (%d/%d)", outputFileName, flopProgress, totalFlops)
-
-	// 直接发送导出命令，不等待响应
-	dumpCmd := fmt.Sprintf(`dump_tree "%s" no_rivers `, outputPath)
-	log.Printf("  → 执行导出命令: %s (%d/%d)", dumpCmd, flopProgress, totalFlops)
-
-	// 直接发送命令，不使用ExecuteCommand以避免等待响应
-	_, err = fmt.Fprintln(client.GetStdin(), dumpCmd)
-	if err != nil {
-		log.Printf("  ❌ 发送导出命令失败: %v (%d/%d)", err, flopProgress, totalFlops)
-		return fmt.Errorf("发送导出命令失败: %v", err)
-	}
-
-	// 等待一点时间让导出命令执行，但不等待响应
-	time.Sleep(2 * time.Second)
-
-	log.Printf("  ✓ 导出命令已发送: %s (%d/%d)", outputFileName, flopProgress, totalFlops)
-
-	return nil
-}
-
-// waitForCalculationCompleteWithStream 通过实时输出流等待计算完成
-func waitForCalculationCompleteWithStream(outputChan <-chan string, errChan <-chan error) error {
-	log.Printf("    监控PioSolver实时输出...")
-
-	maxWaitTime := 30 * time.Minute     // 最长等待30分钟
-	noOutputTimeout := 30 * time.Second // 如果30秒没有输出，认为计算完成
-
-	startTime := time.Now()
-	lastOutputTime := time.Now()
-	goOkFound := false
-
-	for {
-		select {
-		case line, ok := <-outputChan:
-			if !ok {
-				// 输出通道关闭，PioSolver进程结束
-				log.Printf("    ✓ PioSolver进程结束，计算完成")
-				return nil
-			}
-
-			// 更新最后输出时间
-			lastOutputTime = time.Now()
-			elapsed := time.Since(startTime)
-
-			// 检查go命令启动确认
-			if strings.Contains(line, "go ok!") {
-				goOkFound = true
-				log.Printf("    PioSolver: %s - 计算已启动", line)
-				continue
-			}
-
-			// 如果还没有看到go ok!，继续等待
-			if !goOkFound {
-				log.Printf("    PioSolver: %s", line)
-				continue
-			}
-
-			// 过滤并显示重要的计算信息
-			if strings.Contains(line, "running time:") ||
-				strings.Contains(line, "EV OOP:") ||
-				strings.Contains(line, "EV IP:") ||
-				strings.Contains(line, "Exploitable for:") ||
-				strings.Contains(line, "SOLVER:") {
-				log.Printf("    PioSolver: %s (用时: %v)", line, elapsed.Round(time.Second))
-
-				// 检查计算完成的信号
-				if strings.Contains(line, "SOLVER: stopped (required accuracy reached)") {
-					log.Printf("    ✓ 检测到计算完成信号！")
-					return nil
-				}
-				if strings.Contains(line, "SOLVER: stopped") && !strings.Contains(line, "started") {
-					log.Printf("    ✓ 检测到求解器停止！")
-					return nil
-				}
-
-				// 检测可剥削值 - 保持严格的精度要求
-				if strings.Contains(line, "Exploitable for:") {
-					parts := strings.Fields(line)
-					if len(parts) >= 3 {
-						exploitableStr := parts[2]
-						if exploitable, err := strconv.ParseFloat(exploitableStr, 64); err == nil {
-							log.Printf("    → 当前可剥削值: %.6f (目标: ≤0.12)", exploitable)
-							// 保持严格的精度要求：可剥削值小于等于0.12
-							if exploitable <= 0.12 {
-								log.Printf("    ✓ 可剥削值 %.6f 达到精度要求，计算完成！", exploitable)
-								return nil
-							}
-						}
-					}
-				}
-			}
-
-		case err := <-errChan:
-			if err != nil {
-				return fmt.Errorf("读取PioSolver输出时出错: %v", err)
-			}
-
-		case <-time.After(1 * time.Second):
-			// 定期检查超时条件
-			elapsed := time.Since(startTime)
-
-			// 检查总超时时间
-			if elapsed > maxWaitTime {
-				return fmt.Errorf("计算超时，超过最大等待时间 %v", maxWaitTime)
-			}
-
-			// 检查是否长时间没有输出
-			if time.Since(lastOutputTime) > noOutputTimeout {
-				log.Printf("    ✓ 长时间无输出，认为计算已完成（无输出时间: %v）", time.Since(lastOutputTime).Round(time.Second))
-				return nil
-			}
-
-			// 每30秒显示一次进度
-			if int(elapsed.Seconds())%30 == 0 && goOkFound {
-				log.Printf("    计算进行中... (已用时: %v)", elapsed.Round(time.Second))
-			}
-		}
-	}
-}
-
-// waitForCalculationComplete 等待计算完成（保留原方法作为备用）
-func waitForCalculationComplete(client *upi.Client) error {
-	log.Printf("    监控PioSolver计算日志...")
-
-	maxWaitTime := 30 * time.Minute       // 最长等待30分钟
-	checkInterval := 2 * time.Second      // 每2秒检查一次
-	noResponseTimeout := 30 * time.Second // 如果30秒没有响应，认为计算完成
-
-	startTime := time.Now()
-	lastResponseTime := time.Now()
-	consecutiveCalculatingCount := 0 // 连续"计算中..."的计数器
-
-	for {
-		// 检查是否超时
-		if time.Since(startTime) > maxWaitTime {
-			return fmt.Errorf("计算超时，超过最大等待时间 %v", maxWaitTime)
-		}
-
-		// 检查是否长时间没有响应（可能计算已完成）
-		if time.Since(lastResponseTime) > noResponseTimeout {
-			log.Printf("    ✓ 长时间无响应，认为计算已完成（无响应时间: %v）", time.Since(lastResponseTime).Round(time.Second))
-			return nil
-		}
-
-		// 使用show_memory命令获取计算状态
-		responses, err := client.ExecuteCommand("show_memory", 3*time.Second)
-		if err != nil {
-			elapsed := time.Since(startTime)
-			consecutiveCalculatingCount++
-			log.Printf("    计算中... (已用时: %v, 连续%d次)", elapsed.Round(time.Second), consecutiveCalculatingCount)
-
-			// 当连续出现"计算中..."五次以上时，主动查询当前精度
-			if consecutiveCalculatingCount >= 5 {
-				log.Printf("    → 连续%d次显示计算中，主动查询当前计算精度...", consecutiveCalculatingCount)
-
-				// 尝试使用不同的命令查询状态
-				statusResponses, statusErr := client.ExecuteCommand("show_memory", 5*time.Second)
-				if statusErr == nil {
-					for _, response := range statusResponses {
-						response = strings.TrimSpace(response)
-						if response == "" {
-							continue
-						}
-
-						log.Printf("    状态查询: %s", response)
-
-						// 检测可剥削值
-						if strings.Contains(response, "Exploitable for:") {
-							parts := strings.Fields(response)
-							if len(parts) >= 3 {
-								exploitableStr := parts[2]
-								if exploitable, err := strconv.ParseFloat(exploitableStr, 64); err == nil {
-									log.Printf("    → 当前可剥削值: %.6f (目标: ≤0.12)", exploitable)
-									// 保持原来的精度要求：小于等于0.12
-									if exploitable <= 0.12 {
-										log.Printf("    ✓ 可剥削值 %.6f 达到精度要求，计算完成！", exploitable)
-										return nil
-									}
-									// 重置计数器，因为我们获得了有效的状态信息
-									consecutiveCalculatingCount = 0
-									lastResponseTime = time.Now()
-								}
-							}
-						}
-
-						// 检查其他完成信号
-						if strings.Contains(response, "SOLVER: stopped (required accuracy reached)") {
-							log.Printf("    ✓ 检测到计算完成信号！")
-							return nil
-						}
-						if strings.Contains(response, "SOLVER: stopped") && !strings.Contains(response, "started") {
-							log.Printf("    ✓ 检测到求解器停止！")
-							return nil
-						}
-					}
-				} else {
-					log.Printf("    状态查询失败: %v", statusErr)
-				}
-			}
-		} else {
-			// 显示经过时间
-			elapsed := time.Since(startTime)
-
-			// 过滤并显示有用的PioSolver计算信息
-			hasValidResponse := false
-			for _, response := range responses {
-				response = strings.TrimSpace(response)
-				if response == "" {
-					continue
-				}
-
-				// 只显示计算相关的重要信息
-				if strings.Contains(response, "running time:") ||
-					strings.Contains(response, "EV OOP:") ||
-					strings.Contains(response, "EV IP:") ||
-					strings.Contains(response, "Exploitable for:") ||
-					strings.Contains(response, "SOLVER:") {
-					log.Printf("    PioSolver: %s", response)
-					hasValidResponse = true
-					lastResponseTime = time.Now()   // 更新最后响应时间
-					consecutiveCalculatingCount = 0 // 重置计数器
-
-					// 检查计算完成的信号
-					if strings.Contains(response, "SOLVER: stopped (required accuracy reached)") {
-						log.Printf("    ✓ 检测到计算完成信号！")
-						return nil
-					}
-					if strings.Contains(response, "SOLVER: stopped") && !strings.Contains(response, "started") {
-						log.Printf("    ✓ 检测到求解器停止！")
-						return nil
-					}
-					// 检测可剥削值 - 保持原来的精度要求
-					if strings.Contains(response, "Exploitable for:") {
-						parts := strings.Fields(response)
-						if len(parts) >= 3 {
-							exploitableStr := parts[2]
-							if exploitable, err := strconv.ParseFloat(exploitableStr, 64); err == nil {
-								// 保持严格的精度要求：可剥削值小于等于0.12
-								if exploitable <= 0.12 {
-									log.Printf("    ✓ 可剥削值 %.6f 达到精度要求，计算完成！", exploitable)
-									return nil
-								}
-							}
-						}
-					}
-				}
-			}
-
-			// 如果没有有效的计算信息，只显示时间
-			if !hasValidResponse {
-				consecutiveCalculatingCount++
-				log.Printf("    计算中... (已用时: %v, 连续%d次)", elapsed.Round(time.Second), consecutiveCalculatingCount)
-			}
-		}
-
-		// 等待下次检查
-		time.Sleep(checkInterval)
-	}
-}
-
-// 新增：根据node_prefix判断策略执行者（IP或OOP）
-func calculateIpOrOop(nodePrefix string) string {
-	// 示例：r:0:c:20:70:170:370
-	// r:0 是固定前缀，然后 c(oop) -> 20(ip) -> 70(oop) -> 170(ip) -> 370(oop)
-	// 接下来应该是IP执行策略
-
-	// 移除固定前缀 "r:0"
-	if !strings.HasPrefix(nodePrefix, "r:0") {
-		log.Printf("警告：节点格式不符合预期，返回默认值IP: %s", nodePrefix)
-		return "IP"
-	}
-
-	// 去掉 "r:0" 前缀
-	remaining := strings.TrimPrefix(nodePrefix, "r:0")
-	if remaining == "" {
-		// 如果只有 "r:0"，那么第一个行动者是OOP
-		return "OOP"
-	}
-
-	// 移除开头的冒号
-	if strings.HasPrefix(remaining, ":") {
-		remaining = remaining[1:]
-	}
-
-	if remaining == "" {
-		return "OOP"
-	}
-
-	// 按冒号分割剩余部分
-	parts := strings.Split(remaining, ":")
-
-	// 计算行动次数：
-	// 第1次行动：OOP (c)
-	// 第2次行动：IP (20)
-	// 第3次行动：OOP (70)
-	// 第4次行动：IP (170)
-	// 第5次行动：OOP (370)
-	// 第6次行动：IP (下一个策略执行者)
-
-	actionCount := len(parts)
-	log.Printf("节点 %s 解析：去除r:0后=%s，行动次数=%d", nodePrefix, remaining, actionCount)
-
-	// 下一个策略执行者：
-	// 如果已有奇数次行动，下一个是IP
-	// 如果已有偶数次行动，下一个是OOP
-	if actionCount%2 == 1 {
-		return "IP"
-	} else {
-		return "OOP"
-	}
-}
-
-// 新增：从CFR文件名生成表名
-func generateTableName(cfrFileName string) string {
-	// 移除.cfr扩展名
-	baseName := strings.TrimSuffix(cfrFileName, ".cfr")
-
-	// 解析文件名格式: 40bb_COvsBB_8d5c4c
-	// 转换为: flop_40bb_co_bb_8d5c4c (包含公牌信息，用于CSV文件名)
-	parts := strings.Split(baseName, "_")
-	if len(parts) >= 3 {
-		// 提取筹码深度 (如 40bb)
-		stackDepth := parts[0]
-
-		// 提取位置信息 (如 COvsBB)
-		position := parts[1]
+	// 求解期间采样PioSolver进程的实际内存
+	stopSampling := client.SampleRSS(rssSampleInterval)
+
+	// 根据PioSolver报告的求解状态等待完成：可剥削值达到精度或求解器报告停止
+	final, err := client.WaitForSolve(ctx, upi.StopPolicy{
+		TargetExploitability: solveAccuracy,
+		MaxDuration:          30 * time.Minute,
+		StallTimeout:         10 * time.Minute,
+		OnProgress: func(p upi.SolveProgress) {
+			if p.Stopped {
+				log.Printf("    PioSolver: 求解器已停止 (%s)", p.Reason)
+				return
+			}
+			log.Printf("    PioSolver: 第%d次报告 EV OOP: %.3f, EV IP: %.3f, 可剥削值: %.6f (目标: ≤%.2f, 用时: %v)",
+				p.Iteration, p.EVOOP, p.EVIP, p.Exploitability, solveAccuracy, p.Elapsed.Round(time.Second))
+		},
+	})
+	rss := stopSampling()
+	if rss.Samples > 0 {
+		memoryRecord.Time = time.Now()
+		memoryRecord.EstimatedMB = roundMB(estimatedMemory)
+		memoryRecord.PeakRssMB = roundMB(rss.Peak)
+		memoryRecord.RssSamples = rss.Samples
+		guard.append(guard.usagePath, memoryRecord)
+		log.Printf("    📈 PioSolver内存峰值: %.0f MB (估算: %.0f MB)", bytesToMB(rss.Peak), bytesToMB(estimatedMemory))
+	}
+	if err != nil {
+		return fmt.Errorf("等待计算完成失败: %v", err)
+	}
+	log.Printf("    ✓ 求解完成，可剥削值 %.6f (%d/%d)", final.Exploitability, flopProgress, totalFlops)
 
-		// 提取公牌信息 (如 8d5c4c)
-		board := parts[2]
+	log.Printf("  ✓ 计算完成，开始导出... (%d/%d)", flopProgress, totalFlops)
 
-		// 转换位置信息为小写并格式化
-		// COvsBB -> co_bb
-		positionLower := strings.ToLower(position)
-		positionFormatted := strings.ReplaceAll(positionLower, "vs", "_")
+	// 生成导出文件名
+	outputFileName := fmt.Sprintf("%s_%s_%s.cfr", pathPrefix, scriptName, flop)
+	outputPath := fmt.Sprintf(`%s%s`, exportSavePath, outputFileName)
 
-		// 生成表名格式: flop_筹码_位置_公牌 (包含公牌，用于CSV文件名)
-		tableName := fmt.Sprintf("flop_%s_%s_%s", stackDepth, positionFormatted, board)
+	log.Printf("  → 导出文件: %s (%d/%d)", outputFileName, flopProgress, totalFlops)
 
-		log.Printf("生成CSV文件名: %s -> %s", baseName, tableName)
-		return tableName
+	// 先导出到临时文件，等待PioSolver确认导出并校验文件完整、可以重新加载后重命名为outputPath；
+	// 失败时临时文件被删除，已有的outputPath不受影响
+	if err := client.DumpTreeAtomic(outputPath, calcDumpMode); err != nil {
+		log.Printf("  ❌ 导出失败: %v (%d/%d)", err, flopProgress, totalFlops)
+		return fmt.Errorf("导出失败: %v", err)
 	}
 
-	// 如果解析失败，使用默认表名
-	log.Printf("警告：无法解析CFR文件名 %s，使用默认表名", baseName)
-	return "flop_60bb_co_bb"
+	log.Printf("  ✓ 导出完成并已校验: %s (%d/%d)", outputFileName, flopProgress, totalFlops)
+
+	return nil
 }
 
 // 新增：从CFR文件名生成表名（不包含公牌信息）
@@ -1884,46 +1439,31 @@ func runMergeCommand() {
 		log.Fatalf("data目录不存在: %s", dataDir)
 	}
 
-	// 读取data目录下的所有文件
-	files, err := os.ReadDir(dataDir)
+	// 从清单读取parse命令完成的SQL文件
+	outputs, err := manifest.Open(dataDir)
 	if err != nil {
-		log.Fatalf("读取data目录失败: %v", err)
-	}
-
-	// 过滤出SQL文件，排除data.sql
-	var sqlFiles []string
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-
-		fileName := file.Name()
-		if strings.HasSuffix(strings.ToLower(fileName), ".sql") && fileName != "data.sql" {
-			fullPath := filepath.Join(dataDir, fileName)
-			sqlFiles = append(sqlFiles, fullPath)
-		}
+		log.Fatalf("读取data目录清单失败: %v", err)
 	}
+	sqlFiles := completedSQLFiles(outputs)
 
 	if len(sqlFiles) == 0 {
-		log.Printf("在data目录中未找到任何SQL文件（除data.sql外）")
+		log.Printf("data目录的清单中没有已完成的SQL文件")
 		return
 	}
 
-	// 按文件名排序，确保汇总顺序一致
-	sort.Strings(sqlFiles)
-
 	log.Printf("找到 %d 个SQL文件需要汇总", len(sqlFiles))
 	for i, file := range sqlFiles {
 		log.Printf("  %d. %s", i+1, filepath.Base(file))
 	}
 
-	// 创建输出文件
+	// 创建输出文件：先写入临时文件，完成后重命名，中断时不会留下不完整的data.sql
 	outputPath := filepath.Join(dataDir, "data.sql")
-	outputFile, err := os.Create(outputPath)
+	outputFile, err := os.Create(outputPath + parser.TempSuffix)
 	if err != nil {
 		log.Fatalf("创建汇总文件失败: %v", err)
 	}
 	defer outputFile.Close()
+	var sources []string
 
 	// 写入文件头部
 	outputFile.WriteString("-- 汇总的SQL文件\n")
@@ -1974,6 +1514,7 @@ func runMergeCommand() {
 
 		totalLines += validLines
 		totalFiles++
+		sources = append(sources, filepath.Base(sqlFile))
 		log.Printf("  ✓ 处理完成，有效SQL语句: %d 条", validLines)
 	}
 
@@ -1985,6 +1526,16 @@ func runMergeCommand() {
 	outputFile.WriteString(fmt.Sprintf("-- 汇总完成时间: %s\n", time.Now().Format("2006-01-02 15:04:05")))
 	outputFile.WriteString("-- ========================================\n")
 
+	if err := outputFile.Close(); err != nil {
+		log.Fatalf("写入汇总文件失败: %v", err)
+	}
+	if err := os.Rename(outputPath+parser.TempSuffix, outputPath); err != nil {
+		log.Fatalf("重命名汇总文件失败: %v", err)
+	}
+	if _, err := outputs.Record(filepath.Base(outputPath), manifest.KindMerged, strings.Join(sources, ","), nil, totalLines); err != nil {
+		log.Printf("警告：汇总文件记入清单失败: %v", err)
+	}
+
 	log.Println("\n==================================")
 	log.Println("【SQL文件汇总功能】完成！")
 	log.Printf("📊 汇总统计:")
@@ -1994,6 +1545,20 @@ func runMergeCommand() {
 	log.Println("==================================")
 }
 
+// completedSQLFiles 返回清单中parse命令完成的SQL文件路径，按文件名排序
+// 文件缺失或内容与清单记录的SHA-256不一致时跳过
+func completedSQLFiles(outputs *manifest.Manifest) []string {
+	var sqlFiles []string
+	for _, entry := range outputs.Entries(manifest.KindSQL) {
+		if err := outputs.Verify(entry); err != nil {
+			log.Printf("  ⚠️  跳过SQL文件 %s: %v，请重新解析 %s", entry.Artifact, err, filepath.Base(entry.Source))
+			continue
+		}
+		sqlFiles = append(sqlFiles, filepath.Join(outputs.Dir(), entry.Artifact))
+	}
+	return sqlFiles
+}
+
 // runMergeCSVCommand 执行SQL转CSV功能
 func runMergeCSVCommand() {
 	log.Println("==================================")
@@ -2006,43 +1571,31 @@ func runMergeCSVCommand() {
 		log.Fatalf("data目录不存在: %s", dataDir)
 	}
 
-	// 读取data目录下的所有文件
-	files, err := os.ReadDir(dataDir)
+	// 从清单读取parse命令完成的SQL文件
+	outputs, err := manifest.Open(dataDir)
 	if err != nil {
-		log.Fatalf("读取data目录失败: %v", err)
-	}
-
-	// 过滤出SQL文件，排除data.sql
-	var sqlFiles []string
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-
-		fileName := file.Name()
-		if strings.HasSuffix(strings.ToLower(fileName), ".sql") && fileName != "data.sql" {
-			fullPath := filepath.Join(dataDir, fileName)
-			sqlFiles = append(sqlFiles, fullPath)
-		}
+		log.Fatalf("读取data目录清单失败: %v", err)
 	}
+	sqlFiles := completedSQLFiles(outputs)
 
 	if len(sqlFiles) == 0 {
-		log.Println("没有找到需要转换的SQL文件")
+		log.Println("data目录的清单中没有已完成的SQL文件")
 		return
 	}
 
 	log.Printf("找到 %d 个SQL文件需要转换", len(sqlFiles))
 
-	// 创建csv目录
+	// 创建csv目录，转换完成的CSV文件和导入脚本记入csv目录的清单
 	csvDir := "csv"
-	if err := os.MkdirAll(csvDir, 0755); err != nil {
+	csvOutputs, err := manifest.Open(csvDir)
+	if err != nil {
 		log.Fatalf("创建csv目录失败: %v", err)
 	}
 
 	// 统计信息
 	var totalFiles int
 	var totalRecords int
-	var csvToTableMap = make(map[string]string) // CSV文件名 -> 表名的映射
+	var csvToTableMap = make(map[string]csvTable) // CSV文件名 -> 表名和列的映射
 
 	// 为每个SQL文件生成独立的CSV文件
 	for _, sqlFile := range sqlFiles {
@@ -2053,31 +1606,46 @@ func runMergeCSVCommand() {
 		cfrFileName := strings.TrimSuffix(sqlFileName, ".sql") + ".cfr"
 
 		// 生成完整的CSV文件名（包含公牌）
-		csvBaseName := generateTableName(cfrFileName) // 包含公牌的完整名称
+		csvBaseName := parser.TableName(cfrFileName) // 包含公牌的完整名称
 		csvFileName := csvBaseName + ".csv"
 		csvFilePath := filepath.Join(csvDir, csvFileName)
 
 		// 生成表名（不包含公牌）
 		tableName := generateTableNameWithoutBoard(cfrFileName)
 
-		// 记录CSV文件到表名的映射
-		csvToTableMap[csvFileName] = tableName
-
-		// 转换单个SQL文件为CSV
-		recordCount, err := convertSQLToCSV(sqlFile, csvFilePath, tableName)
+		// 转换单个SQL文件为CSV：记录表和动作子表各一个CSV文件
+		tables, err := convertSQLToCSV(sqlFile, csvFilePath, tableName)
 		if err != nil {
 			log.Printf("转换SQL文件 %s 失败: %v", sqlFile, err)
 			continue
 		}
 
 		totalFiles++
-		totalRecords += recordCount
-		log.Printf("已生成CSV文件: %s -> 表: %s (记录数: %d)", csvFileName, tableName, recordCount)
+		for _, table := range tables {
+			// 记录CSV文件到表名的映射
+			csvToTableMap[table.csvFile] = table
+			if parser.TableSuffix(table.name) == "" {
+				totalRecords += table.rows
+			}
+			if _, err := csvOutputs.Record(table.csvFile, manifest.KindCSV, sqlFileName, nil, table.rows); err != nil {
+				log.Printf("警告：CSV文件 %s 记入清单失败: %v", table.csvFile, err)
+			}
+			log.Printf("已生成CSV文件: %s -> 表: %s (行数: %d)", table.csvFile, table.name, table.rows)
+		}
 	}
 
 	// 生成LOAD DATA脚本
 	if err := generateLoadDataScriptWithMapping(csvDir, csvToTableMap); err != nil {
 		log.Printf("生成LOAD DATA脚本失败: %v", err)
+	} else {
+		var csvFiles []string
+		for csvFile := range csvToTableMap {
+			csvFiles = append(csvFiles, csvFile)
+		}
+		sort.Strings(csvFiles)
+		if _, err := csvOutputs.Record("load_data.sql", manifest.KindLoadSQL, strings.Join(csvFiles, ","), nil, len(csvFiles)); err != nil {
+			log.Printf("警告：LOAD DATA脚本记入清单失败: %v", err)
+		}
 	}
 
 	log.Println("\n==================================")
@@ -2089,14 +1657,21 @@ func runMergeCSVCommand() {
 	log.Println("==================================")
 }
 
-// parseSQLFile 解析SQL文件，提取表名和数据记录
-func parseSQLFile(content string) (string, [][]string, error) {
+// sqlTable 是SQL文件中插入同一张表的数据
+type sqlTable struct {
+	name    string
+	columns []string
+	records [][]string
+}
+
+// parseSQLFile 解析SQL文件，按表提取列名和数据记录，表的顺序与第一次出现的顺序一致
+func parseSQLFile(content string) ([]*sqlTable, error) {
 	lines := strings.Split(content, "\n")
-	var records [][]string
-	var tableName string
+	var tables []*sqlTable
+	byName := make(map[string]*sqlTable)
 
 	// 正则表达式匹配INSERT语句
-	insertRegex := regexp.MustCompile(`INSERT\s+(?:IGNORE\s+)?INTO\s+(\w+)\s+\([^)]+\)\s+VALUES\s+\(([^)]+)\);?`)
+	insertRegex := regexp.MustCompile(`INSERT\s+(?:IGNORE\s+)?INTO\s+(\w+)\s+\(([^)]+)\)\s+VALUES\s+\(([^)]+)\);?`)
 
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -2106,14 +1681,20 @@ func parseSQLFile(content string) (string, [][]string, error) {
 
 		// 匹配INSERT语句
 		matches := insertRegex.FindStringSubmatch(line)
-		if len(matches) >= 3 {
-			// 提取表名（第一次遇到时）
-			if tableName == "" {
-				tableName = matches[1]
+		if len(matches) >= 4 {
+			// 第一次遇到表时记录表名和列名
+			table := byName[matches[1]]
+			if table == nil {
+				table = &sqlTable{name: matches[1]}
+				for _, column := range strings.Split(matches[2], ",") {
+					table.columns = append(table.columns, strings.TrimSpace(column))
+				}
+				byName[table.name] = table
+				tables = append(tables, table)
 			}
 
 			// 提取VALUES部分
-			valuesStr := matches[2]
+			valuesStr := matches[3]
 
 			// 解析VALUES中的字段值
 			values, err := parseValues(valuesStr)
@@ -2122,15 +1703,15 @@ func parseSQLFile(content string) (string, [][]string, error) {
 				continue
 			}
 
-			records = append(records, values)
+			table.records = append(table.records, values)
 		}
 	}
 
-	if tableName == "" {
-		return "", nil, fmt.Errorf("未找到有效的表名")
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("未找到有效的表名")
 	}
 
-	return tableName, records, nil
+	return tables, nil
 }
 
 // parseValues 解析SQL VALUES子句中的值
@@ -2191,24 +1772,40 @@ func parseValues(valuesStr string) ([]string, error) {
 	return values, nil
 }
 
-// writeCSVFile 写入CSV文件
-func writeCSVFile(filePath string, records [][]string) error {
-	file, err := os.Create(filePath)
+// writeOutputFile 把write的内容写入临时文件path+parser.TempSuffix，成功后重命名为path，中断或出错时不会留下不完整的输出
+func writeOutputFile(path string, write func(file *bufio.Writer) error) error {
+	tempPath := path + parser.TempSuffix
+	file, err := os.Create(tempPath)
 	if err != nil {
-		return fmt.Errorf("创建CSV文件失败: %v", err)
+		return err
 	}
-	defer file.Close()
-
-	// 写入CSV头部（字段名）
-	header := []string{
-		"node_prefix", "bet_level", "board_id", "combo_id", "stack_depth", "bet_pct", "spr",
-		"board_str", "combo_str", "ip_or_oop", "action1", "freq1", "ev1", "eq1",
-		"action2", "freq2", "ev2", "eq2",
+	w := bufio.NewWriter(file)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
 	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
 
+// writeCSVFile 写入CSV文件，header为字段名；先写入临时文件，完成后重命名
+func writeCSVFile(filePath string, header []string, records [][]string) error {
+	return writeOutputFile(filePath, func(file *bufio.Writer) error {
+		return writeCSVRecords(file, header, records)
+	})
+}
+
+// writeCSVRecords 写入CSV的头部行和数据行
+func writeCSVRecords(file *bufio.Writer, header []string, records [][]string) error {
 	// 写入头部行
 	headerLine := "\"" + strings.Join(header, "\",\"") + "\"\n"
-	_, err = file.WriteString(headerLine)
+	_, err := file.WriteString(headerLine)
 	if err != nil {
 		return fmt.Errorf("写入CSV头部失败: %v", err)
 	}
@@ -2244,15 +1841,21 @@ func writeCSVFile(filePath string, records [][]string) error {
 	return nil
 }
 
-// generateLoadDataScript 生成LOAD DATA脚本
+// generateLoadDataScript 生成LOAD DATA脚本，先写入临时文件，完成后重命名
 func generateLoadDataScript(csvDir string, tableNames []string) error {
 	scriptPath := filepath.Join(csvDir, "load_data.sql")
-	file, err := os.Create(scriptPath)
+	err := writeOutputFile(scriptPath, func(file *bufio.Writer) error {
+		writeLoadDataScript(file, csvDir, tableNames)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("创建脚本文件失败: %v", err)
+		return fmt.Errorf("写入脚本文件失败: %v", err)
 	}
-	defer file.Close()
+	return nil
+}
 
+// writeLoadDataScript 为每个表写入一条LOAD DATA语句
+func writeLoadDataScript(file *bufio.Writer, csvDir string, tableNames []string) {
 	// 写入脚本头部
 	file.WriteString("-- ========================================\n")
 	file.WriteString("-- PioSolver数据导入脚本\n")
@@ -2268,9 +1871,7 @@ func generateLoadDataScript(csvDir string, tableNames []string) error {
 		file.WriteString(fmt.Sprintf("INTO TABLE %s\n", tableName))
 		file.WriteString("FIELDS TERMINATED BY ',' ENCLOSED BY '\"'\n")
 		file.WriteString("LINES TERMINATED BY '\\n'\n")
-		file.WriteString("(node_prefix, bet_level, board_id, combo_id, stack_depth, bet_pct, spr, board_str, combo_str, ip_or_oop,\n")
-		file.WriteString(" action1, freq1, ev1, eq1,\n")
-		file.WriteString(" action2, freq2, ev2, eq2);\n\n")
+		file.WriteString(fmt.Sprintf("(%s);\n\n", strings.Join(parser.TableColumns(tableName), ", ")))
 	}
 
 	// 写入脚本尾部
@@ -2278,54 +1879,82 @@ func generateLoadDataScript(csvDir string, tableNames []string) error {
 	file.WriteString("-- 导入完成\n")
 	file.WriteString(fmt.Sprintf("-- 总表数: %d\n", len(tableNames)))
 	file.WriteString("-- ========================================\n")
+}
 
-	return nil
+// csvTable 是mergecsv生成的一个CSV文件及其导入的表
+type csvTable struct {
+	csvFile string
+	name    string
+	columns []string
+	rows    int
 }
 
-// convertSQLToCSV 将单个SQL文件转换为CSV文件
-func convertSQLToCSV(sqlFilePath, csvFilePath, tableName string) (int, error) {
+// convertSQLToCSV 将单个SQL文件转换为CSV文件：记录表写入csvFilePath并导入tableName，
+// 动作子表、节点汇总表等子表写入带相应后缀（如 _actions）的CSV并导入 tableName_actions 等
+func convertSQLToCSV(sqlFilePath, csvFilePath, tableName string) ([]csvTable, error) {
 	// 读取SQL文件内容
 	content, err := os.ReadFile(sqlFilePath)
 	if err != nil {
-		return 0, fmt.Errorf("读取SQL文件失败: %v", err)
+		return nil, fmt.Errorf("读取SQL文件失败: %v", err)
 	}
 
 	// 解析SQL文件，提取数据
-	_, records, err := parseSQLFile(string(content))
+	tables, err := parseSQLFile(string(content))
 	if err != nil {
-		return 0, fmt.Errorf("解析SQL文件失败: %v", err)
+		return nil, fmt.Errorf("解析SQL文件失败: %v", err)
 	}
 
-	if len(records) == 0 {
-		return 0, fmt.Errorf("文件中没有有效的INSERT语句")
-	}
+	var converted []csvTable
+	for _, table := range tables {
+		if len(table.records) == 0 {
+			continue
+		}
+		path, target := csvFilePath, tableName
+		if suffix := parser.TableSuffix(table.name); suffix != "" {
+			path = strings.TrimSuffix(csvFilePath, ".csv") + suffix + ".csv"
+			target = tableName + suffix
+		}
 
-	// 写入CSV文件
-	err = writeCSVFile(csvFilePath, records)
-	if err != nil {
-		return 0, fmt.Errorf("写入CSV文件失败: %v", err)
+		// 写入CSV文件
+		if err := writeCSVFile(path, table.columns, table.records); err != nil {
+			return nil, fmt.Errorf("写入CSV文件失败: %v", err)
+		}
+		converted = append(converted, csvTable{
+			csvFile: filepath.Base(path),
+			name:    target,
+			columns: table.columns,
+			rows:    len(table.records),
+		})
 	}
 
-	return len(records), nil
-}
-
-// generateLoadDataScriptWithMapping 生成LOAD DATA脚本，支持CSV文件名到表名的映射
-func generateLoadDataScriptWithMapping(csvDir string, csvToTableMap map[string]string) error {
-	scriptPath := filepath.Join(csvDir, "load_data.sql")
-	file, err := os.Create(scriptPath)
-	if err != nil {
-		return fmt.Errorf("创建脚本文件失败: %v", err)
+	if len(converted) == 0 {
+		return nil, fmt.Errorf("文件中没有有效的INSERT语句")
 	}
-	defer file.Close()
+	return converted, nil
+}
 
+// generateLoadDataScriptWithMapping 生成LOAD DATA脚本，支持CSV文件名到表名的映射；先写入临时文件，完成后重命名
+func generateLoadDataScriptWithMapping(csvDir string, csvToTableMap map[string]csvTable) error {
 	// 获取当前工作目录的绝对路径
 	currentDir, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("获取当前目录失败: %v", err)
 	}
 
-	// 构建CSV目录的绝对路径
-	csvAbsPath := filepath.Join(currentDir, csvDir)
+	scriptPath := filepath.Join(csvDir, "load_data.sql")
+	err = writeOutputFile(scriptPath, func(file *bufio.Writer) error {
+		writeLoadDataScriptWithMapping(file, filepath.Join(currentDir, csvDir), csvToTableMap)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("写入脚本文件失败: %v", err)
+	}
+	return nil
+}
+
+// writeLoadDataScriptWithMapping 为每个CSV文件写入一条导入对应表的LOAD DATA语句
+func writeLoadDataScriptWithMapping(file *bufio.Writer, csvAbsPath string, csvToTableMap map[string]csvTable) {
+
 	// 将Windows路径分隔符转换为正斜杠（MySQL兼容）
 	csvAbsPath = strings.ReplaceAll(csvAbsPath, "\\", "/")
 
@@ -2339,8 +1968,8 @@ func generateLoadDataScriptWithMapping(csvDir string, csvToTableMap map[string]s
 
 	// 按表名分组CSV文件
 	tableToCSVs := make(map[string][]string)
-	for csvFile, tableName := range csvToTableMap {
-		tableToCSVs[tableName] = append(tableToCSVs[tableName], csvFile)
+	for csvFile, table := range csvToTableMap {
+		tableToCSVs[table.name] = append(tableToCSVs[table.name], csvFile)
 	}
 
 	// 为每个表生成LOAD DATA语句
@@ -2360,9 +1989,7 @@ func generateLoadDataScriptWithMapping(csvDir string, csvToTableMap map[string]s
 			file.WriteString("FIELDS TERMINATED BY ',' ENCLOSED BY '\"'\n")
 			file.WriteString("LINES TERMINATED BY '\\n'\n")
 			file.WriteString("IGNORE 1 LINES\n") // 忽略CSV头部行
-			file.WriteString("(node_prefix, bet_level, board_id, combo_id, stack_depth, bet_pct, spr, board_str, combo_str, ip_or_oop,\n")
-			file.WriteString(" action1, freq1, ev1, eq1,\n")
-			file.WriteString(" action2, freq2, ev2, eq2);\n\n")
+			file.WriteString(fmt.Sprintf("(%s);\n\n", strings.Join(csvToTableMap[csvFileName].columns, ", ")))
 		}
 	}
 
@@ -2373,12 +2000,16 @@ func generateLoadDataScriptWithMapping(csvDir string, csvToTableMap map[string]s
 	file.WriteString(fmt.Sprintf("-- 总CSV文件数: %d\n", len(csvToTableMap)))
 	file.WriteString(fmt.Sprintf("-- CSV文件绝对路径: %s\n", csvAbsPath))
 	file.WriteString("-- ========================================\n")
-
-	return nil
 }
 
-// runJSONLCommand 执行JSONL生成功能
-func runJSONLCommand() {
+// JSONL训练数据的粒度
+const (
+	jsonlLevelCombo = "combo" // 每条记录的每个动作一条样本
+	jsonlLevelNode  = "node"  // 每个决策节点一条样本，策略分布取解析时按范围加权的节点汇总
+)
+
+// runJSONLCommand 执行JSONL生成功能，level为jsonlLevelCombo或jsonlLevelNode
+func runJSONLCommand(level string) {
 	log.Println("==================================")
 	log.Println("【JSONL生成功能】正在初始化...")
 	log.Println("==================================")
@@ -2398,6 +2029,17 @@ func runJSONLCommand() {
 
 	log.Printf("找到 %d 个表", len(tableNames))
 
+	// 生成的训练/评估数据记入当前目录的清单
+	outputs, err := manifest.Open(".")
+	if err != nil {
+		log.Fatalf("读取清单失败: %v", err)
+	}
+
+	if level == jsonlLevelNode {
+		runNodeJSONL(db, tableNames, outputs)
+		return
+	}
+
 	var allTrainingData []SimpleTrainingData
 	totalRecords := 0
 
@@ -2414,10 +2056,13 @@ func runJSONLCommand() {
 		// 解析位置信息
 		playerPos, opponentPos := parsePositionsFromTableName(tableName)
 
-		// 为每条记录生成训练数据
+		// 为每条记录的每个动作生成训练数据
 		for _, record := range records {
-			// 处理action1
-			if record.Action1 != "" && record.Freq1 > 0 {
+			for _, action := range record.Actions {
+				if action.Action == "" || action.Freq <= 0 {
+					continue
+				}
+
 				// 分析手牌特征
 				handFeatures := analyzeHandFeatures(record.ComboStr, record.BoardStr)
 
@@ -2428,38 +2073,9 @@ func runJSONLCommand() {
 					potOdds = lastActionSize / (100 + lastActionSize)
 				}
 
-				training := SimpleTrainingData{
-					Board:               record.BoardStr,
-					HoleCards:           record.ComboStr,
-					PlayerPosition:      playerPos,
-					OpponentPosition:    opponentPos,
-					PlayerIsOOP:         record.IPOrOOP == "OOP",
-					SPR:                 record.SPR,
-					BoardTextureSummary: analyzeBoardTexture(record.BoardStr),
-					ActionHistory:       parseActionHistory(record.NodePrefix, record.IPOrOOP),
-					GTOAction:           normalizeActionType(record.Action1),
-					FrequencyPct:        record.Freq1 * 100,
-					EV:                  record.EV1,
-					HandFeatures:        handFeatures,
-					Equity:              record.EQ1, // 使用原始的EQ字段
-					PotOdds:             potOdds,
-					StackDepth:          record.StackDepth,
-					BetLevel:            record.BetLevel,
-					BetPct:              record.BetPct, // 使用数据库中的bet_pct
-				}
-				allTrainingData = append(allTrainingData, training)
-			}
-
-			// 处理action2
-			if record.Action2 != "" && record.Freq2 > 0 {
-				// 分析手牌特征
-				handFeatures := analyzeHandFeatures(record.ComboStr, record.BoardStr)
-
-				// 计算底池赔率
-				potOdds := 0.0
-				lastActionSize := extractLastActionSize(record.NodePrefix)
-				if lastActionSize > 0 {
-					potOdds = lastActionSize / (100 + lastActionSize)
+				rangeWeight := record.IPWeight
+				if record.IPOrOOP == "OOP" {
+					rangeWeight = record.OOPWeight
 				}
 
 				training := SimpleTrainingData{
@@ -2471,15 +2087,16 @@ func runJSONLCommand() {
 					SPR:                 record.SPR,
 					BoardTextureSummary: analyzeBoardTexture(record.BoardStr),
 					ActionHistory:       parseActionHistory(record.NodePrefix, record.IPOrOOP),
-					GTOAction:           normalizeActionType(record.Action2),
-					FrequencyPct:        record.Freq2 * 100,
-					EV:                  record.EV2,
+					GTOAction:           normalizeActionType(action.Action),
+					FrequencyPct:        action.Freq * 100,
+					EV:                  action.EV,
 					HandFeatures:        handFeatures,
-					Equity:              record.EQ2, // 使用原始的EQ字段
+					Equity:              action.EQ, // 使用原始的EQ字段
 					PotOdds:             potOdds,
 					StackDepth:          record.StackDepth,
 					BetLevel:            record.BetLevel,
 					BetPct:              record.BetPct, // 使用数据库中的bet_pct
+					RangeWeight:         rangeWeight,
 				}
 				allTrainingData = append(allTrainingData, training)
 			}
@@ -2504,16 +2121,19 @@ func runJSONLCommand() {
 	}
 
 	// 输出JSONL文件
-	err = writeSimpleJSONLFile(filteredData, "train.jsonl")
+	err = writeJSONLFile(filteredData, "train.jsonl")
 	if err != nil {
 		log.Fatalf("写入JSONL文件失败: %v", err)
 	}
+	recordJSONLOutput(outputs, "train.jsonl", level, tableNames, len(filteredData))
 
 	// 生成评估数据集（10%的数据）
-	evalData := splitSimpleEvalData(filteredData, 0.1)
-	err = writeSimpleJSONLFile(evalData, "eval.jsonl")
+	evalData := splitEvalData(filteredData, 0.1)
+	err = writeJSONLFile(evalData, "eval.jsonl")
 	if err != nil {
 		log.Printf("写入评估数据集失败: %v", err)
+	} else {
+		recordJSONLOutput(outputs, "eval.jsonl", level, tableNames, len(evalData))
 	}
 
 	log.Println("==================================")
@@ -2526,6 +2146,58 @@ func runJSONLCommand() {
 	log.Println("==================================")
 }
 
+// runNodeJSONL 为每个决策节点生成一条训练样本：策略分布和EV读取节点汇总表，combo示例取自记录表
+func runNodeJSONL(db *sql.DB, tableNames []string, outputs *manifest.Manifest) {
+	var allTrainingData []TrainingData
+	totalSummaries := 0
+
+	for _, tableName := range tableNames {
+		log.Printf("正在处理表: %s", tableName)
+
+		records, err := fetchTableData(db, tableName)
+		if err != nil {
+			log.Printf("获取表 %s 数据失败: %v", tableName, err)
+			continue
+		}
+		summaries, err := fetchNodeSummaries(db, tableName)
+		if err != nil {
+			log.Printf("获取表 %s 的节点汇总失败: %v", tableName, err)
+			continue
+		}
+
+		aggregated := aggregateRecords(summaries, records)
+		allTrainingData = append(allTrainingData, convertToTrainingData(aggregated, tableName, records)...)
+		totalSummaries += len(summaries)
+		log.Printf("表 %s 处理了 %d 个节点汇总", tableName, len(summaries))
+	}
+
+	if err := writeJSONLFile(allTrainingData, "train_nodes.jsonl"); err != nil {
+		log.Fatalf("写入JSONL文件失败: %v", err)
+	}
+	recordJSONLOutput(outputs, "train_nodes.jsonl", jsonlLevelNode, tableNames, len(allTrainingData))
+	evalData := splitEvalData(allTrainingData, 0.1)
+	if err := writeJSONLFile(evalData, "eval_nodes.jsonl"); err != nil {
+		log.Printf("写入评估数据集失败: %v", err)
+	} else {
+		recordJSONLOutput(outputs, "eval_nodes.jsonl", jsonlLevelNode, tableNames, len(evalData))
+	}
+
+	log.Println("==================================")
+	log.Printf("【JSONL生成完成】")
+	log.Printf("✅ 节点汇总数: %d", totalSummaries)
+	log.Printf("✅ 生成的训练样本: %d", len(allTrainingData))
+	log.Printf("✅ 评估数据: %d 条", len(evalData))
+	log.Printf("✅ 输出文件: train_nodes.jsonl, eval_nodes.jsonl")
+	log.Println("==================================")
+}
+
+// recordJSONLOutput 把生成的JSONL文件记入清单，来源是读取的数据库表，记入失败只记录日志
+func recordJSONLOutput(outputs *manifest.Manifest, filename, level string, tableNames []string, samples int) {
+	if _, err := outputs.Record(filename, manifest.KindJSONL, strings.Join(tableNames, ","), map[string]string{"level": level}, samples); err != nil {
+		log.Printf("警告：%s 记入清单失败: %v", filename, err)
+	}
+}
+
 // connectDatabase 连接MySQL数据库
 func connectDatabase() (*sql.DB, error) {
 	// 数据库连接配置 - 使用用户的MySQL数据库
@@ -2552,7 +2224,7 @@ func connectDatabase() (*sql.DB, error) {
 	return db, nil
 }
 
-// getTableNames 获取所有以flop_开头的表名
+// getTableNames 获取所有以flop_开头的记录表名（不含动作子表）
 func getTableNames(db *sql.DB) ([]string, error) {
 	query := "SHOW TABLES LIKE 'flop_%'"
 	rows, err := db.Query(query)
@@ -2568,18 +2240,21 @@ func getTableNames(db *sql.DB) ([]string, error) {
 		if err != nil {
 			return nil, err
 		}
+		// 动作子表和节点汇总表随记录表一起读取
+		if parser.TableSuffix(tableName) != "" {
+			continue
+		}
 		tableNames = append(tableNames, tableName)
 	}
 
 	return tableNames, nil
 }
 
-// fetchTableData 获取表中的所有数据
+// fetchTableData 获取表中的所有数据，并从动作子表读取每条记录的全部动作
 func fetchTableData(db *sql.DB, tableName string) ([]DBRecord, error) {
 	query := fmt.Sprintf(`
 		SELECT node_prefix, bet_level, board_id, combo_id, combo_str, board_str, 
-		       ip_or_oop, stack_depth, bet_pct, spr,
-		       action1, freq1, ev1, eq1, action2, freq2, ev2, eq2
+		       ip_or_oop, stack_depth, bet_pct, spr, oop_weight, ip_weight
 		FROM %s
 	`, tableName)
 
@@ -2589,94 +2264,165 @@ func fetchTableData(db *sql.DB, tableName string) ([]DBRecord, error) {
 	}
 	defer rows.Close()
 
+	// 记录按 (node_prefix, board_id, combo_id) 与动作子表关联
+	type recordKey struct {
+		nodePrefix string
+		boardID    int
+		comboID    int
+	}
 	var records []DBRecord
+	index := make(map[recordKey]int)
 	for rows.Next() {
 		var record DBRecord
 		err := rows.Scan(
 			&record.NodePrefix, &record.BetLevel, &record.BoardID, &record.ComboID,
 			&record.ComboStr, &record.BoardStr, &record.IPOrOOP, &record.StackDepth,
-			&record.BetPct, &record.SPR, &record.Action1, &record.Freq1, &record.EV1,
-			&record.EQ1, &record.Action2, &record.Freq2, &record.EV2, &record.EQ2,
+			&record.BetPct, &record.SPR, &record.OOPWeight, &record.IPWeight,
 		)
 		if err != nil {
 			return nil, err
 		}
+		index[recordKey{record.NodePrefix, record.BoardID, record.ComboID}] = len(records)
 		records = append(records, record)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	actionRows, err := db.Query(fmt.Sprintf(`
+		SELECT node_prefix, board_id, combo_id, action_index, action, freq, ev, eq
+		FROM %s
+		ORDER BY node_prefix, board_id, combo_id, action_index
+	`, tableName+parser.ActionsTableSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("读取动作子表失败: %v", err)
+	}
+	defer actionRows.Close()
 
-	return records, nil
+	for actionRows.Next() {
+		var key recordKey
+		var action DBAction
+		if err := actionRows.Scan(&key.nodePrefix, &key.boardID, &key.comboID,
+			&action.Index, &action.Action, &action.Freq, &action.EV, &action.EQ); err != nil {
+			return nil, err
+		}
+		if i, ok := index[key]; ok {
+			records[i].Actions = append(records[i].Actions, action)
+		}
+	}
+	return records, actionRows.Err()
 }
 
-// aggregateRecords 聚合记录数据
-func aggregateRecords(records []DBRecord) map[AggregationKey]map[string]*ActionAggregation {
-	aggregated := make(map[AggregationKey]map[string]*ActionAggregation)
+// fetchNodeSummaries 读取记录表对应的节点汇总表及其汇总动作表
+func fetchNodeSummaries(db *sql.DB, tableName string) ([]DBNodeSummary, error) {
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT node_prefix, bet_level, board_id, board_str, ip_or_oop, stack_depth, bet_pct, spr,
+		       actor_ev, actor_eq, opponent_ev, opponent_eq
+		FROM %s
+	`, tableName+parser.NodesTableSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("读取节点汇总表失败: %v", err)
+	}
+	defer rows.Close()
 
-	for _, record := range records {
-		key := AggregationKey{
-			NodePrefix: record.NodePrefix,
-			BoardID:    record.BoardID,
-			IPOrOOP:    record.IPOrOOP,
-			StackDepth: record.StackDepth,
-			BetPct:     record.BetPct,
+	// 汇总按 (node_prefix, board_id) 与汇总动作表关联
+	type summaryKey struct {
+		nodePrefix string
+		boardID    int
+	}
+	var summaries []DBNodeSummary
+	index := make(map[summaryKey]int)
+	for rows.Next() {
+		var summary DBNodeSummary
+		if err := rows.Scan(&summary.NodePrefix, &summary.BetLevel, &summary.BoardID, &summary.BoardStr,
+			&summary.IPOrOOP, &summary.StackDepth, &summary.BetPct, &summary.SPR,
+			&summary.ActorEV, &summary.ActorEQ, &summary.OpponentEV, &summary.OpponentEQ); err != nil {
+			return nil, err
 		}
+		index[summaryKey{summary.NodePrefix, summary.BoardID}] = len(summaries)
+		summaries = append(summaries, summary)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	actionRows, err := db.Query(fmt.Sprintf(`
+		SELECT node_prefix, board_id, action_index, action, freq, ev
+		FROM %s
+		ORDER BY node_prefix, board_id, action_index
+	`, tableName+parser.NodeStrategyTableSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("读取节点汇总动作表失败: %v", err)
+	}
+	defer actionRows.Close()
 
-		if aggregated[key] == nil {
-			aggregated[key] = make(map[string]*ActionAggregation)
+	for actionRows.Next() {
+		var key summaryKey
+		var action DBNodeAction
+		if err := actionRows.Scan(&key.nodePrefix, &key.boardID, &action.Index, &action.Action,
+			&action.Freq, &action.EV); err != nil {
+			return nil, err
+		}
+		if i, ok := index[key]; ok {
+			summaries[i].Actions = append(summaries[i].Actions, action)
 		}
+	}
+	return summaries, actionRows.Err()
+}
 
-		// 处理action1
-		if record.Action1 != "" && record.Freq1 > 0 {
-			actionKey := record.Action1
-			sizePct := extractSizeFromAction(record.Action1)
+// aggregateRecords 聚合节点数据：动作频率和EV取解析时按范围加权计算的节点汇总，记录只用于挑选combo示例
+func aggregateRecords(summaries []DBNodeSummary, records []DBRecord) map[AggregationKey]map[string]*ActionAggregation {
+	aggregated := make(map[AggregationKey]map[string]*ActionAggregation)
 
-			if aggregated[key][actionKey] == nil {
-				aggregated[key][actionKey] = &ActionAggregation{
-					ActionType: normalizeActionType(record.Action1),
-					SizePctPot: sizePct,
-				}
-			}
+	// 节点按 (node_prefix, board_id) 查找聚合键
+	type nodeKey struct {
+		nodePrefix string
+		boardID    int
+	}
+	keys := make(map[nodeKey]AggregationKey)
 
-			agg := aggregated[key][actionKey]
-			agg.TotalFreq += record.Freq1
-			agg.TotalEV += record.EV1 * record.Freq1
-			agg.ComboCount++
+	for _, summary := range summaries {
+		key := AggregationKey{
+			NodePrefix: summary.NodePrefix,
+			BoardID:    summary.BoardID,
+			IPOrOOP:    summary.IPOrOOP,
+			StackDepth: summary.StackDepth,
+			BetPct:     summary.BetPct,
+		}
+		keys[nodeKey{summary.NodePrefix, summary.BoardID}] = key
+		aggregated[key] = make(map[string]*ActionAggregation)
 
-			// 添加combo示例
-			if len(agg.ComboExamples) < 3 {
-				note := getComboNote(record.Freq1, record.EV1, record.Action1)
-				agg.ComboExamples = append(agg.ComboExamples, ComboExample{
-					Combo:        record.ComboStr,
-					Action:       record.Action1,
-					FrequencyPct: record.Freq1 * 100,
-					Note:         note,
-				})
+		for _, action := range summary.Actions {
+			if action.Action == "" || action.Freq <= 0 {
+				continue
+			}
+			aggregated[key][action.Action] = &ActionAggregation{
+				ActionType: normalizeActionType(action.Action),
+				SizePctPot: extractSizeFromAction(action.Action),
+				TotalFreq:  action.Freq,
+				TotalEV:    action.EV * action.Freq,
 			}
 		}
+	}
 
-		// 处理action2
-		if record.Action2 != "" && record.Freq2 > 0 {
-			actionKey := record.Action2
-			sizePct := extractSizeFromAction(record.Action2)
-
-			if aggregated[key][actionKey] == nil {
-				aggregated[key][actionKey] = &ActionAggregation{
-					ActionType: normalizeActionType(record.Action2),
-					SizePctPot: sizePct,
-				}
+	// 添加combo示例
+	for _, record := range records {
+		key, ok := keys[nodeKey{record.NodePrefix, record.BoardID}]
+		if !ok {
+			continue
+		}
+		for _, action := range record.Actions {
+			agg := aggregated[key][action.Action]
+			if agg == nil || action.Freq <= 0 {
+				continue
 			}
-
-			agg := aggregated[key][actionKey]
-			agg.TotalFreq += record.Freq2
-			agg.TotalEV += record.EV2 * record.Freq2
 			agg.ComboCount++
-
-			// 添加combo示例
 			if len(agg.ComboExamples) < 3 {
-				note := getComboNote(record.Freq2, record.EV2, record.Action2)
+				note := getComboNote(action.Freq, action.EV, action.Action)
 				agg.ComboExamples = append(agg.ComboExamples, ComboExample{
 					Combo:        record.ComboStr,
-					Action:       record.Action2,
-					FrequencyPct: record.Freq2 * 100,
+					Action:       action.Action,
+					FrequencyPct: action.Freq * 100,
 					Note:         note,
 				})
 			}
@@ -2726,14 +2472,12 @@ func convertToTrainingData(aggregated map[AggregationKey]map[string]*ActionAggre
 
 		// 先统计总频率
 		for _, actionAgg := range actions {
-			if actionAgg.ComboCount > 0 {
-				totalFreq += actionAgg.TotalFreq
-			}
+			totalFreq += actionAgg.TotalFreq
 		}
 
 		// 计算归一化的频率和平均EV
 		for _, actionAgg := range actions {
-			if actionAgg.ComboCount > 0 && totalFreq > 0 {
+			if actionAgg.TotalFreq > 0 && totalFreq > 0 {
 				// 计算该动作在所有动作中的频率占比
 				actionFreqPct := (actionAgg.TotalFreq / totalFreq) * 100
 				// 计算该动作的平均EV
@@ -3176,28 +2920,24 @@ func checkConnectedness(ranks []int) string {
 	return "无顺子听牌"
 }
 
-func writeSimpleJSONLFile(data []SimpleTrainingData, filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	for _, item := range data {
-		err := encoder.Encode(item)
-		if err != nil {
-			return err
+// writeJSONLFile 每行写入一条JSON，先写入临时文件，完成后重命名
+func writeJSONLFile[T any](data []T, filename string) error {
+	return writeOutputFile(filename, func(file *bufio.Writer) error {
+		encoder := json.NewEncoder(file)
+		for _, item := range data {
+			err := encoder.Encode(item)
+			if err != nil {
+				return err
+			}
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
-func splitSimpleEvalData(data []SimpleTrainingData, ratio float64) []SimpleTrainingData {
+func splitEvalData[T any](data []T, ratio float64) []T {
 	evalSize := int(float64(len(data)) * ratio)
 	if evalSize == 0 {
-		return []SimpleTrainingData{}
+		return []T{}
 	}
 
 	// 简单的随机分割，实际可以使用更好的随机化方法
@@ -3261,14 +3001,46 @@ type DBRecord struct {
 	StackDepth float64 `json:"stack_depth"`
 	BetPct     float64 `json:"bet_pct"`
 	SPR        float64 `json:"spr"`
-	Action1    string  `json:"action1"`
-	Freq1      float64 `json:"freq1"`
-	EV1        float64 `json:"ev1"`
-	EQ1        float64 `json:"eq1"`
-	Action2    string  `json:"action2"`
-	Freq2      float64 `json:"freq2"`
-	EV2        float64 `json:"ev2"`
-	EQ2        float64 `json:"eq2"`
+	// 双方到达该节点时该手牌的范围权重
+	OOPWeight float64 `json:"oop_weight"`
+	IPWeight  float64 `json:"ip_weight"`
+	// 动作子表中该记录的全部动作，按action_index排序
+	Actions []DBAction `json:"actions"`
+}
+
+// DBAction 是动作子表中的一行
+type DBAction struct {
+	Index  int     `json:"action_index"`
+	Action string  `json:"action"`
+	Freq   float64 `json:"freq"`
+	EV     float64 `json:"ev"`
+	EQ     float64 `json:"eq"`
+}
+
+// DBNodeSummary 是节点汇总表中的一行
+type DBNodeSummary struct {
+	NodePrefix string
+	BetLevel   int
+	BoardID    int
+	BoardStr   string
+	IPOrOOP    string
+	StackDepth float64
+	BetPct     float64
+	SPR        float64
+	ActorEV    float64
+	ActorEQ    float64
+	OpponentEV float64
+	OpponentEQ float64
+	// 汇总动作表中该节点的全部动作，按action_index排序
+	Actions []DBNodeAction
+}
+
+// DBNodeAction 是汇总动作表中的一行，频率和EV已按范围加权
+type DBNodeAction struct {
+	Index  int
+	Action string
+	Freq   float64
+	EV     float64
 }
 
 // 聚合键结构体
@@ -3311,6 +3083,7 @@ type SimpleTrainingData struct {
 	StackDepth   float64      `json:"stack_depth"`   // 有效筹码深度
 	BetLevel     int          `json:"bet_level"`     // 当前下注轮次
 	BetPct       float64      `json:"bet_pct"`       // 最近下注占底池比例
+	RangeWeight  float64      `json:"range_weight"`  // 行动方到达该节点时该手牌的范围权重，可用于按到达概率加权采样
 }
 
 // 手牌特征结构体
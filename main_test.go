@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"piodatasolver/internal/fakepio"
+	"piodatasolver/internal/manifest"
+	"piodatasolver/internal/upi"
+	"piodatasolver/model"
+	"piodatasolver/parser"
+)
+
+// fixturePath 是fakepio加载的合成博弈树
+var fixturePath, _ = filepath.Abs("testdata/fakepio/40bb_COvsBB_Ks7d2c.cfr")
+
+// TestMain 编译fakepio并让各命令使用它代替PioSolver
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "fakepio")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	pioSolverExePath, err = fakepio.Build(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	pioSolverWorkDir = dir
+	pioSolverAddr, pioRecordPath, pioReplayPath = "", "", ""
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// writeLockFile 把根节点上一手牌的锁定策略（全部选择child）写入锁定策略文件
+func writeLockFile(t *testing.T, child string) string {
+	t.Helper()
+	hand := ""
+	for _, h := range fakepio.HandOrder() {
+		if !strings.ContainsAny(h, "K7") && !strings.Contains(h, "2c") {
+			hand = h
+			break
+		}
+	}
+	records := []*model.Record{{Node: "r:0", Hand: hand, Actions: []model.Action{{ChildNodeID: child, Freq: 1}}}}
+	data, err := json.Marshal(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "lock.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLockAndCompare(t *testing.T) {
+	outPath := filepath.Join(t.TempDir(), "comparison.json")
+	got, err := lockAndCompare(context.Background(), fixturePath, writeLockFile(t, "r:0:b20"), outPath)
+	if err != nil {
+		t.Fatalf("lockAndCompare: %v", err)
+	}
+	if got != outPath {
+		t.Errorf("输出路径 = %s，应为 %s", got, outPath)
+	}
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var comparison lockComparison
+	if err := json.Unmarshal(data, &comparison); err != nil {
+		t.Fatal(err)
+	}
+	if len(comparison.LockedNodes) != 1 || len(comparison.Nodes) != 2 || len(comparison.RootHands) == 0 {
+		t.Errorf("EV对比 = %d 个锁定节点, %d 个节点EV, %d 手牌EV",
+			len(comparison.LockedNodes), len(comparison.Nodes), len(comparison.RootHands))
+	}
+}
+
+func TestLockAndCompareReturnsErrors(t *testing.T) {
+	// 出错时返回错误而不是直接退出，调用方的defer（归还并关闭PioSolver）得以执行
+	outPath := filepath.Join(t.TempDir(), "comparison.json")
+	if _, err := lockAndCompare(context.Background(), fixturePath, writeLockFile(t, "r:0:no_such_child"), outPath); err == nil ||
+		!strings.Contains(err.Error(), "r:0:no_such_child") {
+		t.Errorf("锁定不存在的动作应返回错误，实际 %v", err)
+	}
+	if _, err := lockAndCompare(context.Background(), fixturePath, filepath.Join(t.TempDir(), "missing.json"), outPath); err == nil {
+		t.Error("锁定策略文件不存在时应返回错误")
+	}
+	if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+		t.Error("出错时不应写出EV对比结果")
+	}
+}
+
+func TestCalcMemoryGuardEnqueueOnce(t *testing.T) {
+	dir := t.TempDir()
+	guard := &calcMemoryGuard{budget: 1 << 30, queuePath: filepath.Join(dir, "oversized_tasks.jsonl")}
+
+	// 重复运行时同一任务只追加一次
+	task := calcMemoryRecord{Script: "script.txt", Flop: "Ks7d2c", Task: "40bb_COvsBB_Ks7d2c.cfr"}
+	if !guard.enqueue(task) {
+		t.Error("第一次应追加到队列")
+	}
+	if guard.enqueue(task) {
+		t.Error("已在队列中的任务不应重复追加")
+	}
+	other := task
+	other.Task = "40bb_COvsBB_8d5c4c.cfr"
+	if !guard.enqueue(other) {
+		t.Error("不同的任务应追加到队列")
+	}
+
+	data, err := os.ReadFile(guard.queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("队列有 %d 行，应为 2 行", n)
+	}
+}
+
+func TestCalcMemoryGuardEstimateFailure(t *testing.T) {
+	// 未设置公牌时estimate_tree返回ERROR
+	client := upi.NewClient(pioSolverExePath, pioSolverWorkDir)
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	task := calcMemoryRecord{Script: "script.txt", Flop: "Ks7d2c", Task: "40bb_COvsBB_Ks7d2c.cfr"}
+
+	// 未设置预算时跳过检查
+	unlimited := &calcMemoryGuard{queuePath: filepath.Join(t.TempDir(), "oversized_tasks.jsonl")}
+	if estimate, err := unlimited.check(client, task); err != nil || estimate != 0 {
+		t.Errorf("未设置预算时 check = %d, %v", estimate, err)
+	}
+	if _, err := os.Stat(unlimited.queuePath); !os.IsNotExist(err) {
+		t.Error("未设置预算时不应记入队列")
+	}
+
+	// 设置了预算却无法估算时拒绝任务并记入队列
+	guard := &calcMemoryGuard{budget: 1 << 30, queuePath: filepath.Join(t.TempDir(), "oversized_tasks.jsonl")}
+	_, err := guard.check(client, task)
+	var budgetErr *memoryBudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.cause == nil {
+		t.Fatalf("无法估算内存时应拒绝任务，实际 %v", err)
+	}
+	data, err := os.ReadFile(guard.queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var queued calcMemoryRecord
+	if err := json.Unmarshal(data, &queued); err != nil {
+		t.Fatal(err)
+	}
+	if queued.Task != task.Task || queued.BudgetMB != 1024 || !strings.Contains(queued.Reason, "board not set") {
+		t.Errorf("队列记录 = %+v", queued)
+	}
+}
+
+func TestRecordParseOutputsRequiresSameParams(t *testing.T) {
+	dir := t.TempDir()
+	outputs, err := manifest.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfrFile := "40bb_COvsBB_Ks7d2c.cfr"
+	recordPath, summaryPath, sqlPath := parser.OutputPaths(dir, cfrFile, parser.FormatJSON)
+	for _, path := range []string{recordPath, summaryPath, sqlPath} {
+		if err := os.WriteFile(path, []byte("[]"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	params := map[string]string{"format": parser.FormatJSON, "actor": "IP"}
+
+	// 输出来自参数不同的断点时不记入清单
+	stale := parser.Stats{Params: map[string]string{"format": parser.FormatJSON, "actor": ""}}
+	if err := recordParseOutputs(outputs, cfrFile, parser.FormatJSON, params, stale); err == nil {
+		t.Error("输出的参数与本次不同时应返回错误")
+	}
+	if parseCompleted(outputs, cfrFile, parser.FormatJSON, params) {
+		t.Errorf("%s 不应被记为已完成", filepath.Base(recordPath))
+	}
+
+	if err := recordParseOutputs(outputs, cfrFile, parser.FormatJSON, params, parser.Stats{Params: params}); err != nil {
+		t.Fatalf("recordParseOutputs: %v", err)
+	}
+	if !parseCompleted(outputs, cfrFile, parser.FormatJSON, params) {
+		t.Error("参数相同的输出应记为已完成")
+	}
+}
+
+func TestWriteOutputFileKeepsPreviousOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "train.jsonl")
+	if err := writeJSONLFile([]map[string]int{{"a": 1}, {"a": 2}}, path); err != nil {
+		t.Fatal(err)
+	}
+
+	// 写入出错时不替换已有的完整输出，也不留下临时文件
+	err := writeOutputFile(path, func(file *bufio.Writer) error {
+		file.WriteString("{\"a\":")
+		return errors.New("中断")
+	})
+	if err == nil {
+		t.Fatal("写入出错时应返回错误")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "{\"a\":1}\n{\"a\":2}\n" {
+		t.Errorf("已有的输出被替换为 %q", data)
+	}
+	if _, err := os.Stat(path + parser.TempSuffix); !os.IsNotExist(err) {
+		t.Error("写入出错后不应留下临时文件")
+	}
+}
+
+func TestMergeCSVRecordsOutputs(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// data目录清单中记录的一个SQL文件
+	outputs, err := manifest.Open("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql := "INSERT INTO flop_40bb_co_bb (node_prefix, combo_str, freq) VALUES ('r:0', 'AhAd', 0.5);\n" +
+		"INSERT INTO flop_40bb_co_bb (node_prefix, combo_str, freq) VALUES ('r:0', 'KhKd', 1);\n"
+	if err := os.WriteFile(filepath.Join("data", "40bb_COvsBB_Ks7d2c.sql"), []byte(sql), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := outputs.Record("40bb_COvsBB_Ks7d2c.sql", manifest.KindSQL, "40bb_COvsBB_Ks7d2c.cfr", nil, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	runMergeCSVCommand()
+
+	// CSV文件和导入脚本都记入csv目录的清单，且与清单记录一致，不留下临时文件
+	csvOutputs, err := manifest.Open("csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	csvEntries, scripts := csvOutputs.Entries(manifest.KindCSV), csvOutputs.Entries(manifest.KindLoadSQL)
+	if len(csvEntries) != 1 || csvEntries[0].Records != 2 || csvEntries[0].Source != "40bb_COvsBB_Ks7d2c.sql" {
+		t.Errorf("清单中的CSV文件 = %+v", csvEntries)
+	}
+	if len(scripts) != 1 || scripts[0].Artifact != "load_data.sql" {
+		t.Errorf("清单中的导入脚本 = %+v", scripts)
+	}
+	for _, entry := range append(csvEntries, scripts...) {
+		if err := csvOutputs.Verify(entry); err != nil {
+			t.Errorf("%s: %v", entry.Artifact, err)
+		}
+	}
+	if temps, _ := filepath.Glob(filepath.Join("csv", "*"+parser.TempSuffix)); len(temps) > 0 {
+		t.Errorf("不应留下临时文件: %v", temps)
+	}
+}
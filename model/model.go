@@ -28,7 +28,8 @@ type ChildNode struct {
 type Record struct {
 	Node       string   `json:"node"`        //节点id
 	Actor      string   `json:"actor"`       //行动方
-	Board      string   `json:"board"`       //公共牌
+	Board      string   `json:"board"`       //公共牌（转牌/河牌节点为完整公牌）
+	Street     string   `json:"street"`      //所在街：flop/turn/river
 	BoardId    int64    `json:"board_id"`    //公牌ID索引
 	Hand       string   `json:"hand"`        //玩家手牌
 	ComboId    int      `json:"combo_id"`    //手牌ID索引
@@ -39,6 +40,39 @@ type Record struct {
 	BetPct     float64  `json:"-"`           //下注占底池比例 - 使用自定义序列化
 	IpOrOop    string   `json:"ip_or_oop"`   //策略执行者（IP或OOP）
 	BetLevel   int      `json:"bet_level"`   //主动下注次数
+	OopWeight  float64  `json:"oop_weight"`  //OOP到达该节点时持有这手牌的范围权重
+	IpWeight   float64  `json:"ip_weight"`   //IP到达该节点时持有这手牌的范围权重
+}
+
+// NodeSummary 是一个决策节点按双方范围加权的汇总，每个决策节点一条
+type NodeSummary struct {
+	Node           string       `json:"node"`            //节点id
+	Actor          string       `json:"actor"`           //行动方
+	Board          string       `json:"board"`           //公共牌（转牌/河牌节点为完整公牌）
+	Street         string       `json:"street"`          //所在街：flop/turn/river
+	BoardId        int64        `json:"board_id"`        //公牌ID索引
+	IpOrOop        string       `json:"ip_or_oop"`       //策略执行者（IP或OOP）
+	BetLevel       int          `json:"bet_level"`       //主动下注次数
+	PotInfo        string       `json:"pot_info"`        //底池信息
+	Pot            float64      `json:"pot"`             //底池总额
+	StackDepth     float64      `json:"stack_depth"`     //筹码深度（后手筹码）
+	Spr            float64      `json:"spr"`             //栈底比
+	BetPct         float64      `json:"bet_pct"`         //下注占底池比例
+	Actions        []NodeAction `json:"actions"`         //行动方的各动作，按行动方范围加权
+	ActorCombos    float64      `json:"actor_combos"`    //行动方范围的组合数（范围权重之和）
+	OpponentCombos float64      `json:"opponent_combos"` //对手范围的组合数
+	ActorEv        float64      `json:"actor_ev"`        //行动方范围的整体EV
+	ActorEq        float64      `json:"actor_eq"`        //行动方范围的整体胜率
+	OpponentEv     float64      `json:"opponent_ev"`     //对手范围的整体EV
+	OpponentEq     float64      `json:"opponent_eq"`     //对手范围的整体胜率
+}
+
+// NodeAction 是节点汇总中的一个动作
+type NodeAction struct {
+	ChildNodeID string  `json:"ChildNodeID"` // 这个action对应的子节点ID
+	Label       string  `json:"label"`       //"bet 75%" / "check"
+	Freq        float64 `json:"freq"`        //行动方范围执行该动作的频率 0.00-1.00
+	Ev          float64 `json:"ev"`          //执行该动作的组合的平均EV
 }
 
 // MarshalJSON 自定义JSON序列化，控制Spr和BetPct的小数位数
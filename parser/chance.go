@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strings"
+
+	"piodatasolver/model"
+)
+
+// parseChanceNode 解析发牌节点：按Cards过滤发出的牌，按CardSample抽样后逐个解析对应的子树
+func (f *fileParse) parseChanceNode(node, board string, children []model.ChildNode) error {
+	selected := f.selectCards(node, children)
+	log.Printf("发牌节点 %s (%s)：共 %d 张牌，解析 %d 张", node, board, len(children), len(selected))
+
+	for _, child := range selected {
+		if err := f.parseNode(child.NodeID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// descends 判断是否进入公牌为board的发牌节点，即发出的下一条街是否在Street范围内
+func (f *fileParse) descends(board string) bool {
+	return streetRank(streetOf(board))+1 <= streetRank(f.config.Street)
+}
+
+// selectCards 从发牌节点的子节点中选出要解析的牌，保持PioSolver返回的顺序
+// 抽样按节点ID和牌计算的哈希排序后取前CardSample张，同一棵树重复解析的结果相同
+func (f *fileParse) selectCards(node string, children []model.ChildNode) []model.ChildNode {
+	var selected []model.ChildNode
+	for _, child := range children {
+		if f.cardAllowed(dealtCard(child)) {
+			selected = append(selected, child)
+		}
+	}
+
+	n := f.config.CardSample
+	if n <= 0 || len(selected) <= n {
+		return selected
+	}
+	key := func(child model.ChildNode) uint64 {
+		h := fnv.New64a()
+		h.Write([]byte(node + "/" + dealtCard(child)))
+		return h.Sum64()
+	}
+	sampled := append([]model.ChildNode(nil), selected...)
+	sort.SliceStable(sampled, func(i, j int) bool { return key(sampled[i]) < key(sampled[j]) })
+	sampled = sampled[:n]
+	sort.SliceStable(sampled, func(i, j int) bool { return sampled[i].Index < sampled[j].Index })
+	return sampled
+}
+
+// cardAllowed 判断发出的牌是否符合Cards过滤，未设置过滤时都符合
+func (f *fileParse) cardAllowed(card string) bool {
+	if len(f.config.Cards) == 0 {
+		return true
+	}
+	for _, c := range f.config.Cards {
+		if c == card || (len(c) == 1 && card != "" && c[0] == card[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// dealtCard 返回发牌节点子节点对应的牌：节点ID的最后一段，或公牌的最后一张
+func dealtCard(child model.ChildNode) string {
+	if i := strings.LastIndex(child.NodeID, ":"); i >= 0 && isCard(child.NodeID[i+1:]) {
+		return child.NodeID[i+1:]
+	}
+	if cards := strings.Fields(child.Board); len(cards) > 0 {
+		return cards[len(cards)-1]
+	}
+	return ""
+}
+
+// normalizeCards 把Cards过滤统一为点数大写、花色小写的形式，如 "ah" -> "Ah"、"t" -> "T"
+func normalizeCards(cards []string) ([]string, error) {
+	var normalized []string
+	for _, c := range cards {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		switch len(c) {
+		case 1:
+			c = strings.ToUpper(c)
+		case 2:
+			c = strings.ToUpper(c[:1]) + strings.ToLower(c[1:])
+		}
+		if !isCard(c) && !(len(c) == 1 && strings.Contains("23456789TJQKA", c)) {
+			return nil, fmt.Errorf("无效的牌: %s（应为具体的牌如 Ah，或点数如 A）", c)
+		}
+		normalized = append(normalized, c)
+	}
+	return normalized, nil
+}
@@ -0,0 +1,305 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// JournalSuffix 是断点文件的扩展名：<文件名>.journal
+const JournalSuffix = ".journal"
+
+// JournalPath 返回CFR文件在dir下的断点文件路径
+// 断点文件在解析期间存在，整棵树遍历成功、临时文件重命名为输出路径后删除
+func JournalPath(dir, cfrFile string) string {
+	_, cfrFileName := filepath.Split(cfrFile)
+	cfrFileName = strings.TrimSuffix(cfrFileName, filepath.Ext(cfrFileName))
+	return filepath.Join(dir, cfrFileName+JournalSuffix)
+}
+
+// Checkpointer 是支持断点续传的Sink，FileSinks创建的Sink实现了该接口
+type Checkpointer interface {
+	// Resume 返回上次中断前已完成的决策节点及当时的统计，没有断点时completed为nil
+	// stats.Params是输出对应的解析参数，没有断点时也会设置
+	Resume() (completed map[string]bool, stats Stats)
+	// Checkpoint 在决策节点处理完成后调用：刷新输出，并把节点、输出的位置和当前统计记录到断点文件
+	Checkpoint(node string, stats Stats) error
+	// Finish 在整棵树遍历成功、Sink关闭之后调用，提交输出并删除断点文件，输出标记为完成
+	Finish() error
+}
+
+// journalHeader 是断点文件的第一行：产生这些输出的解析参数（Parser.Params()和输出格式）
+// 参数不同的断点不能沿用，否则会在旧参数的输出后追加新参数的输出
+type journalHeader struct {
+	Params map[string]string `json:"params"`
+}
+
+// journalEntry 是断点文件中的一行：一个完成的决策节点，完成时各输出文件的长度和累计统计
+type journalEntry struct {
+	Node            string  `json:"node"`
+	Offsets         []int64 `json:"offsets"`
+	Nodes           int     `json:"nodes"`
+	Records         int     `json:"records"`
+	Actions         int     `json:"actions"`
+	FilteredActions int     `json:"filtered_actions"`
+	Summaries       int     `json:"summaries"`
+}
+
+// flusher 是可以把缓冲的输出写入文件的Sink
+type flusher interface {
+	flush() error
+}
+
+// fileSink 把记录、节点汇总和SQL写入文件，并在断点文件中记录完成的节点
+type fileSink struct {
+	SummarySink
+	files       []*os.File
+	flushers    []flusher
+	journal     *os.File
+	journalPath string
+	// params 是写入断点文件头的解析参数
+	params map[string]string
+	// outputs 是输出路径，files写入的是对应的临时文件
+	outputs []string
+
+	// 从断点继续时上次已完成的节点和统计
+	completed map[string]bool
+	stats     Stats
+}
+
+// openFileSink 打开CFR文件输出的临时文件：断点文件的参数与params、format相同且有有效记录时，
+// 截断到最后一个完成节点的位置并继续写入；参数不同时删除断点文件和临时文件后新建
+func openFileSink(journalPath, cfrFile, format string, params map[string]string, recordPath, summaryPath, sqlPath string) (*fileSink, error) {
+	s := &fileSink{
+		journalPath: journalPath,
+		params:      journalParams(params, format),
+		outputs:     []string{recordPath, summaryPath, sqlPath},
+	}
+	var paths []string
+	for _, output := range s.outputs {
+		paths = append(paths, output+TempSuffix)
+	}
+	entries, err := readJournal(journalPath, paths, s.params)
+	if errors.Is(err, errParamsChanged) {
+		log.Printf("  ⚠️  %s 记录的解析参数与本次不同，丢弃断点和临时文件，重新解析整个文件", filepath.Base(journalPath))
+		err = removeFiles(append([]string{journalPath}, paths...))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	closeFiles := func() {
+		for _, file := range s.files {
+			file.Close()
+		}
+	}
+
+	if len(entries) == 0 {
+		for _, path := range paths {
+			file, err := os.Create(path)
+			if err != nil {
+				closeFiles()
+				return nil, fmt.Errorf("创建输出文件%s失败: %v", filepath.Base(path), err)
+			}
+			s.files = append(s.files, file)
+		}
+		records := NewRecordSink(s.files[0], format).(*recordSink)
+		summaries := NewSummarySink(s.files[1], format).(*recordSink)
+		sql := NewSQLSink(s.files[2], cfrFile).(*sqlSink)
+		s.SummarySink = MultiSink(records, summaries, sql)
+		s.flushers = []flusher{records, summaries, sql}
+	} else {
+		last := entries[len(entries)-1]
+		for i, path := range paths {
+			file, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err == nil {
+				err = file.Truncate(last.Offsets[i])
+				if err == nil {
+					_, err = file.Seek(last.Offsets[i], io.SeekStart)
+				}
+				if err != nil {
+					file.Close()
+				}
+			}
+			if err != nil {
+				closeFiles()
+				return nil, fmt.Errorf("打开输出文件%s失败: %v", filepath.Base(path), err)
+			}
+			s.files = append(s.files, file)
+		}
+		records := resumeRecordSink(s.files[0], format, last.Offsets[0])
+		summaries := resumeRecordSink(s.files[1], format, last.Offsets[1])
+		summaries.summaries = true
+		sql := resumeSQLSink(s.files[2], cfrFile)
+		s.SummarySink = MultiSink(records, summaries, sql)
+		s.flushers = []flusher{records, summaries, sql}
+
+		s.completed = make(map[string]bool)
+		for _, entry := range entries {
+			s.completed[entry.Node] = true
+		}
+		s.stats = Stats{
+			Nodes:           last.Nodes,
+			Records:         last.Records,
+			Actions:         last.Actions,
+			FilteredActions: last.FilteredActions,
+			Summaries:       last.Summaries,
+		}
+	}
+	s.stats.Params = s.params
+
+	// 重写断点文件，只保留参数和有效的记录（中断时最后一行可能不完整）
+	if s.journal, err = os.Create(journalPath); err != nil {
+		closeFiles()
+		return nil, fmt.Errorf("创建断点文件失败: %v", err)
+	}
+	lines := []any{journalHeader{Params: s.params}}
+	for _, entry := range entries {
+		lines = append(lines, entry)
+	}
+	for _, line := range lines {
+		if err := s.writeLine(line); err != nil {
+			s.journal.Close()
+			closeFiles()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// errParamsChanged 表示断点文件记录的解析参数与本次不同（或没有记录参数）
+var errParamsChanged = errors.New("断点文件的解析参数已改变")
+
+// journalParams 返回写入断点文件头的参数：Parser.Params()加上输出格式
+func journalParams(params map[string]string, format string) map[string]string {
+	merged := map[string]string{"format": format}
+	for k, v := range params {
+		merged[k] = v
+	}
+	return merged
+}
+
+// readJournal 读取断点文件中的有效记录，断点文件不存在时返回nil；文件头的参数与params不同时返回errParamsChanged
+// 记录按完成顺序追加，输出只会变长；遇到无法解析或位置超出输出文件长度的记录时，只保留之前的记录
+func readJournal(journalPath string, paths []string, params map[string]string) ([]journalEntry, error) {
+	file, err := os.Open(journalPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取断点文件失败: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	var header journalHeader
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &header) != nil || !maps.Equal(header.Params, params) {
+		return nil, errParamsChanged
+	}
+
+	sizes := make([]int64, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("  ⚠️  断点对应的输出文件%s不可用: %v，重新解析整个文件", filepath.Base(path), err)
+			return nil, nil
+		}
+		sizes[i] = info.Size()
+	}
+
+	var entries []journalEntry
+scan:
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || len(entry.Offsets) != len(paths) {
+			break
+		}
+		for i, offset := range entry.Offsets {
+			if offset < 0 || offset > sizes[i] {
+				break scan
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// removeFiles 删除文件，不存在的文件忽略
+func removeFiles(paths []string) error {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("删除%s失败: %v", filepath.Base(path), err)
+		}
+	}
+	return nil
+}
+
+// writeLine 把一行（文件头或记录）追加到断点文件
+func (s *fileSink) writeLine(line any) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("写入断点文件失败: %v", err)
+	}
+	if _, err := s.journal.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("写入断点文件失败: %v", err)
+	}
+	return nil
+}
+
+// Resume 返回上次中断前已完成的决策节点及当时的统计
+func (s *fileSink) Resume() (map[string]bool, Stats) {
+	return s.completed, s.stats
+}
+
+// Checkpoint 刷新全部输出，把节点和各输出文件的当前长度记录到断点文件
+func (s *fileSink) Checkpoint(node string, stats Stats) error {
+	entry := journalEntry{
+		Node:            node,
+		Nodes:           stats.Nodes,
+		Records:         stats.Records,
+		Actions:         stats.Actions,
+		FilteredActions: stats.FilteredActions,
+		Summaries:       stats.Summaries,
+	}
+	for i, f := range s.flushers {
+		if err := f.flush(); err != nil {
+			return err
+		}
+		offset, err := s.files[i].Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("获取输出位置失败: %v", err)
+		}
+		entry.Offsets = append(entry.Offsets, offset)
+	}
+	return s.writeLine(entry)
+}
+
+// Close 关闭全部输出和断点文件，断点文件保留到Finish
+func (s *fileSink) Close() error {
+	err := s.SummarySink.Close()
+	if cerr := s.journal.Close(); cerr != nil {
+		err = errors.Join(err, fmt.Errorf("关闭断点文件失败: %v", cerr))
+	}
+	return err
+}
+
+// Finish 把临时文件重命名为输出路径，然后删除断点文件
+func (s *fileSink) Finish() error {
+	for _, output := range s.outputs {
+		if err := os.Rename(output+TempSuffix, output); err != nil {
+			return fmt.Errorf("重命名输出文件失败: %v", err)
+		}
+	}
+	if err := os.Remove(s.journalPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除断点文件失败: %v", err)
+	}
+	return nil
+}
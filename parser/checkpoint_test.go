@@ -0,0 +1,197 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// interruptSink 在完成after个决策节点后取消解析，模拟中断
+type interruptSink struct {
+	*fileSink
+	after  int
+	cancel context.CancelFunc
+}
+
+func (s *interruptSink) Checkpoint(node string, stats Stats) error {
+	err := s.fileSink.Checkpoint(node, stats)
+	if s.after--; s.after == 0 {
+		s.cancel()
+	}
+	return err
+}
+
+// interruptAfter 返回在完成after个决策节点后调用cancel的FileSinks
+func interruptAfter(dir, format string, after int, cancel context.CancelFunc) SinkFactory {
+	sinks := FileSinks(dir, format)
+	return func(cfrFile string, params map[string]string) (Sink, error) {
+		sink, err := sinks(cfrFile, params)
+		if err != nil {
+			return nil, err
+		}
+		return &interruptSink{fileSink: sink.(*fileSink), after: after, cancel: cancel}, nil
+	}
+}
+
+// parseInterrupted 解析fixture并在完成after个决策节点后中断，留下临时文件和断点文件
+func parseInterrupted(t *testing.T, dir string, config Config, after int) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config.Sinks = interruptAfter(dir, FormatJSON, after, cancel)
+	if _, err := openParser(t, config).ParseFile(ctx, fixturePath); !errors.Is(err, context.Canceled) {
+		t.Fatalf("解析应被中断，实际 %v", err)
+	}
+	if _, err := os.Stat(JournalPath(dir, fixturePath)); err != nil {
+		t.Fatalf("中断后应保留断点文件: %v", err)
+	}
+	// 中断的解析只留下临时文件，不会出现不完整的输出文件
+	recordPath, _, _ := OutputPaths(dir, fixturePath, FormatJSON)
+	if _, err := os.Stat(recordPath + TempSuffix); err != nil {
+		t.Fatalf("中断后应保留临时文件: %v", err)
+	}
+	if _, err := os.Stat(recordPath); !os.IsNotExist(err) {
+		t.Errorf("中断后不应出现输出文件 %s", filepath.Base(recordPath))
+	}
+}
+
+// parseComplete 完整解析fixture
+func parseComplete(t *testing.T, dir string, config Config) Stats {
+	t.Helper()
+	config.Sinks = FileSinks(dir, FormatJSON)
+	stats, err := openParser(t, config).ParseFile(context.Background(), fixturePath)
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	return stats
+}
+
+// assertSameOutputs 检查两个目录中的记录、节点汇总和SQL输出逐字节相同
+func assertSameOutputs(t *testing.T, dir, want string) {
+	t.Helper()
+	got1, got2, got3 := OutputPaths(dir, fixturePath, FormatJSON)
+	want1, want2, want3 := OutputPaths(want, fixturePath, FormatJSON)
+	for _, pair := range [][2]string{{got1, want1}, {got2, want2}, {got3, want3}} {
+		got, err := os.ReadFile(pair[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := os.ReadFile(pair[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expected) {
+			t.Errorf("%s 与不中断的解析结果不同（%d 字节，应为 %d 字节）", filepath.Base(pair[0]), len(got), len(expected))
+		}
+	}
+}
+
+func TestResumeMatchesUninterrupted(t *testing.T) {
+	want := t.TempDir()
+	wantStats := parseComplete(t, want, Config{})
+
+	dir := t.TempDir()
+	parseInterrupted(t, dir, Config{}, 2)
+	stats := parseComplete(t, dir, Config{})
+
+	assertSameOutputs(t, dir, want)
+	if stats.Records != wantStats.Records || stats.Summaries != wantStats.Summaries || stats.Nodes != wantStats.Nodes {
+		t.Errorf("续传后的统计 %+v 与不中断的 %+v 不同", stats, wantStats)
+	}
+	if _, err := os.Stat(JournalPath(dir, fixturePath)); !os.IsNotExist(err) {
+		t.Error("解析完成后应删除断点文件")
+	}
+}
+
+func TestResumeDiscardsJournalWithChangedParams(t *testing.T) {
+	want := t.TempDir()
+	parseComplete(t, want, Config{Actor: "IP"})
+
+	// 按默认参数中断，再用 -actor IP 重新解析：不能沿用旧参数的输出
+	dir := t.TempDir()
+	parseInterrupted(t, dir, Config{}, 2)
+	stats := parseComplete(t, dir, Config{Actor: "IP"})
+
+	assertSameOutputs(t, dir, want)
+	if stats.Params["actor"] != "IP" || stats.Params["format"] != FormatJSON {
+		t.Errorf("重新解析的输出参数 = %v", stats.Params)
+	}
+}
+
+func TestResumeDiscardsJournalWithChangedFormat(t *testing.T) {
+	dir := t.TempDir()
+	parseInterrupted(t, dir, Config{}, 2)
+
+	sink, err := FileSinks(dir, FormatNDJSON)(fixturePath, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if completed, _ := sink.(Checkpointer).Resume(); completed != nil {
+		t.Errorf("输出格式改变后不应从断点继续，已完成 %d 个节点", len(completed))
+	}
+}
+
+// sortedLines 读取NDJSON输出的每一行并排序：重试的节点追加在最后，只比较内容
+func sortedLines(t *testing.T, path string) []string {
+	t.Helper()
+	lines := readLines(t, path)
+	sort.Strings(lines)
+	return lines
+}
+
+func TestResumeRetriesSkippedNodes(t *testing.T) {
+	want := t.TempDir()
+	wantStats, err := openParser(t, Config{Sinks: FileSinks(want, FormatNDJSON)}).ParseFile(context.Background(), fixturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		fail string // 第一次解析时返回ERROR的命令
+	}{
+		{"策略查询失败", "show_strategy r:0"},
+		{"子节点查询失败，跳过整个子树", "show_children r:0:c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			recordPath, summaryPath, _ := OutputPaths(dir, fixturePath, FormatNDJSON)
+
+			t.Setenv("FAKEPIO_FAIL", tt.fail)
+			stats, err := openParser(t, Config{Sinks: FileSinks(dir, FormatNDJSON)}).ParseFile(context.Background(), fixturePath)
+			if !errors.Is(err, ErrIncomplete) || stats.SkippedNodes != 1 {
+				t.Fatalf("跳过节点后应返回ErrIncomplete，实际 %v（跳过 %d 个节点）", err, stats.SkippedNodes)
+			}
+			// 跳过了节点的输出不标记为完成：只有临时文件和断点文件
+			if _, err := os.Stat(recordPath); !os.IsNotExist(err) {
+				t.Errorf("跳过节点后不应出现输出文件 %s", filepath.Base(recordPath))
+			}
+			if _, err := os.Stat(JournalPath(dir, fixturePath)); err != nil {
+				t.Fatalf("跳过节点后应保留断点文件: %v", err)
+			}
+
+			// 再次解析时只重新查询被跳过的节点
+			t.Setenv("FAKEPIO_FAIL", "")
+			stats, err = openParser(t, Config{Sinks: FileSinks(dir, FormatNDJSON)}).ParseFile(context.Background(), fixturePath)
+			if err != nil {
+				t.Fatalf("重新解析: %v", err)
+			}
+			if stats.Records != wantStats.Records || stats.Summaries != wantStats.Summaries || stats.Nodes != wantStats.Nodes {
+				t.Errorf("重试后的统计 %+v 与一次完成的 %+v 不同", stats, wantStats)
+			}
+			wantRecords, wantSummaries, _ := OutputPaths(want, fixturePath, FormatNDJSON)
+			for _, pair := range [][2]string{{recordPath, wantRecords}, {summaryPath, wantSummaries}} {
+				if got, expected := sortedLines(t, pair[0]), sortedLines(t, pair[1]); !slices.Equal(got, expected) {
+					t.Errorf("%s 与一次完成的解析结果不同（%d 行，应为 %d 行）", filepath.Base(pair[0]), len(got), len(expected))
+				}
+			}
+		})
+	}
+}
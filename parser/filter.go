@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// compileFilters 校验并规范化遍历过滤配置，返回编译后的NodePattern（未设置时为nil）
+func compileFilters(config *Config) (*regexp.Regexp, error) {
+	if config.MaxDepth < 0 {
+		return nil, fmt.Errorf("无效的最大深度: %d", config.MaxDepth)
+	}
+	if config.MaxBetLevel < 0 {
+		return nil, fmt.Errorf("无效的最大下注次数: %d", config.MaxBetLevel)
+	}
+
+	config.Actor = strings.ToUpper(strings.TrimSpace(config.Actor))
+	if config.Actor != "" && config.Actor != "IP" && config.Actor != "OOP" {
+		return nil, fmt.Errorf("无效的行动方: %s（应为IP或OOP）", config.Actor)
+	}
+
+	if config.NodePattern == "" {
+		return nil, nil
+	}
+	pattern, err := regexp.Compile(config.NodePattern)
+	if err != nil {
+		return nil, fmt.Errorf("无效的节点路径匹配: %v", err)
+	}
+	return pattern, nil
+}
+
+// withinLimits 判断是否进入节点：节点的决策层数不超过MaxDepth，主动下注次数不超过MaxBetLevel
+// 只按节点ID判断，超出限制的节点及其子树不会向PioSolver查询
+func (f *fileParse) withinLimits(node string) bool {
+	if f.config.MaxDepth > 0 && f.nodeDepth(node) > f.config.MaxDepth {
+		return false
+	}
+	if f.config.MaxBetLevel > 0 && calculateBetLevel(node) > f.config.MaxBetLevel {
+		return false
+	}
+	return true
+}
+
+// nodeDepth 返回节点相对RootNode的决策层数：RootNode为第1层，每个动作加1，发出的牌不计
+func (f *fileParse) nodeDepth(node string) int {
+	depth := 1
+	for _, segment := range strings.Split(strings.TrimPrefix(node, f.config.RootNode), ":") {
+		if segment != "" && !isCard(segment) {
+			depth++
+		}
+	}
+	return depth
+}
+
+// includes 判断是否输出决策节点的记录和汇总：节点ID符合NodePattern，行动方（IP或OOP）符合Actor
+// 不输出的节点仍会遍历其子节点
+func (f *fileParse) includes(node, player string) bool {
+	if f.config.Actor != "" && player != f.config.Actor {
+		return false
+	}
+	if f.pattern != nil && !f.pattern.MatchString(node) {
+		return false
+	}
+	return true
+}
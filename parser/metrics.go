@@ -0,0 +1,339 @@
+package parser
+
+import (
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 新增：转换节点路径为标准格式
+func convertNodePath(path string) string {
+	// 使用正则表达式匹配数字
+	re := regexp.MustCompile(`[rb](\d+)`)
+	// 将带数字的动作替换为单个字母
+	return re.ReplaceAllString(path, "${1}")
+}
+
+// 新增：计算下注次数（主动下注行为）
+func calculateBetLevel(nodePath string) int {
+	// 移除固定前缀 "r:0"
+	if !strings.HasPrefix(nodePath, "r:0") {
+		return 0
+	}
+
+	// 去掉 "r:0" 前缀
+	remaining := strings.TrimPrefix(nodePath, "r:0")
+	if remaining == "" {
+		return 0 // 只有 "r:0"，没有任何行动
+	}
+
+	// 移除开头的冒号
+	if strings.HasPrefix(remaining, ":") {
+		remaining = remaining[1:]
+	}
+
+	if remaining == "" {
+		return 0
+	}
+
+	// 按冒号分割行动
+	actions := strings.Split(remaining, ":")
+	betCount := 0
+
+	// 统计主动下注次数
+	for _, action := range actions {
+		action = strings.TrimSpace(action)
+		if action == "" {
+			continue
+		}
+
+		// 检查是否为下注行为：
+		// - 以 'b' 开头的是bet下注
+		// - 以 'r' 开头的是raise加注（也算作主动下注）
+		// - 'c' 是check，不算主动下注
+		// - 'f' 是fold，不算主动下注
+		if strings.HasPrefix(action, "b") || strings.HasPrefix(action, "r") {
+			betCount++
+			log.Printf("检测到主动下注行为: %s，当前bet_level: %d", action, betCount)
+		}
+	}
+
+	log.Printf("节点路径 %s 的bet_level: %d", nodePath, betCount)
+	return betCount
+}
+
+// 修改：计算bet_pct、spr和stack_depth
+func calculateBetMetrics(potInfo string, nodeId string, effectiveStack float64) (float64, float64, float64) {
+	log.Printf("解析底池信息: %s", potInfo)
+
+	// 默认值
+	var oop, ip, dead float64 = 0, 0, 0
+
+	// 解析potInfo：三个整数，以空格分隔，分别对应oop, ip, dead
+	if potInfo != "" {
+		potInfo = strings.TrimSpace(potInfo)
+		fields := strings.Fields(potInfo)
+
+		if len(fields) >= 3 {
+			// 解析oop（第一个值）
+			if val, err := strconv.ParseFloat(fields[0], 64); err == nil {
+				oop = val
+			}
+			// 解析ip（第二个值）
+			if val, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				ip = val
+			}
+			// 解析dead（第三个值）
+			if val, err := strconv.ParseFloat(fields[2], 64); err == nil {
+				dead = val
+			}
+		} else {
+			log.Printf("警告：底池信息格式不正确，期望3个数值，实际得到: %d 个", len(fields))
+		}
+	}
+
+	// 计算总底池大小
+	totalPot := oop + ip + dead
+
+	// 计算bet_pct（最近一次下注占底池比例）
+	// 从nodeId中提取最后一个冒号后的值来判断最近的行动
+	betPct := 0.0
+	if nodeId != "" {
+		// 找到最后一个冒号的位置
+		lastColonIndex := strings.LastIndex(nodeId, ":")
+		if lastColonIndex != -1 && lastColonIndex < len(nodeId)-1 {
+			lastAction := nodeId[lastColonIndex+1:]
+			log.Printf("提取最后行动: %s", lastAction)
+
+			if lastAction == "c" {
+				// check行动，下注为0
+				betPct = 0.0
+				log.Printf("检测到check行动，bet_pct = 0.0")
+			} else if strings.HasPrefix(lastAction, "b") {
+				// 下注行动，提取下注金额
+				betAmountStr := strings.TrimPrefix(lastAction, "b")
+				if betAmount, err := strconv.ParseFloat(betAmountStr, 64); err == nil {
+					if totalPot > 0 {
+						betPct = betAmount / totalPot
+						log.Printf("检测到下注行动: b%s，下注金额: %.2f，底池: %.2f，bet_pct: %.3f",
+							betAmountStr, betAmount, totalPot, betPct)
+					}
+				} else {
+					log.Printf("警告：无法解析下注金额: %s", betAmountStr)
+				}
+			} else if strings.HasPrefix(lastAction, "r") {
+				// raise行动，提取加注金额
+				raiseAmountStr := strings.TrimPrefix(lastAction, "r")
+				if raiseAmount, err := strconv.ParseFloat(raiseAmountStr, 64); err == nil {
+					if totalPot > 0 {
+						betPct = raiseAmount / totalPot
+						log.Printf("检测到加注行动: r%s，加注金额: %.2f，底池: %.2f，bet_pct: %.3f",
+							raiseAmountStr, raiseAmount, totalPot, betPct)
+					}
+				} else {
+					log.Printf("警告：无法解析加注金额: %s", raiseAmountStr)
+				}
+			} else {
+				log.Printf("未识别的行动类型: %s", lastAction)
+			}
+		} else {
+			log.Printf("nodeId中未找到有效的行动信息: %s", nodeId)
+		}
+	}
+
+	// 计算spr（栈底比）
+	// 使用传入的有效筹码，计算剩余筹码与底池的比例
+	remainingStack := effectiveStack - math.Max(oop, ip)
+	spr := 0.0
+	if totalPot > 0 && remainingStack > 0 {
+		spr = remainingStack / totalPot
+	}
+
+	// 计算筹码深度（后手筹码，两人中筹码量较少的一方）
+	stackDepth := math.Min(effectiveStack-oop, effectiveStack-ip)
+
+	log.Printf("计算结果: oop=%.2f, ip=%.2f, dead=%.2f, totalPot=%.2f, bet_pct=%.3f, spr=%.3f, stack_depth=%.2f",
+		oop, ip, dead, totalPot, betPct, spr, stackDepth)
+
+	return betPct, spr, stackDepth
+}
+
+// 新增：根据node_prefix判断策略执行者（IP或OOP）
+func calculateIpOrOop(nodePrefix string) string {
+	// 示例：r:0:c:20:70:170:370
+	// r:0 是固定前缀，然后 c(oop) -> 20(ip) -> 70(oop) -> 170(ip) -> 370(oop)
+	// 接下来应该是IP执行策略
+
+	// 移除固定前缀 "r:0"
+	if !strings.HasPrefix(nodePrefix, "r:0") {
+		log.Printf("警告：节点格式不符合预期，返回默认值IP: %s", nodePrefix)
+		return "IP"
+	}
+
+	// 去掉 "r:0" 前缀
+	remaining := strings.TrimPrefix(nodePrefix, "r:0")
+	if remaining == "" {
+		// 如果只有 "r:0"，那么第一个行动者是OOP
+		return "OOP"
+	}
+
+	// 移除开头的冒号
+	if strings.HasPrefix(remaining, ":") {
+		remaining = remaining[1:]
+	}
+
+	if remaining == "" {
+		return "OOP"
+	}
+
+	// 按冒号分割剩余部分
+	parts := strings.Split(remaining, ":")
+
+	// 转牌、河牌节点路径中包含发出的牌（如 r:0:c:c:7h:c），每条街都由OOP先行动，只计算最后一张牌之后的行动
+	for i := len(parts) - 1; i >= 0; i-- {
+		if isCard(parts[i]) {
+			parts = parts[i+1:]
+			break
+		}
+	}
+	if len(parts) == 0 {
+		return "OOP"
+	}
+
+	// 计算行动次数：
+	// 第1次行动：OOP (c)
+	// 第2次行动：IP (20)
+	// 第3次行动：OOP (70)
+	// 第4次行动：IP (170)
+	// 第5次行动：OOP (370)
+	// 第6次行动：IP (下一个策略执行者)
+
+	actionCount := len(parts)
+	log.Printf("节点 %s 解析：去除r:0后=%s，行动次数=%d", nodePrefix, remaining, actionCount)
+
+	// 下一个策略执行者：
+	// 如果已有奇数次行动，下一个是IP
+	// 如果已有偶数次行动，下一个是OOP
+	if actionCount%2 == 1 {
+		return "IP"
+	} else {
+		return "OOP"
+	}
+}
+
+// 新增：标准化公牌顺序
+func standardizeBoard(board string) string {
+	// 移除多余的空格
+	board = strings.TrimSpace(board)
+
+	// 分割成单张牌
+	cards := strings.Fields(board)
+	if len(cards) != 3 {
+		return board // 如果不是3张牌，返回原始字符串
+	}
+
+	// 对牌进行排序（按照值和花色）
+	sort.Slice(cards, func(i, j int) bool {
+		// 获取牌值和花色
+		rank1, suit1 := cards[i][0], cards[i][1]
+		rank2, suit2 := cards[j][0], cards[j][1]
+
+		// 转换 T、J、Q、K、A 为对应的数值
+		rankValue := func(r byte) int {
+			switch r {
+			case 'T':
+				return 10
+			case 'J':
+				return 11
+			case 'Q':
+				return 12
+			case 'K':
+				return 13
+			case 'A':
+				return 14
+			default:
+				if r >= '2' && r <= '9' {
+					return int(r - '0')
+				}
+				return 0
+			}
+		}
+
+		// 首先按牌值比较
+		rank1Val := rankValue(rank1)
+		rank2Val := rankValue(rank2)
+		if rank1Val != rank2Val {
+			return rank1Val > rank2Val // 大的牌在前面
+		}
+
+		// 牌值相同时按花色排序 (s > h > d > c)
+		suitValue := func(s byte) int {
+			switch s {
+			case 's':
+				return 4
+			case 'h':
+				return 3
+			case 'd':
+				return 2
+			case 'c':
+				return 1
+			default:
+				return 0
+			}
+		}
+		return suitValue(suit1) > suitValue(suit2)
+	})
+
+	// 重新组合成字符串
+	return strings.Join(cards, " ")
+}
+
+// 街的名称
+const (
+	StreetFlop  = "flop"
+	StreetTurn  = "turn"
+	StreetRiver = "river"
+)
+
+// streetOf 根据公牌张数返回所在的街
+func streetOf(board string) string {
+	switch len(strings.Fields(board)) {
+	case 4:
+		return StreetTurn
+	case 5:
+		return StreetRiver
+	default:
+		return StreetFlop
+	}
+}
+
+// streetRank 返回街的顺序，flop为0，未知的街为-1
+func streetRank(street string) int {
+	switch street {
+	case StreetFlop:
+		return 0
+	case StreetTurn:
+		return 1
+	case StreetRiver:
+		return 2
+	default:
+		return -1
+	}
+}
+
+// isCard 判断节点路径中的一段是否是发出的牌，如 7h
+func isCard(s string) bool {
+	return len(s) == 2 && strings.IndexByte("23456789TJQKA", s[0]) >= 0 && strings.IndexByte("cdhs", s[1]) >= 0
+}
+
+// flopBoard 返回公牌的前三张（翻牌）
+func flopBoard(board string) string {
+	cards := strings.Fields(board)
+	if len(cards) > 3 {
+		cards = cards[:3]
+	}
+	return strings.Join(cards, " ")
+}
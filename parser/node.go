@@ -0,0 +1,432 @@
+package parser
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"piodatasolver/internal/upi"
+	"piodatasolver/internal/util"
+	"piodatasolver/model"
+)
+
+// parseNode 解析节点及其子树并写入输出
+// 单个节点的查询失败只跳过该节点（无法获取子节点时连同子树），记入Stats.SkippedNodes；
+// PioSolver进程退出且重启重试用尽、写出失败或ctx被取消时返回错误
+func (f *fileParse) parseNode(node string) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	// 超出MaxDepth、MaxBetLevel的节点不再深入
+	if !f.withinLimits(node) {
+		return nil
+	}
+	client := f.client
+
+	// 第一批：show_node 获取当前节点信息，公牌，行动方（IP/OOP）；show_children 获取子节点
+	results, err := client.QueryBatch([]string{
+		fmt.Sprintf("show_node %s", node),
+		fmt.Sprintf("show_children %s", node),
+	}, 10*time.Second)
+	if err != nil {
+		if isFatalQueryError(client, err) {
+			return fmt.Errorf("节点 %s: %v", node, err)
+		}
+		log.Printf("执行指令show_node/show_children失败: %v，跳过此节点及其子树", err)
+		f.stats.SkippedNodes++
+		return nil
+	}
+
+	info, err := results[0].NodeInfo()
+	if err != nil {
+		log.Printf("执行指令show_node失败: %v，跳过此节点及其子树", err)
+		f.stats.SkippedNodes++
+		return nil
+	}
+
+	board := info.Board
+
+	// 如果是终端节点，则不需要进一步处理
+	if info.ChildCount == 0 {
+		log.Printf("节点 %s 没有子节点，跳过进一步处理", node)
+		return nil
+	}
+
+	//show_children 当前节点下的子节点，每一个子节点代表一个行动，与后续的show_strategy、每一行的结果对应
+	children, err := results[1].Children()
+	if err != nil {
+		log.Printf("执行指令show_children失败: %v，跳过此节点及其子树", err)
+		f.stats.SkippedNodes++
+		return nil
+	}
+
+	// 如果没有解析到任何子节点，则返回
+	if len(children) == 0 {
+		log.Printf("节点 %s 没有解析到有效子节点，跳过进一步处理", node)
+		return nil
+	}
+
+	// 发牌节点的子节点是发出的转牌或河牌，没有策略，选出要展开的牌后继续解析
+	if info.NodeType == "SPLIT_NODE" {
+		return f.parseChanceNode(node, board, children)
+	}
+
+	// 不输出的决策节点（NodePattern、Actor过滤）和从断点继续时已完成的节点只遍历子节点，不查询策略、EV和胜率
+	// 被跳过的节点不记录断点，下次从断点继续时重新查询
+	if f.includes(node, info.Player()) && !f.completed[node] {
+		skipped, err := f.parseDecision(node, info, children)
+		if err != nil {
+			return err
+		}
+		if skipped {
+			f.stats.SkippedNodes++
+		} else if f.checkpoint != nil {
+			if err := f.checkpoint.Checkpoint(node, f.stats); err != nil {
+				return fmt.Errorf("节点 %s: 记录断点失败: %v", node, err)
+			}
+		}
+	}
+
+	//遍历子节点，递归调用解析；SPLIT_NODE（发牌节点）只在配置了解析下一条街时进入
+	for _, child := range children {
+		if child.NodeType == "SPLIT_NODE" && !f.descends(child.Board) {
+			continue
+		}
+		// 递归处理子节点
+		if err := f.parseNode(child.NodeID); err != nil {
+			return err
+		}
+	}
+
+	// 如果是根节点(深度为1)，关闭JSON数组
+	if strings.Count(node, ":") <= 1 {
+		// 打印总结信息
+		log.Printf("处理完成根节点 %s，数据已保存到文件中", node)
+	}
+	return nil
+}
+
+// parseDecision 查询决策节点的策略、EV、胜率和双方范围，写入节点的记录和汇总
+// 查询失败（包括任一条命令返回ERROR）时不写出该节点，返回skipped为true；PioSolver进程退出且重启重试用尽或写出失败时返回错误
+func (f *fileParse) parseDecision(node string, info upi.NodeInfo, children []model.ChildNode) (skipped bool, err error) {
+	client := f.client
+	actor := info.NodeType
+	board := info.Board
+	pot := info.Pot
+
+	// 解析子节点信息,生成对应的action
+	var actions []model.Action
+	for _, child := range children {
+		// 打印提取的子节点信息
+		log.Printf("解析到子节点 %d: NodeID=%s, NodeType=%s, Board=%s, PotInfo=%s, Flag=%s",
+			child.Index, child.NodeID, child.NodeType, child.Board, child.PotInfo, child.Flag)
+		label, _ := util.BuildActionLabel(pot, child)
+		actions = append(actions, model.Action{
+			Label:       label,
+			ChildNodeID: child.NodeID,
+		})
+	}
+
+	// actor如果是IP_DEC，则actorCmd为IP
+	// actor如果是OOP_DEC，则actorCmd为OOP
+	actorCmd := info.Player()
+
+	// 第二批：show_strategy、每个动作的calc_ev、calc_eq_node、双方的show_range一次发送，按顺序读取应答；
+	// 输出节点汇总时再加上对手的calc_ev和calc_eq_node
+	var commands []string
+	if info.IsDecision() {
+		commands = append(commands, fmt.Sprintf("show_strategy %s", node))
+	}
+	if actorCmd != "" {
+		for _, action := range actions {
+			commands = append(commands, fmt.Sprintf("calc_ev %s %s", actorCmd, action.ChildNodeID))
+		}
+		commands = append(commands,
+			fmt.Sprintf("calc_eq_node %s %s", actorCmd, node),
+			fmt.Sprintf("show_range OOP %s", node),
+			fmt.Sprintf("show_range IP %s", node),
+		)
+		if f.summaries {
+			commands = append(commands,
+				fmt.Sprintf("calc_ev %s %s", opponentOf(actorCmd), node),
+				fmt.Sprintf("calc_eq_node %s %s", opponentOf(actorCmd), node),
+			)
+		}
+	}
+	results, err := client.QueryBatch(commands, 20*time.Second)
+	if err != nil {
+		if isFatalQueryError(client, err) {
+			return false, fmt.Errorf("节点 %s: %v", node, err)
+		}
+		log.Printf("执行指令show_strategy/calc_ev/calc_eq_node失败: %v，跳过此节点", err)
+		return true, nil
+	}
+	// 任一条命令返回ERROR时节点的输出不完整，整个节点跳过，留待从断点继续时重新查询
+	for _, result := range results {
+		if result.Err != nil {
+			log.Printf("执行指令%s失败: %v，跳过此节点", result.Command, result.Err)
+			return true, nil
+		}
+	}
+
+	//show_strategy 当前节点1326手牌各行动对应的策略频率，行动类别参考show_children的结果
+	var strategy [][]float64
+	if info.IsDecision() {
+		strategy, err = results[0].Strategy()
+		results = results[1:]
+		if err != nil {
+			log.Printf("执行指令show_strategy失败: %v，尝试继续处理", err)
+			// 不返回，继续处理其他命令的结果
+		} else if len(strategy) != len(actions) {
+			log.Printf("警告: 动作数量 %d 与策略行数 %d 不一致，跳过策略处理", len(actions), len(strategy))
+			strategy = nil
+		}
+	}
+
+	// 获取所有手牌
+	handCards := f.hands.Order()
+
+	// 计算当前节点的bet_pct、spr和stack_depth
+	betPct, spr, stackDepth := calculateBetMetrics(pot, node, f.effectiveStack)
+
+	// 计算策略执行者（IP或OOP）
+	ipOrOop := calculateIpOrOop(node)
+
+	// 根据公牌张数确定所在的街
+	street := streetOf(board)
+
+	// 计算主动下注次数（在convertNodePath之前计算，因为convertNodePath会移除b和r前缀）
+	betLevel := calculateBetLevel(node)
+
+	// 标准化公牌并计算board_id（转牌、河牌节点使用所在翻牌的board_id）
+	standardizedBoard := standardizeBoard(flopBoard(board))
+	boardId, ok := f.boards.Index(standardizedBoard)
+	if !ok {
+		log.Printf("警告：无法找到公牌 %s (标准化后: %s) 的索引", board, standardizedBoard)
+		boardId = -1 // 设置为-1表示未找到
+	}
+
+	// 创建一个映射，存储每个手牌的Record
+	handRecords := make(map[string]*model.Record)
+
+	// 先为每个手牌创建一个Record
+	for _, hand := range handCards {
+		// 计算手牌的combo_id
+		comboId, ok := f.hands.Index(hand)
+		if !ok {
+			log.Printf("警告：无法找到手牌 %s 的索引", hand)
+			comboId = -1 // 设置为-1表示未找到
+		}
+
+		handRecords[hand] = &model.Record{
+			Node:       node,
+			Actor:      actor,
+			Board:      board,
+			Street:     street,
+			BoardId:    boardId, // 设置公牌ID
+			Hand:       hand,
+			ComboId:    comboId,          // 设置手牌ID
+			Actions:    []model.Action{}, // 初始化空的Actions数组
+			PotInfo:    pot,              // 设置底池信息
+			StackDepth: stackDepth,       // 设置筹码深度
+			Spr:        spr,              // 设置栈底比
+			BetPct:     betPct,           // 设置下注比例
+			IpOrOop:    ipOrOop,          // 设置策略执行者
+			BetLevel:   betLevel,         // 设置主动下注次数
+		}
+	}
+
+	// 只有当strategy有效时才处理策略频率
+	if strategy != nil {
+		// 收集每个手牌在所有动作下的频率，始终添加所有动作，无论频率是否为0
+		for i, action := range actions {
+			for j, hand := range handCards {
+				action.Freq = strategy[i][j]
+				record := handRecords[hand]
+				record.Actions = append(record.Actions, action)
+			}
+		}
+	} else {
+		log.Printf("节点 %s 的策略数据无效，跳过策略处理", node)
+	}
+
+	//calc_ev 当前节点下1326手牌各行动的期望值
+	if actorCmd == "" {
+		log.Printf("节点 %s 的actor不是IP_DEC或OOP_DEC: %s，跳过EV和EQ计算", node, actor)
+		// 这里不返回，因为我们可能已经有部分有用数据
+	}
+
+	// 只有当actorCmd有效时才计算EV
+	if actorCmd != "" {
+		// 遍历所有动作获取EV
+		for i, action := range actions {
+			childNodeID := action.ChildNodeID
+
+			// 当前动作的EV值和match-up值
+			evs, matchups, err := results[i].HandValues()
+			if err != nil {
+				log.Printf("执行指令calc_ev失败: %v，跳过当前动作", err)
+				continue
+			}
+
+			// 遍历所有手牌，添加EV值到对应的Action中
+			for j, hand := range handCards {
+				// 跳过NaN的值
+				if math.IsNaN(evs[j]) || math.IsNaN(matchups[j]) {
+					continue
+				}
+
+				record := handRecords[hand]
+				if i < len(record.Actions) && record.Actions[i].ChildNodeID == childNodeID {
+					record.Actions[i].Ev = evs[j]
+					record.Actions[i].Matchup = matchups[j]
+				}
+			}
+		}
+
+		//calc_eq_node 当前节点下1326手牌的胜率
+		eqs, _, err := results[len(actions)].HandValues()
+		if err != nil {
+			log.Printf("执行指令calc_eq_node失败: %v，跳过EQ处理", err)
+		} else {
+			// 按照handCards顺序为每个手牌设置EQ值
+			for j, hand := range handCards {
+				// 跳过NaN值
+				if math.IsNaN(eqs[j]) {
+					continue
+				}
+
+				// 为所有action设置相同的EQ值
+				record := handRecords[hand]
+				for k := range record.Actions {
+					record.Actions[k].Eq = eqs[j]
+				}
+			}
+		}
+
+		//show_range 双方到达当前节点时1326手牌的范围权重
+		for k, player := range []string{"OOP", "IP"} {
+			weights, err := results[len(actions)+1+k].Range()
+			if err != nil {
+				log.Printf("执行指令show_range %s失败: %v，范围权重记为0", player, err)
+				continue
+			}
+			for j, hand := range handCards {
+				if math.IsNaN(weights[j]) {
+					continue
+				}
+				record := handRecords[hand]
+				if player == "OOP" {
+					record.OopWeight = weights[j]
+				} else {
+					record.IpWeight = weights[j]
+				}
+			}
+		}
+	}
+
+	// 节点汇总：在过滤之前用全部手牌计算，需要有效的策略和行动方，sink不写出汇总时不计算
+	var summary *model.NodeSummary
+	if f.summaries && strategy != nil && actorCmd != "" {
+		summary = &model.NodeSummary{
+			Node:       node,
+			Actor:      actor,
+			Board:      board,
+			Street:     street,
+			BoardId:    boardId,
+			IpOrOop:    ipOrOop,
+			BetLevel:   betLevel,
+			PotInfo:    pot,
+			Pot:        potTotal(pot),
+			StackDepth: stackDepth,
+			Spr:        spr,
+			BetPct:     betPct,
+		}
+
+		//calc_ev、calc_eq_node 对手在当前节点1326手牌的EV和胜率
+		oppEVs, oppMatchups, err := results[len(actions)+3].HandValues()
+		if err != nil {
+			log.Printf("执行指令calc_ev %s失败: %v，对手EV记为0", opponentOf(actorCmd), err)
+		}
+		oppEQs, _, err := results[len(actions)+4].HandValues()
+		if err != nil {
+			log.Printf("执行指令calc_eq_node %s失败: %v，对手胜率记为0", opponentOf(actorCmd), err)
+		}
+
+		nodeRecords := make([]*model.Record, len(handCards))
+		for j, hand := range handCards {
+			nodeRecords[j] = handRecords[hand]
+		}
+		summarizeNode(summary, actorCmd, actions, nodeRecords, oppEVs, oppEQs, oppMatchups)
+	}
+
+	// 过滤NaN值和空记录并按手牌顺序重建records
+	var finalRecords []*model.Record
+	for _, hand := range handCards {
+		record := handRecords[hand]
+		if record == nil {
+			continue
+		}
+
+		// 过滤掉EV或EQ为0、NaN或Inf的Action，以及freq为0的Action
+		var validActions []model.Action
+		for _, action := range record.Actions {
+			// 检查是否所有三个值(freq、ev、eq)都是无效值(0、NaN或Inf)
+			freqIsInvalid := action.Freq == 0
+			evIsInvalid := action.Ev == 0 || math.IsInf(action.Ev, 0) || math.IsNaN(action.Ev)
+			eqIsInvalid := action.Eq == 0 || math.IsInf(action.Eq, 0) || math.IsNaN(action.Eq)
+
+			// 检查ev*matchup是否等于0且不是fold动作
+			evMultMatchupIsZero := action.Ev*action.Matchup == 0 && action.Label != "fold"
+
+			// 只有当所有三个值都无效时或者ev*matchup=0（非fold）时才过滤
+			if (freqIsInvalid && evIsInvalid && eqIsInvalid) || evMultMatchupIsZero {
+				f.stats.FilteredActions++ // 增加过滤计数
+				continue
+			}
+
+			validActions = append(validActions, action)
+		}
+
+		// 更新record的Actions
+		record.Actions = validActions
+
+		// 只有当有有效Action时才添加到finalRecords
+		// 新增条件：如果只有一个action且为fold，也过滤掉
+		if len(record.Actions) > 0 {
+			// 过滤掉只有一个fold动作的record
+			if len(record.Actions) == 1 && record.Actions[0].Label == "fold" {
+				continue
+			}
+			finalRecords = append(finalRecords, record)
+		}
+	}
+
+	// 写入输出
+	if len(finalRecords) > 0 {
+		if err := f.sink.WriteNode(node, finalRecords); err != nil {
+			return false, fmt.Errorf("节点 %s: %v", node, err)
+		}
+		f.stats.Nodes++
+		f.stats.Records += len(finalRecords)
+		for _, record := range finalRecords {
+			f.stats.Actions += len(record.Actions)
+		}
+
+		nodeType := "根节点"
+		if strings.Count(node, ":") > 1 {
+			nodeType = "子节点"
+		}
+		log.Printf("处理完成节点 %s (%s)，当前节点记录数: %d，累计记录数: %d",
+			node, nodeType, len(finalRecords), f.stats.Records)
+	}
+	if summary != nil {
+		if err := f.sink.(SummarySink).WriteSummary(summary); err != nil {
+			return false, fmt.Errorf("节点 %s: %v", node, err)
+		}
+		f.stats.Summaries++
+	}
+	return false, nil
+}
@@ -0,0 +1,316 @@
+// Package parser 把PioSolver的CFR文件解析为逐手牌的策略记录
+//
+// Parser 通过一个PioSolver实例遍历博弈树，为每个决策节点上的手牌生成model.Record
+// （各动作的频率、EV、胜率以及底池、筹码深度等），并流式交给Sink写出。
+// Parser不使用包级全局状态，多个Parser（各自持有一个PioSolver实例）可以并行解析不同的文件：
+//
+//	p, err := parser.Open(ctx, parser.Config{
+//		SolverExe: "./PioSOLVER3-edge.exe",
+//		SolverDir: `E:\piosolver\`,
+//		Sinks:     parser.FileSinks("data", parser.FormatNDJSON),
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	defer p.Close()
+//	stats, err := p.ParseFile(ctx, "40bb_COvsBB_8d5c4c.cfr")
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"piodatasolver/internal/cache"
+	"piodatasolver/internal/upi"
+)
+
+const (
+	// DefaultRootNode 是默认开始遍历的节点
+	DefaultRootNode = "r:0"
+	// DefaultEffectiveStack 是无法从PioSolver获取有效筹码时默认使用的值（bb）
+	DefaultEffectiveStack = 60.0
+)
+
+// Config 是Parser的配置
+type Config struct {
+	// RootNode 是开始遍历的节点，为空时使用DefaultRootNode
+	RootNode string
+	// DefaultEffectiveStack 是无法获取有效筹码时使用的值，为0时使用DefaultEffectiveStack
+	DefaultEffectiveStack float64
+	// Sinks 为每个CFR文件创建输出，为nil时只统计不写出
+	Sinks SinkFactory
+
+	// Street 是解析到的最后一条街：StreetFlop（默认，不进入发牌节点）、StreetTurn 或 StreetRiver
+	Street string
+	// Cards 限制发牌节点只展开这些牌，可以是具体的牌（如 "Ah"）或点数（如 "A"），为空时不限制
+	Cards []string
+	// CardSample 大于0时每个发牌节点只展开（按Cards过滤后）抽取的这么多张牌，抽样是确定的
+	CardSample int
+
+	// MaxDepth 大于0时只遍历从RootNode开始的前MaxDepth层决策节点（RootNode为第1层，发出的牌不计）
+	MaxDepth int
+	// MaxBetLevel 大于0时只遍历主动下注次数不超过MaxBetLevel的节点
+	MaxBetLevel int
+	// NodePattern 非空时只输出节点ID匹配该正则表达式的决策节点，如 "^r:0:c" 只输出OOP过牌之后的节点
+	NodePattern string
+	// Actor 为IP或OOP时只输出该玩家的决策节点
+	// NodePattern和Actor只决定是否输出，不匹配的节点仍会遍历其子节点；MaxDepth和MaxBetLevel直接停止遍历
+	Actor string
+
+	// 以下仅用于Open自行启动PioSolver：SolverAddr非空时连接远程upibridge，否则在SolverDir下启动SolverExe
+	SolverExe  string
+	SolverDir  string
+	SolverAddr string
+}
+
+// Stats 是解析一个CFR文件的统计
+type Stats struct {
+	// 写出了记录的节点数
+	Nodes int
+	// 写出了汇总的决策节点数
+	Summaries int
+	// 写出的记录数和其中的动作数
+	Records int
+	Actions int
+	// 因频率、EV、胜率无效被过滤掉的动作数
+	FilteredActions int
+	// 解析使用的有效筹码（bb）
+	EffectiveStack float64
+	// 查询失败被跳过的节点数（无法获取子节点时连同子树一起跳过），大于0时输出不完整
+	SkippedNodes int
+	// 解析期间PioSolver重启的次数
+	Restarts int
+	Duration time.Duration
+	// 输出对应的解析参数（断点文件头记录的Parser.Params()和输出格式），Sink不支持断点续传时为nil
+	// 从断点继续时是断点文件记录的参数，调用方据此确认输出没有混入其他参数的结果
+	Params map[string]string
+}
+
+// FilterRatio 返回被过滤动作占全部动作的百分比
+func (s Stats) FilterRatio() float64 {
+	total := s.Actions + s.FilteredActions
+	if total == 0 {
+		return 0
+	}
+	return float64(s.FilteredActions) / float64(total) * 100
+}
+
+// ErrIncomplete 表示整棵树遍历完成但有节点因查询失败被跳过，输出没有标记为完成
+// 断点文件保留已写出的节点，再次解析同一文件时只重新查询被跳过的节点
+var ErrIncomplete = errors.New("部分节点查询失败被跳过，输出不完整")
+
+// Parser 用一个PioSolver实例解析CFR文件，同一时间只能解析一个文件
+type Parser struct {
+	config Config
+	client *upi.Client
+	// owned 表示client由Open启动，Close时关闭
+	owned bool
+
+	// 手牌顺序和公牌索引，初始化后只读
+	hands  *cache.HandOrder
+	boards *cache.BoardOrder
+	// pattern 是编译后的NodePattern，未设置时为nil
+	pattern *regexp.Regexp
+}
+
+// New 用已启动的PioSolver客户端创建Parser，会向PioSolver查询手牌顺序；Close不会关闭client
+func New(client *upi.Client, config Config) (*Parser, error) {
+	if config.RootNode == "" {
+		config.RootNode = DefaultRootNode
+	}
+	if config.DefaultEffectiveStack == 0 {
+		config.DefaultEffectiveStack = DefaultEffectiveStack
+	}
+	if config.Street == "" {
+		config.Street = StreetFlop
+	}
+	if streetRank(config.Street) < 0 {
+		return nil, fmt.Errorf("无效的街: %s（应为flop、turn或river）", config.Street)
+	}
+	cards, err := normalizeCards(config.Cards)
+	if err != nil {
+		return nil, err
+	}
+	config.Cards = cards
+	pattern, err := compileFilters(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Parser{
+		config:  config,
+		client:  client,
+		hands:   &cache.HandOrder{},
+		boards:  &cache.BoardOrder{},
+		pattern: pattern,
+	}
+	if err := p.hands.Init(client); err != nil {
+		return nil, fmt.Errorf("初始化HandOrder失败: %v", err)
+	}
+	if err := p.boards.Init(); err != nil {
+		return nil, fmt.Errorf("初始化BoardOrder失败: %v", err)
+	}
+	return p, nil
+}
+
+// Open 按config中的SolverExe/SolverDir（或SolverAddr）启动PioSolver并创建Parser，Close时结束PioSolver
+// ctx被取消时PioSolver进程会被终止
+func Open(ctx context.Context, config Config) (*Parser, error) {
+	var client *upi.Client
+	switch {
+	case config.SolverAddr != "":
+		client = upi.NewTCPClient(config.SolverAddr)
+	case config.SolverExe != "":
+		client = upi.NewClient(config.SolverExe, config.SolverDir)
+	default:
+		return nil, fmt.Errorf("未设置PioSolver路径（SolverExe）或桥接地址（SolverAddr）")
+	}
+	if err := client.Start(ctx); err != nil {
+		return nil, fmt.Errorf("启动PioSolver失败: %v", err)
+	}
+
+	p, err := New(client, config)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	p.owned = true
+	return p, nil
+}
+
+// WithClient 返回使用另一个PioSolver客户端的Parser，与p共享配置和手牌顺序
+// 用于连接池替换了已退出的实例后继续解析，不会重新查询手牌顺序
+func (p *Parser) WithClient(client *upi.Client) *Parser {
+	return &Parser{
+		config:  p.config,
+		client:  client,
+		hands:   p.hands,
+		boards:  p.boards,
+		pattern: p.pattern,
+	}
+}
+
+// Params 返回影响输出内容的配置（应用默认值和规范化之后），用于判断已有的输出是否可以沿用
+func (p *Parser) Params() map[string]string {
+	c := p.config
+	return map[string]string{
+		"root":          c.RootNode,
+		"street":        c.Street,
+		"cards":         strings.Join(c.Cards, ","),
+		"card_sample":   strconv.Itoa(c.CardSample),
+		"max_depth":     strconv.Itoa(c.MaxDepth),
+		"max_bet_level": strconv.Itoa(c.MaxBetLevel),
+		"node_pattern":  c.NodePattern,
+		"actor":         c.Actor,
+	}
+}
+
+// Close 结束由Open启动的PioSolver；由New创建的Parser不做任何事
+func (p *Parser) Close() error {
+	if !p.owned {
+		return nil
+	}
+	return p.client.Close()
+}
+
+// ParseFile 加载CFR文件并从RootNode开始遍历整棵树，把每个节点的记录写入Sinks创建的输出
+//
+// 单个节点的查询失败只跳过该节点，遍历结束后返回包装了ErrIncomplete的错误；PioSolver不可用（进程退出且重启重试用尽）、
+// 写出失败或ctx被取消时返回错误，此时输出不完整，由调用方决定是否删除。
+// Sink实现了Checkpointer（如FileSinks）时每完成一个决策节点记录一次断点，再次解析同一文件时跳过已完成的节点继续写入。
+func (p *Parser) ParseFile(ctx context.Context, path string) (Stats, error) {
+	start := time.Now()
+	if err := ctx.Err(); err != nil {
+		return Stats{}, err
+	}
+
+	// 加载树
+	if err := p.client.LoadTree(path); err != nil {
+		return Stats{}, fmt.Errorf("加载树失败: %v", err)
+	}
+	log.Printf("  ✓ CFR文件加载成功: %s", filepath.Base(path))
+
+	// 获取有效筹码
+	log.Printf("  → 获取有效筹码...")
+	effectiveStack, err := p.client.ShowEffectiveStack()
+	if err != nil {
+		effectiveStack = p.config.DefaultEffectiveStack
+		log.Printf("  ❌ 获取有效筹码失败: 执行show_effective_stack命令失败: %v，使用默认值%gbb", err, effectiveStack)
+	} else {
+		log.Printf("  ✓ 有效筹码: %.2f bb", effectiveStack)
+	}
+
+	// 打开输出，整个文件解析期间流式追加
+	var sink Sink = MultiSink()
+	if p.config.Sinks != nil {
+		if sink, err = p.config.Sinks(path, p.Params()); err != nil {
+			return Stats{}, err
+		}
+	}
+
+	f := &fileParse{
+		Parser:         p,
+		ctx:            ctx,
+		cfrFile:        path,
+		effectiveStack: effectiveStack,
+		sink:           sink,
+		summaries:      acceptsSummaries(sink),
+	}
+	if cp, ok := sink.(Checkpointer); ok {
+		f.checkpoint = cp
+		completed, stats := cp.Resume()
+		f.stats.Params = stats.Params
+		if completed != nil {
+			f.completed = completed
+			f.stats = stats
+			log.Printf("  ↻ 从断点继续：已完成 %d 个决策节点，已写出 %d 条记录", len(completed), stats.Records)
+		}
+	}
+	f.stats.EffectiveStack = effectiveStack
+
+	log.Printf("  → 开始解析节点...")
+	restartsBefore := p.client.Restarts()
+	err = f.parseNode(p.config.RootNode)
+	if cerr := sink.Close(); err == nil {
+		err = cerr
+	}
+	// 只有整棵树遍历成功且没有跳过节点时才把文件标记为完成
+	if err == nil && f.stats.SkippedNodes > 0 {
+		err = fmt.Errorf("%w（跳过 %d 个节点）", ErrIncomplete, f.stats.SkippedNodes)
+	}
+	if err == nil && f.checkpoint != nil {
+		err = f.checkpoint.Finish()
+	}
+	f.stats.Restarts = p.client.Restarts() - restartsBefore
+	f.stats.Duration = time.Since(start)
+	return f.stats, err
+}
+
+// fileParse 是解析单个CFR文件的状态
+type fileParse struct {
+	*Parser
+	ctx            context.Context
+	cfrFile        string
+	effectiveStack float64
+	sink           Sink
+	stats          Stats
+	// summaries 表示sink写出节点汇总，为false时不计算汇总
+	summaries bool
+
+	// checkpoint 在每个决策节点完成后记录断点，Sink不支持断点续传时为nil
+	checkpoint Checkpointer
+	// completed 是从断点继续时上次已完成的决策节点，不再查询和输出
+	completed map[string]bool
+}
+
+// isFatalQueryError 判断查询错误是否说明PioSolver已不可用（进程退出且重启重试用尽），此时应放弃整个文件
+func isFatalQueryError(client *upi.Client, err error) bool {
+	return err != nil && (errors.Is(err, upi.ErrProcessExited) || !client.Alive())
+}
@@ -0,0 +1,398 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"piodatasolver/internal/fakepio"
+	"piodatasolver/internal/upi"
+	"piodatasolver/model"
+)
+
+// fakepioExe 是TestMain编译的fakepio可执行文件
+var fakepioExe string
+
+// fixturePath 是fakepio加载的合成博弈树
+var fixturePath, _ = filepath.Abs("../testdata/fakepio/40bb_COvsBB_Ks7d2c.cfr")
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "fakepio")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fakepioExe, err = fakepio.Build(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// openParser 启动fakepio并创建Parser，测试结束时关闭
+func openParser(t *testing.T, config Config) *Parser {
+	t.Helper()
+	config.SolverExe = fakepioExe
+	config.SolverDir = t.TempDir()
+	p, err := Open(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// readLines 读取NDJSON输出的每一行
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<24)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestParseFile(t *testing.T) {
+	dir := t.TempDir()
+	p := openParser(t, Config{Sinks: FileSinks(dir, FormatNDJSON)})
+
+	stats, err := p.ParseFile(context.Background(), fixturePath)
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	if stats.Nodes == 0 || stats.Records == 0 || stats.Summaries == 0 {
+		t.Fatalf("没有输出: %+v", stats)
+	}
+	if stats.EffectiveStack != 370 {
+		t.Errorf("有效筹码 = %v", stats.EffectiveStack)
+	}
+
+	recordPath, summaryPath, sqlPath := OutputPaths(dir, fixturePath, FormatNDJSON)
+	records := readLines(t, recordPath)
+	if len(records) != stats.Records {
+		t.Errorf("记录文件有 %d 行，统计为 %d 条", len(records), stats.Records)
+	}
+	actions := 0
+	for _, line := range records {
+		var record model.Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("无法解析记录: %v", err)
+		}
+		if record.Street != StreetFlop || len(record.Actions) == 0 {
+			t.Fatalf("记录无效: %+v", record)
+		}
+		if record.OopWeight < 0 || record.OopWeight > 1 || record.IpWeight < 0 || record.IpWeight > 1 {
+			t.Errorf("%s %s 的范围权重 = %v, %v", record.Node, record.Hand, record.OopWeight, record.IpWeight)
+		}
+		// 根节点双方都还没有行动，范围权重是初始范围
+		if record.Node == DefaultRootNode && (record.OopWeight != 1 || record.IpWeight != 1) {
+			t.Errorf("根节点 %s 的范围权重 = %v, %v", record.Hand, record.OopWeight, record.IpWeight)
+		}
+		actions += len(record.Actions)
+	}
+	if actions != stats.Actions {
+		t.Errorf("记录中有 %d 个动作，统计为 %d 个", actions, stats.Actions)
+	}
+	summaries := readLines(t, summaryPath)
+	if len(summaries) != stats.Summaries {
+		t.Errorf("汇总文件有 %d 行，统计为 %d 条", len(summaries), stats.Summaries)
+	}
+	for _, line := range summaries {
+		var summary model.NodeSummary
+		if err := json.Unmarshal([]byte(line), &summary); err != nil {
+			t.Fatalf("无法解析节点汇总: %v", err)
+		}
+		total := 0.0
+		for _, action := range summary.Actions {
+			total += action.Freq
+		}
+		if summary.ActorCombos <= 0 || math.Abs(total-1) > 1e-6 {
+			t.Errorf("%s 的汇总无效: 行动方 %v 手牌组合，动作频率之和 %v", summary.Node, summary.ActorCombos, total)
+		}
+	}
+
+	// 每条记录在记录表一行，每个动作在动作子表一行，每个节点汇总在汇总表一行
+	sql, err := os.ReadFile(sqlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(sql), ActionsTableSuffix+" ("); n != stats.Actions {
+		t.Errorf("动作子表有 %d 条插入语句，应为 %d 条", n, stats.Actions)
+	}
+	if n := strings.Count(string(sql), NodesTableSuffix+" ("); n != stats.Summaries {
+		t.Errorf("节点汇总表有 %d 条插入语句，应为 %d 条", n, stats.Summaries)
+	}
+
+	// 完成后不留下临时文件和断点文件
+	for _, path := range []string{recordPath + TempSuffix, JournalPath(dir, fixturePath)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s 应已删除", filepath.Base(path))
+		}
+	}
+}
+
+func TestParseFileReplaysCassette(t *testing.T) {
+	ctx := context.Background()
+	cassettePath := filepath.Join(t.TempDir(), "session.jsonl")
+
+	// 录制：用fakepio解析并把会话写入录制文件
+	want := t.TempDir()
+	recording := upi.NewClient(fakepioExe, t.TempDir())
+	if err := recording.RecordTo(cassettePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := recording.Start(ctx); err != nil {
+		t.Fatalf("启动fakepio失败: %v", err)
+	}
+	p, err := New(recording, Config{Sinks: FileSinks(want, FormatJSON)})
+	if err != nil {
+		recording.Close()
+		t.Fatal(err)
+	}
+	_, err = p.ParseFile(ctx, fixturePath)
+	recording.Close()
+	if err != nil {
+		t.Fatalf("录制时ParseFile: %v", err)
+	}
+
+	// 回放：不启动PioSolver，输出必须与录制时逐字节相同
+	cassette, err := upi.LoadCassette(cassettePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := upi.NewReplayClient(cassette)
+	if err := replay.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer replay.Close()
+	dir := t.TempDir()
+	p, err = New(replay, Config{Sinks: FileSinks(dir, FormatJSON)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ParseFile(ctx, fixturePath); err != nil {
+		t.Fatalf("回放时ParseFile: %v", err)
+	}
+	assertSameOutputs(t, dir, want)
+}
+
+func TestParseFileTurn(t *testing.T) {
+	dir := t.TempDir()
+	p := openParser(t, Config{Street: StreetTurn, CardSample: 2, Sinks: FileSinks(dir, FormatNDJSON)})
+
+	stats, err := p.ParseFile(context.Background(), fixturePath)
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	if stats.Records == 0 {
+		t.Fatal("解析到转牌时没有输出")
+	}
+
+	// 翻牌和转牌的记录都有，转牌记录的公牌多一张，不会越过转牌进入河牌
+	recordPath, _, _ := OutputPaths(dir, fixturePath, FormatNDJSON)
+	streets := map[string]int{}
+	for _, line := range readLines(t, recordPath) {
+		var record model.Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("无法解析记录: %v", err)
+		}
+		streets[record.Street]++
+		if record.Street == StreetTurn && len(strings.Fields(record.Board)) != 4 {
+			t.Errorf("转牌记录的公牌 = %q", record.Board)
+		}
+	}
+	if streets[StreetFlop] == 0 || streets[StreetTurn] == 0 || streets[StreetRiver] != 0 {
+		t.Errorf("各街的记录数 = %v", streets)
+	}
+
+	if _, err := Open(context.Background(), Config{Street: "preflop", SolverExe: fakepioExe, SolverDir: t.TempDir()}); err == nil {
+		t.Error("无效的街应返回错误")
+	}
+}
+
+func TestParseFileWithoutSummaries(t *testing.T) {
+	// 没有配置Sinks时不计算节点汇总，也不计数
+	stats, err := openParser(t, Config{MaxDepth: 2}).ParseFile(context.Background(), fixturePath)
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	if stats.Records == 0 || stats.Summaries != 0 {
+		t.Errorf("没有汇总输出时 Records = %d, Summaries = %d", stats.Records, stats.Summaries)
+	}
+
+	var buf bytes.Buffer
+	for _, tt := range []struct {
+		sink Sink
+		want bool
+	}{
+		{MultiSink(), false},
+		{NewRecordSink(&buf, FormatNDJSON), false},
+		{MultiSink(NewRecordSink(&buf, FormatNDJSON)), false},
+		{NewSummarySink(&buf, FormatNDJSON), true},
+		{NewSQLSink(&buf, fixturePath), true},
+		{MultiSink(NewRecordSink(&buf, FormatNDJSON), NewSummarySink(&buf, FormatNDJSON)), true},
+	} {
+		if got := acceptsSummaries(tt.sink); got != tt.want {
+			t.Errorf("acceptsSummaries(%T) = %v", tt.sink, got)
+		}
+	}
+}
+
+// parseRecords 按config解析fixture并返回写出的全部记录
+func parseRecords(t *testing.T, config Config) []model.Record {
+	t.Helper()
+	dir := t.TempDir()
+	config.Sinks = FileSinks(dir, FormatNDJSON)
+	if _, err := openParser(t, config).ParseFile(context.Background(), fixturePath); err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	recordPath, _, _ := OutputPaths(dir, fixturePath, FormatNDJSON)
+	var records []model.Record
+	for _, line := range readLines(t, recordPath) {
+		var record model.Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("无法解析记录: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// depthOf 返回节点相对r:0的决策层数，发出的牌不计
+func depthOf(node string) int {
+	depth := 1
+	for _, segment := range strings.Split(node, ":")[2:] {
+		if !isCard(segment) {
+			depth++
+		}
+	}
+	return depth
+}
+
+func TestParseFileFilters(t *testing.T) {
+	const maxDepth, maxBetLevel = 3, 1
+	// r:0 和 r:0:c 都不匹配，只有经过它们才能到达匹配的节点
+	pattern := regexp.MustCompile("^r:0:c:")
+
+	// 不过滤时满足条件的节点
+	want := map[string]bool{}
+	for _, record := range parseRecords(t, Config{}) {
+		if depthOf(record.Node) <= maxDepth && calculateBetLevel(record.Node) <= maxBetLevel && pattern.MatchString(record.Node) {
+			want[record.Node] = true
+		}
+	}
+	if len(want) == 0 {
+		t.Fatal("fixture中没有满足过滤条件的节点")
+	}
+
+	got := map[string]bool{}
+	for _, record := range parseRecords(t, Config{MaxDepth: maxDepth, MaxBetLevel: maxBetLevel, NodePattern: pattern.String()}) {
+		if depthOf(record.Node) > maxDepth || calculateBetLevel(record.Node) > maxBetLevel || !pattern.MatchString(record.Node) {
+			t.Fatalf("输出了不满足过滤条件的节点 %s", record.Node)
+		}
+		got[record.Node] = true
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("输出的节点 = %v，应为 %v", got, want)
+	}
+
+	for _, record := range parseRecords(t, Config{MaxDepth: 2, Actor: "ip"}) {
+		if record.IpOrOop != "IP" || depthOf(record.Node) > 2 {
+			t.Fatalf("输出了不满足过滤条件的节点 %s（%s）", record.Node, record.IpOrOop)
+		}
+	}
+
+	for _, config := range []Config{{MaxDepth: -1}, {MaxBetLevel: -1}, {NodePattern: "("}, {Actor: "BTN"}} {
+		config.SolverExe, config.SolverDir = fakepioExe, t.TempDir()
+		if p, err := Open(context.Background(), config); err == nil {
+			p.Close()
+			t.Errorf("无效的过滤配置 %+v 应返回错误", config)
+		}
+	}
+}
+
+func TestGenerateSQLInsert(t *testing.T) {
+	record := &model.Record{
+		BoardId: 7,
+		ComboId: 42,
+		Board:   "Ks 7d 2c",
+		Hand:    "AhAs",
+		IpOrOop: "oop",
+		Actions: []model.Action{
+			{Label: "check", Freq: 0.5, Ev: 10, Eq: 0.8},
+			{Label: "bet 20", Freq: 0.3, Ev: 11, Eq: 0.8},
+			{Label: "bet 60", Freq: 0.2, Ev: 9, Eq: 0.8},
+		},
+	}
+	lines := strings.Split(strings.TrimSuffix(generateSQLInsert(record, "r0", 0, "flop_40bb_co_bb"), "\n"), "\n")
+	if len(lines) != 1+len(record.Actions) {
+		t.Fatalf("生成了 %d 条插入语句:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	if !strings.HasPrefix(lines[0], "INSERT IGNORE INTO flop_40bb_co_bb (") || !strings.HasSuffix(lines[0], ", 3);") {
+		t.Errorf("记录表插入语句 = %s", lines[0])
+	}
+	for i, line := range lines[1:] {
+		want := fmt.Sprintf("VALUES ('r0', 7, 42, %d, '%s', ", i, record.Actions[i].Label)
+		if !strings.HasPrefix(line, "INSERT IGNORE INTO flop_40bb_co_bb_actions (") || !strings.Contains(line, want) {
+			t.Errorf("第 %d 个动作的插入语句 = %s", i, line)
+		}
+	}
+
+	if sql := generateSQLInsert(&model.Record{}, "r0", 0, "flop_40bb_co_bb"); sql != "" {
+		t.Errorf("没有动作的记录不应生成插入语句: %s", sql)
+	}
+}
+
+func TestRecordSinkJSON(t *testing.T) {
+	record := func(hand string) *model.Record {
+		return &model.Record{
+			Node:    "r:0",
+			Board:   "Ks7d2c",
+			Hand:    hand,
+			Actions: []model.Action{{Label: "check", Freq: 0.25}, {Label: "bet 20", Freq: 0.75}},
+		}
+	}
+	nodes := [][]*model.Record{{record("AhAs"), record("KhKd")}, {record("7c7h")}}
+
+	// 逐节点流式写出的JSON数组与一次性MarshalIndent的结果逐字节相同
+	var buf bytes.Buffer
+	sink := NewRecordSink(&buf, FormatJSON)
+	var all []*model.Record
+	for _, records := range nodes {
+		if err := sink.WriteNode(records[0].Node, records); err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, records...)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.MarshalIndent(all, "", "  ")
+	if buf.String() != string(want) {
+		t.Errorf("JSON输出 =\n%s\n应为\n%s", buf.String(), want)
+	}
+
+	// 没有记录时输出空数组
+	buf.Reset()
+	if err := NewRecordSink(&buf, FormatJSON).Close(); err != nil || buf.String() != "[]" {
+		t.Errorf("空输出 = %q, %v", buf.String(), err)
+	}
+}
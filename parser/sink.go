@@ -0,0 +1,315 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"piodatasolver/model"
+)
+
+// 解析结果的记录格式
+const (
+	FormatJSON   = "json"   // JSON数组，<目录>/<文件名>.json
+	FormatNDJSON = "ndjson" // 每行一条记录，<目录>/<文件名>.ndjson
+)
+
+// Sink 接收解析一个CFR文件得到的记录
+type Sink interface {
+	// WriteNode 写入一个节点的全部有效记录，每个有记录的节点调用一次
+	WriteNode(node string, records []*model.Record) error
+	// Close 在文件解析结束时调用（无论成功与否），写入错误也可以在这里返回
+	Close() error
+}
+
+// SummarySink 是同时接收节点汇总的Sink：每个有策略的决策节点在WriteNode之后调用一次WriteSummary（节点没有有效记录时不调用WriteNode）
+type SummarySink interface {
+	Sink
+	WriteSummary(summary *model.NodeSummary) error
+}
+
+// SinkFactory 为要解析的CFR文件创建Sink，params是解析使用的Parser.Params()，可用于判断已有的输出能否沿用
+type SinkFactory func(cfrFile string, params map[string]string) (Sink, error)
+
+// SummaryFileSuffix 是节点汇总输出文件名的后缀：<文件名>_nodes.json
+const SummaryFileSuffix = "_nodes"
+
+// TempSuffix 是解析期间输出文件名的后缀：解析期间写入 <输出路径>.tmp，整棵树遍历成功后才重命名为输出路径
+const TempSuffix = ".tmp"
+
+// OutputPaths 返回CFR文件在dir下的记录输出路径、节点汇总输出路径和SQL输出路径
+func OutputPaths(dir, cfrFile, format string) (recordPath, summaryPath, sqlPath string) {
+	_, cfrFileName := filepath.Split(cfrFile)
+	cfrFileName = strings.TrimSuffix(cfrFileName, filepath.Ext(cfrFileName))
+	return filepath.Join(dir, cfrFileName+"."+format),
+		filepath.Join(dir, cfrFileName+SummaryFileSuffix+"."+format),
+		filepath.Join(dir, cfrFileName+".sql")
+}
+
+// FileSinks 返回把记录、节点汇总和SQL插入语句写入dir目录的SinkFactory，输出路径见OutputPaths
+// 解析期间写入带TempSuffix的临时文件，返回的Sink实现了Checkpointer：每完成一个节点就记录到断点文件（见JournalPath），
+// 断点文件存在且记录的参数（params和format）与本次相同时从中断处继续写入临时文件，参数不同时丢弃断点重新解析；
+// Finish时把临时文件重命名为输出路径，覆盖已存在的文件
+func FileSinks(dir, format string) SinkFactory {
+	return func(cfrFile string, params map[string]string) (Sink, error) {
+		if format != FormatJSON && format != FormatNDJSON {
+			return nil, fmt.Errorf("不支持的输出格式: %s（应为json或ndjson）", format)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("创建输出目录失败: %v", err)
+		}
+
+		recordPath, summaryPath, sqlPath := OutputPaths(dir, cfrFile, format)
+		sink, err := openFileSink(JournalPath(dir, cfrFile), cfrFile, format, params, recordPath, summaryPath, sqlPath)
+		if err != nil {
+			return nil, err
+		}
+		return sink, nil
+	}
+}
+
+// recordSink 以流的方式写出记录：JSON数组或每行一条记录的NDJSON
+type recordSink struct {
+	format string
+	dst    io.Writer
+	w      *bufio.Writer
+	count  int
+	// summaries 为true时写出节点汇总而不是逐手牌记录
+	summaries bool
+}
+
+// NewRecordSink 创建把记录写入w的Sink，format为FormatJSON或FormatNDJSON；w实现了io.Closer时在Close中关闭
+func NewRecordSink(w io.Writer, format string) Sink {
+	s := &recordSink{format: format, dst: w, w: bufio.NewWriterSize(w, 1<<20)}
+	if format == FormatJSON {
+		s.w.WriteString("[")
+	}
+	return s
+}
+
+// resumeRecordSink 创建继续写入已有输出的recordSink，已有输出长度为offset（JSON数组已写出开头的"["）
+func resumeRecordSink(w io.Writer, format string, offset int64) *recordSink {
+	s := &recordSink{format: format, dst: w, w: bufio.NewWriterSize(w, 1<<20)}
+	if format == FormatJSON && offset > int64(len("[")) {
+		s.count = 1
+	}
+	return s
+}
+
+// NewSummarySink 创建把节点汇总写入w的SummarySink，格式与NewRecordSink相同，逐手牌记录被忽略
+func NewSummarySink(w io.Writer, format string) SummarySink {
+	s := NewRecordSink(w, format).(*recordSink)
+	s.summaries = true
+	return s
+}
+
+// WriteNode 追加一个节点的记录
+func (s *recordSink) WriteNode(node string, records []*model.Record) error {
+	if s.summaries {
+		return nil
+	}
+	for _, record := range records {
+		if err := s.write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSummary 追加一个节点的汇总，只有NewSummarySink创建的Sink写出
+func (s *recordSink) WriteSummary(summary *model.NodeSummary) error {
+	if !s.summaries {
+		return nil
+	}
+	return s.write(summary)
+}
+
+// write 追加一条JSON值
+func (s *recordSink) write(v any) error {
+	switch s.format {
+	case FormatJSON:
+		// 与json.MarshalIndent(全部记录, "", "  ")的输出格式一致
+		data, err := json.MarshalIndent(v, "  ", "  ")
+		if err != nil {
+			return fmt.Errorf("JSON序列化失败: %v", err)
+		}
+		if s.count > 0 {
+			s.w.WriteString(",")
+		}
+		s.w.WriteString("\n  ")
+		s.w.Write(data)
+	case FormatNDJSON:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("JSON序列化失败: %v", err)
+		}
+		s.w.Write(data)
+		s.w.WriteString("\n")
+	default:
+		return fmt.Errorf("不支持的输出格式: %s（应为json或ndjson）", s.format)
+	}
+	s.count++
+	return nil
+}
+
+// flush 把缓冲的输出写入dst
+func (s *recordSink) flush() error {
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("写入%s文件失败: %v", strings.ToUpper(s.format), err)
+	}
+	return nil
+}
+
+// Close 结束JSON数组并刷新输出
+func (s *recordSink) Close() error {
+	if s.format == FormatJSON {
+		if s.count > 0 {
+			s.w.WriteString("\n")
+		}
+		s.w.WriteString("]")
+	}
+
+	var errs []error
+	if err := s.w.Flush(); err != nil {
+		errs = append(errs, fmt.Errorf("写入%s文件失败: %v", strings.ToUpper(s.format), err))
+	}
+	if c, ok := s.dst.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sqlSink 为每条记录生成一条SQL插入语句
+type sqlSink struct {
+	tableName string
+	dst       io.Writer
+	w         *bufio.Writer
+}
+
+// NewSQLSink 创建把SQL插入语句写入w的Sink，表名由CFR文件名生成（见TableName）；w实现了io.Closer时在Close中关闭
+func NewSQLSink(w io.Writer, cfrFile string) Sink {
+	s := &sqlSink{
+		tableName: TableName(filepath.Base(cfrFile)),
+		dst:       w,
+		w:         bufio.NewWriterSize(w, 1<<20),
+	}
+	// 写入SQL文件头部
+	s.w.WriteString("-- Generated SQL insert statements\n")
+	s.w.WriteString(fmt.Sprintf("-- CFR File: %s\n", filepath.Base(cfrFile)))
+	s.w.WriteString("-- Total records will be added incrementally\n\n")
+	return s
+}
+
+// resumeSQLSink 创建继续写入已有SQL输出的sqlSink，不再写入文件头部
+func resumeSQLSink(w io.Writer, cfrFile string) *sqlSink {
+	return &sqlSink{
+		tableName: TableName(filepath.Base(cfrFile)),
+		dst:       w,
+		w:         bufio.NewWriterSize(w, 1<<20),
+	}
+}
+
+// WriteNode 追加一个节点的SQL插入语句
+func (s *sqlSink) WriteNode(node string, records []*model.Record) error {
+	for _, record := range records {
+		// 生成SQL插入语句（使用Record中已计算的BetLevel和动态表名）
+		if sqlInsert := generateSQLInsert(record, convertNodePath(record.Node), record.BetLevel, s.tableName); sqlInsert != "" {
+			s.w.WriteString(sqlInsert)
+		}
+	}
+	return nil
+}
+
+// WriteSummary 追加一个节点汇总的SQL插入语句
+func (s *sqlSink) WriteSummary(summary *model.NodeSummary) error {
+	s.w.WriteString(generateSummarySQLInsert(summary, convertNodePath(summary.Node), s.tableName))
+	return nil
+}
+
+// flush 把缓冲的输出写入dst
+func (s *sqlSink) flush() error {
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("写入SQL文件失败: %v", err)
+	}
+	return nil
+}
+
+// Close 刷新输出
+func (s *sqlSink) Close() error {
+	var errs []error
+	if err := s.w.Flush(); err != nil {
+		errs = append(errs, fmt.Errorf("写入SQL文件失败: %v", err))
+	}
+	if c, ok := s.dst.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// acceptsSummaries 判断Sink是否会写出节点汇总，不写出时解析不计算汇总，也不查询汇总需要的对手EV和胜率
+func acceptsSummaries(sink Sink) bool {
+	switch s := sink.(type) {
+	case *fileSink:
+		return acceptsSummaries(s.SummarySink)
+	case multiSink:
+		for _, inner := range s {
+			if acceptsSummaries(inner) {
+				return true
+			}
+		}
+		return false
+	case *recordSink:
+		return s.summaries
+	case *sqlSink:
+		return true
+	}
+	_, ok := sink.(SummarySink)
+	return ok
+}
+
+// multiSink 把记录依次写入多个Sink
+type multiSink []Sink
+
+// MultiSink 返回把记录依次写入全部sinks的SummarySink，节点汇总只写入实现了SummarySink的sinks，Close时关闭全部sinks
+func MultiSink(sinks ...Sink) SummarySink {
+	return multiSink(sinks)
+}
+
+func (m multiSink) WriteNode(node string, records []*model.Record) error {
+	for _, s := range m {
+		if err := s.WriteNode(node, records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiSink) WriteSummary(summary *model.NodeSummary) error {
+	for _, s := range m {
+		if ss, ok := s.(SummarySink); ok {
+			if err := ss.WriteSummary(summary); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m multiSink) Close() error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
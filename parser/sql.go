@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"piodatasolver/model"
+)
+
+// 子表名的后缀：记录表 flop_40bb_co_bb 的动作存放在 flop_40bb_co_bb_actions，
+// 节点汇总存放在 flop_40bb_co_bb_nodes，节点汇总的动作存放在 flop_40bb_co_bb_node_strategy
+const (
+	ActionsTableSuffix      = "_actions"
+	NodesTableSuffix        = "_nodes"
+	NodeStrategyTableSuffix = "_node_strategy"
+)
+
+// 记录表和动作子表的列，两表通过 (node_prefix, board_id, combo_id) 关联；
+// 节点汇总表和汇总动作表的列，两表通过 (node_prefix, board_id) 关联
+var (
+	RecordColumns = []string{"node_prefix", "bet_level", "board_id", "combo_id", "stack_depth", "bet_pct", "spr",
+		"board_str", "combo_str", "ip_or_oop", "oop_weight", "ip_weight", "action_count"}
+	ActionColumns = []string{"node_prefix", "board_id", "combo_id", "action_index", "action", "freq", "ev", "eq"}
+	NodeColumns   = []string{"node_prefix", "bet_level", "board_id", "board_str", "street", "ip_or_oop", "pot",
+		"stack_depth", "bet_pct", "spr", "actor_combos", "opponent_combos", "actor_ev", "actor_eq",
+		"opponent_ev", "opponent_eq", "action_count"}
+	NodeStrategyColumns = []string{"node_prefix", "board_id", "action_index", "action", "freq", "ev"}
+)
+
+// TableSuffix 返回子表名的后缀（ActionsTableSuffix等），记录表返回空字符串
+func TableSuffix(tableName string) string {
+	for _, suffix := range []string{ActionsTableSuffix, NodesTableSuffix, NodeStrategyTableSuffix} {
+		if strings.HasSuffix(tableName, suffix) {
+			return suffix
+		}
+	}
+	return ""
+}
+
+// TableColumns 按表名后缀返回表的列
+func TableColumns(tableName string) []string {
+	switch TableSuffix(tableName) {
+	case ActionsTableSuffix:
+		return ActionColumns
+	case NodesTableSuffix:
+		return NodeColumns
+	case NodeStrategyTableSuffix:
+		return NodeStrategyColumns
+	}
+	return RecordColumns
+}
+
+// generateSQLInsert 生成一条记录的SQL插入语句：记录表一行（每个节点、每手牌一行），
+// 动作子表每个动作一行（action_index从0开始，与JSON中actions的顺序一致）
+func generateSQLInsert(record *model.Record, nodePrefix string, betLevel int, tableName string) string {
+	// 确保至少有一个动作
+	if len(record.Actions) == 0 {
+		return ""
+	}
+
+	var sql strings.Builder
+	sql.WriteString(fmt.Sprintf("INSERT IGNORE INTO %s (%s) VALUES ('%s', %d, %d, %d, %.3f, %.4f, %.4f, '%s', '%s', '%s', %.6f, %.6f, %d);\n",
+		tableName, strings.Join(RecordColumns, ", "), nodePrefix, betLevel, record.BoardId, record.ComboId,
+		record.StackDepth, record.BetPct, record.Spr, strings.TrimSpace(record.Board), record.Hand, record.IpOrOop,
+		record.OopWeight, record.IpWeight, len(record.Actions)))
+
+	for i, action := range record.Actions {
+		sql.WriteString(fmt.Sprintf("INSERT IGNORE INTO %s (%s) VALUES ('%s', %d, %d, %d, '%s', %.3f, %.3f, %.3f);\n",
+			tableName+ActionsTableSuffix, strings.Join(ActionColumns, ", "), nodePrefix, record.BoardId, record.ComboId,
+			i, action.Label, action.Freq, action.Ev, action.Eq))
+	}
+	return sql.String()
+}
+
+// generateSummarySQLInsert 生成一个节点汇总的SQL插入语句：节点汇总表一行，汇总动作表每个动作一行
+func generateSummarySQLInsert(summary *model.NodeSummary, nodePrefix string, tableName string) string {
+	var sql strings.Builder
+	sql.WriteString(fmt.Sprintf("INSERT IGNORE INTO %s (%s) VALUES ('%s', %d, %d, '%s', '%s', '%s', %.3f, %.3f, %.4f, %.4f, %.3f, %.3f, %.4f, %.4f, %.4f, %.4f, %d);\n",
+		tableName+NodesTableSuffix, strings.Join(NodeColumns, ", "), nodePrefix, summary.BetLevel, summary.BoardId,
+		strings.TrimSpace(summary.Board), summary.Street, summary.IpOrOop, summary.Pot, summary.StackDepth,
+		summary.BetPct, summary.Spr, summary.ActorCombos, summary.OpponentCombos, summary.ActorEv, summary.ActorEq,
+		summary.OpponentEv, summary.OpponentEq, len(summary.Actions)))
+
+	for i, action := range summary.Actions {
+		sql.WriteString(fmt.Sprintf("INSERT IGNORE INTO %s (%s) VALUES ('%s', %d, %d, '%s', %.4f, %.4f);\n",
+			tableName+NodeStrategyTableSuffix, strings.Join(NodeStrategyColumns, ", "), nodePrefix, summary.BoardId,
+			i, action.Label, action.Freq, action.Ev))
+	}
+	return sql.String()
+}
+
+// TableName 从CFR文件名生成表名（包含公牌），如 40bb_COvsBB_8d5c4c.cfr -> flop_40bb_co_bb_8d5c4c
+func TableName(cfrFileName string) string {
+	// 移除.cfr扩展名
+	baseName := strings.TrimSuffix(cfrFileName, ".cfr")
+
+	// 解析文件名格式: 40bb_COvsBB_8d5c4c
+	// 转换为: flop_40bb_co_bb_8d5c4c (包含公牌信息，用于CSV文件名)
+	parts := strings.Split(baseName, "_")
+	if len(parts) >= 3 {
+		// 提取筹码深度 (如 40bb)
+		stackDepth := parts[0]
+
+		// 提取位置信息 (如 COvsBB)
+		position := parts[1]
+
+		// 提取公牌信息 (如 8d5c4c)
+		board := parts[2]
+
+		// 转换位置信息为小写并格式化
+		// COvsBB -> co_bb
+		positionLower := strings.ToLower(position)
+		positionFormatted := strings.ReplaceAll(positionLower, "vs", "_")
+
+		// 生成表名格式: flop_筹码_位置_公牌 (包含公牌，用于CSV文件名)
+		tableName := fmt.Sprintf("flop_%s_%s_%s", stackDepth, positionFormatted, board)
+
+		log.Printf("生成CSV文件名: %s -> %s", baseName, tableName)
+		return tableName
+	}
+
+	// 如果解析失败，使用默认表名
+	log.Printf("警告：无法解析CFR文件名 %s，使用默认表名", baseName)
+	return "flop_60bb_co_bb"
+}
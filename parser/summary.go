@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"piodatasolver/model"
+)
+
+// opponentOf 返回另一方玩家：OOP -> IP，IP -> OOP
+func opponentOf(player string) string {
+	if player == "OOP" {
+		return "IP"
+	}
+	return "OOP"
+}
+
+// potTotal 返回底池信息（如 "0 0 60"）中各项之和
+func potTotal(potInfo string) float64 {
+	total := 0.0
+	for _, field := range strings.Fields(potInfo) {
+		if v, err := strconv.ParseFloat(field, 64); err == nil {
+			total += v
+		}
+	}
+	return total
+}
+
+// summarizeNode 用过滤前的逐手牌记录（与actions一一对应）和对手的EV、胜率计算节点汇总
+//
+// 动作频率按行动方的范围权重加权；EV和胜率按范围权重乘以match-up加权，与PioSolver计算范围整体EV的方式一致。
+// EV无效（NaN/Inf）的动作不计入该动作的EV和手牌的整体EV；所有动作都无效的手牌不计入行动方的EV和胜率。
+// 对手的数据按手牌顺序给出，查询失败时为nil，对应字段记为0。
+func summarizeNode(summary *model.NodeSummary, actor string, actions []model.Action, records []*model.Record,
+	oppEVs, oppEQs, oppMatchups []float64) {
+	weightOf := func(record *model.Record, player string) float64 {
+		if player == "OOP" {
+			return record.OopWeight
+		}
+		return record.IpWeight
+	}
+	valid := func(vs ...float64) bool {
+		for _, v := range vs {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return false
+			}
+		}
+		return true
+	}
+
+	freqs := make([]float64, len(actions))
+	evSums := make([]float64, len(actions))
+	evWeights := make([]float64, len(actions))
+	var actorEV, actorEQ, actorWeight float64
+	for _, record := range records {
+		w := weightOf(record, actor)
+		if w <= 0 || len(record.Actions) != len(actions) {
+			continue
+		}
+		summary.ActorCombos += w
+
+		// 手牌的整体EV是各动作EV按策略频率加权的平均；match-up与胜率在各动作上相同
+		// 部分动作的EV无效时只在有效动作之间按频率平均，否则整体EV会被无效动作的频率拉低
+		var handEV, handFreq float64
+		matchup, eq := record.Actions[0].Matchup, record.Actions[0].Eq
+		for i, action := range record.Actions {
+			freqs[i] += w * action.Freq
+			if valid(action.Ev, action.Matchup) {
+				evSums[i] += w * action.Matchup * action.Freq * action.Ev
+				evWeights[i] += w * action.Matchup * action.Freq
+				handEV += action.Freq * action.Ev
+				handFreq += action.Freq
+			}
+		}
+		if handFreq > 0 && valid(handEV, matchup, eq) {
+			handEV /= handFreq
+			actorEV += w * matchup * handEV
+			actorEQ += w * matchup * eq
+			actorWeight += w * matchup
+		}
+	}
+
+	for i, action := range actions {
+		nodeAction := model.NodeAction{ChildNodeID: action.ChildNodeID, Label: action.Label}
+		if summary.ActorCombos > 0 {
+			nodeAction.Freq = freqs[i] / summary.ActorCombos
+		}
+		if evWeights[i] > 0 {
+			nodeAction.Ev = evSums[i] / evWeights[i]
+		}
+		summary.Actions = append(summary.Actions, nodeAction)
+	}
+	if actorWeight > 0 {
+		summary.ActorEv = actorEV / actorWeight
+		summary.ActorEq = actorEQ / actorWeight
+	}
+
+	var oppEV, oppEQ, oppWeight float64
+	for j, record := range records {
+		w := weightOf(record, opponentOf(actor))
+		if w <= 0 {
+			continue
+		}
+		summary.OpponentCombos += w
+		if j >= len(oppEVs) || j >= len(oppEQs) || j >= len(oppMatchups) || !valid(oppEVs[j], oppEQs[j], oppMatchups[j]) {
+			continue
+		}
+		oppEV += w * oppMatchups[j] * oppEVs[j]
+		oppEQ += w * oppMatchups[j] * oppEQs[j]
+		oppWeight += w * oppMatchups[j]
+	}
+	if oppWeight > 0 {
+		summary.OpponentEv = oppEV / oppWeight
+		summary.OpponentEq = oppEQ / oppWeight
+	}
+}
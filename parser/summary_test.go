@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"math"
+	"testing"
+
+	"piodatasolver/model"
+)
+
+func TestSummarizeNode(t *testing.T) {
+	nan := math.NaN()
+	actions := []model.Action{{ChildNodeID: "r:0:c", Label: "CHECK"}, {ChildNodeID: "r:0:b20", Label: "BET 20"}}
+	// A: 权重1，match-up 1，胜率0.6，各半check(EV 10)/bet(EV 20)；B: 权重0.5，match-up 2，胜率0.3，全部check(EV 4)
+	hands := func(betEV, checkEV float64) []*model.Record {
+		return []*model.Record{
+			{Hand: "AhAd", OopWeight: 1, Actions: []model.Action{
+				{Freq: 0.5, Ev: checkEV, Eq: 0.6, Matchup: 1},
+				{Freq: 0.5, Ev: betEV, Eq: 0.6, Matchup: 1},
+			}},
+			{Hand: "5h4h", OopWeight: 0.5, Actions: []model.Action{
+				{Freq: 1, Ev: 4, Eq: 0.3, Matchup: 2},
+				{Freq: 0, Ev: 8, Eq: 0.3, Matchup: 2},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name           string
+		records        []*model.Record
+		actorEV        float64
+		actorEQ        float64
+		checkEV, betEV float64
+	}{
+		// 行动方权重 = 1×1 + 0.5×2 = 2；EV = (1×1×15 + 0.5×2×4) / 2，胜率 = (0.6 + 0.5×2×0.3) / 2
+		// check EV = (1×1×0.5×10 + 0.5×2×1×4) / (0.5 + 1)，bet EV只有A贡献
+		{"全部有效", hands(20, 10), 9.5, 0.45, 6, 20},
+		// A的bet EV无效：A的整体EV只在check上平均为10，EV = (10 + 4) / 2
+		{"一个动作无效", hands(nan, 10), 7, 0.45, 6, 0},
+		// A的所有动作都无效：A不计入行动方的EV和胜率
+		{"全部动作无效", hands(nan, nan), 4, 0.3, 4, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := &model.NodeSummary{}
+			summarizeNode(summary, "OOP", actions, tt.records, nil, nil, nil)
+
+			near := func(got, want float64) bool { return math.Abs(got-want) < 1e-9 }
+			if !near(summary.ActorEv, tt.actorEV) || !near(summary.ActorEq, tt.actorEQ) {
+				t.Errorf("行动方EV/胜率 = %v/%v，应为 %v/%v", summary.ActorEv, summary.ActorEq, tt.actorEV, tt.actorEQ)
+			}
+			if !near(summary.ActorCombos, 1.5) {
+				t.Errorf("行动方手牌组合 = %v，应为 1.5", summary.ActorCombos)
+			}
+			if len(summary.Actions) != 2 {
+				t.Fatalf("汇总有 %d 个动作", len(summary.Actions))
+			}
+			// 动作频率不受EV是否有效影响：check = (0.5 + 0.5) / 1.5，bet = 0.5 / 1.5
+			check, bet := summary.Actions[0], summary.Actions[1]
+			if !near(check.Freq, 2.0/3) || !near(bet.Freq, 1.0/3) {
+				t.Errorf("动作频率 = %v/%v", check.Freq, bet.Freq)
+			}
+			if !near(check.Ev, tt.checkEV) || !near(bet.Ev, tt.betEV) {
+				t.Errorf("动作EV = %v/%v，应为 %v/%v", check.Ev, bet.Ev, tt.checkEV, tt.betEV)
+			}
+		})
+	}
+}